@@ -0,0 +1,304 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/xmidt-org/httpaux/roundtrip"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// DefaultRequestIDHeader is the header used to obtain a request's identifier
+	// when AccessLogConfig.RequestIDHeader is unset.
+	DefaultRequestIDHeader = "X-Request-Id"
+
+	// Redacted is the value logged in place of a redacted header.
+	Redacted = "REDACTED"
+)
+
+var (
+	// DefaultRedactHeaders are the headers whose values are never logged when
+	// AccessLogConfig.RedactHeaders is unset.
+	DefaultRedactHeaders = []string{
+		"Authorization",
+		"Proxy-Authorization",
+		"Cookie",
+		"Set-Cookie",
+	}
+)
+
+// AccessLogConfig is the unmarshaled configuration for structured access logs.  The same
+// configuration is used for both server and client access logs.
+type AccessLogConfig struct {
+	// Enabled turns on access logging when this configuration is part of a ServerConfig
+	// or ClientConfig.  The middleware methods of this type ignore this field.
+	Enabled bool `json:"enabled" yaml:"enabled"`
+
+	// Headers are the request headers whose values are included in each log entry.
+	Headers []string `json:"headers" yaml:"headers"`
+
+	// RedactHeaders are the headers whose values are replaced with Redacted.  If unset,
+	// DefaultRedactHeaders is used.
+	RedactHeaders []string `json:"redactHeaders" yaml:"redactHeaders"`
+
+	// RequestIDHeader is the header holding the request's identifier.  If unset,
+	// DefaultRequestIDHeader is used.  A request identifier stored in the request's
+	// context, e.g. by RequestIDConfig.ServerMiddleware, takes precedence.
+	RequestIDHeader string `json:"requestIDHeader" yaml:"requestIDHeader"`
+
+	// SampleInitial and SampleThereafter control zap sampling of access log entries.  Each
+	// second, the first SampleInitial entries are logged and every SampleThereafter entry
+	// after that.  If SampleInitial is unset, no sampling is done.
+	SampleInitial    int `json:"sampleInitial" yaml:"sampleInitial"`
+	SampleThereafter int `json:"sampleThereafter" yaml:"sampleThereafter"`
+}
+
+// accessLogFactory is implemented by factories that configure access logging,
+// such as ServerConfig and ClientConfig.
+type accessLogFactory interface {
+	accessLogConfig() AccessLogConfig
+}
+
+// accessLogger is the internal, immutable strategy built from an AccessLogConfig.
+type accessLogger struct {
+	logger          *zap.Logger
+	headers         []string
+	redact          map[string]bool
+	requestIDHeader string
+}
+
+func (alc AccessLogConfig) newAccessLogger(l *zap.Logger) *accessLogger {
+	if l == nil {
+		l = zap.NewNop()
+	}
+
+	if alc.SampleInitial > 0 {
+		l = l.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return zapcore.NewSamplerWithOptions(core, time.Second, alc.SampleInitial, alc.SampleThereafter)
+		}))
+	}
+
+	al := &accessLogger{
+		logger:          l,
+		redact:          make(map[string]bool),
+		requestIDHeader: alc.RequestIDHeader,
+	}
+
+	for _, h := range alc.Headers {
+		al.headers = append(al.headers, http.CanonicalHeaderKey(h))
+	}
+
+	redactHeaders := alc.RedactHeaders
+	if len(redactHeaders) == 0 {
+		redactHeaders = DefaultRedactHeaders
+	}
+
+	for _, h := range redactHeaders {
+		al.redact[http.CanonicalHeaderKey(h)] = true
+	}
+
+	if len(al.requestIDHeader) == 0 {
+		al.requestIDHeader = DefaultRequestIDHeader
+	}
+
+	return al
+}
+
+// headerFields is a zapcore.ObjectMarshaler for the logged headers.
+type headerFields struct {
+	al     *accessLogger
+	header http.Header
+}
+
+func (hf headerFields) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	for _, name := range hf.al.headers {
+		values := hf.header.Values(name)
+		switch {
+		case len(values) == 0:
+			continue
+
+		case hf.al.redact[name]:
+			enc.AddString(name, Redacted)
+
+		default:
+			enc.AddArray(name, zapcore.ArrayMarshalerFunc(func(ae zapcore.ArrayEncoder) error {
+				for _, v := range values {
+					ae.AppendString(v)
+				}
+
+				return nil
+			}))
+		}
+	}
+
+	return nil
+}
+
+// requestFields returns the log fields common to server and client requests.
+func (al *accessLogger) requestFields(request *http.Request) []zap.Field {
+	fields := make([]zap.Field, 0, 10)
+	fields = append(fields,
+		zap.String("method", request.Method),
+		zap.String("url", request.URL.Redacted()),
+		zap.String("proto", request.Proto),
+	)
+
+	if requestID, ok := GetRequestID(request.Context()); ok {
+		fields = append(fields, zap.String("requestID", requestID))
+	} else if requestID := request.Header.Get(al.requestIDHeader); len(requestID) > 0 {
+		fields = append(fields, zap.String("requestID", requestID))
+	}
+
+	if len(al.headers) > 0 {
+		fields = append(fields, zap.Object("headers", headerFields{al: al, header: request.Header}))
+	}
+
+	return fields
+}
+
+// tlsFields returns log fields describing a TLS connection, if any.
+func tlsFields(fields []zap.Field, cs *tls.ConnectionState) []zap.Field {
+	if cs != nil {
+		fields = append(fields,
+			zap.String("tlsVersion", tls.VersionName(cs.Version)),
+			zap.String("tlsCipherSuite", tls.CipherSuiteName(cs.CipherSuite)),
+			zap.String("tlsServerName", cs.ServerName),
+		)
+	}
+
+	return fields
+}
+
+// countingBody decorates a body to count the bytes read.  The onDone closure is
+// invoked exactly once, when the body is fully read or closed.
+type countingBody struct {
+	io.ReadCloser
+	count  int64
+	onDone func()
+}
+
+func (cb *countingBody) Read(p []byte) (n int, err error) {
+	n, err = cb.ReadCloser.Read(p)
+	cb.count += int64(n)
+	if err == io.EOF && cb.onDone != nil {
+		cb.onDone()
+	}
+
+	return
+}
+
+func (cb *countingBody) Close() error {
+	if cb.onDone != nil {
+		defer cb.onDone()
+	}
+
+	return cb.ReadCloser.Close()
+}
+
+// ServerMiddleware returns a server middleware that writes an access log entry to the given
+// logger for each request.  If l is nil, no logging is done.
+func (alc AccessLogConfig) ServerMiddleware(l *zap.Logger) func(http.Handler) http.Handler {
+	al := alc.newAccessLogger(l)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			var (
+				start        = time.Now()
+				headerTime   time.Time
+				ow           = newObservedWriter(response)
+				body         *countingBody
+				requestEntry = al.logger.Check(zapcore.InfoLevel, "http request")
+			)
+
+			if requestEntry == nil {
+				// sampled out or disabled
+				next.ServeHTTP(response, request)
+				return
+			}
+
+			if request.Body != nil && request.Body != http.NoBody {
+				body = &countingBody{ReadCloser: request.Body}
+				request.Body = body
+			}
+
+			ow.OnWriteHeader(func(int) {
+				headerTime = time.Now()
+			})
+
+			next.ServeHTTP(ow, request)
+
+			fields := al.requestFields(request)
+			fields = append(fields,
+				zap.String("remoteAddr", request.RemoteAddr),
+				zap.Int("status", ow.StatusCode()),
+				zap.Duration("duration", time.Since(start)),
+				zap.Int64("bytesWritten", ow.ContentLength()),
+			)
+
+			if !headerTime.IsZero() {
+				fields = append(fields, zap.Duration("headerDuration", headerTime.Sub(start)))
+			}
+
+			if body != nil {
+				fields = append(fields, zap.Int64("bytesRead", body.count))
+			}
+
+			requestEntry.Write(tlsFields(fields, request.TLS)...)
+		})
+	}
+}
+
+// ClientMiddleware returns a client middleware that writes an access log entry to the given
+// logger for each request.  For successful requests, the entry is written when the response
+// body is fully read or closed.  If l is nil, no logging is done.
+func (alc AccessLogConfig) ClientMiddleware(l *zap.Logger) func(http.RoundTripper) http.RoundTripper {
+	al := alc.newAccessLogger(l)
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundtrip.PreserveCloseIdler(
+			next,
+			roundtrip.Func(func(request *http.Request) (*http.Response, error) {
+				requestEntry := al.logger.Check(zapcore.InfoLevel, "http client request")
+				if requestEntry == nil {
+					return next.RoundTrip(request)
+				}
+
+				start := time.Now()
+				response, err := next.RoundTrip(request)
+				fields := append(
+					al.requestFields(request),
+					zap.Duration("headerDuration", time.Since(start)),
+				)
+
+				if err != nil {
+					requestEntry.Write(append(fields, zap.Error(err))...)
+					return response, err
+				}
+
+				fields = append(fields, zap.Int("status", response.StatusCode))
+				fields = tlsFields(fields, response.TLS)
+				if response.Body == nil {
+					requestEntry.Write(append(fields, zap.Duration("duration", time.Since(start)))...)
+					return response, err
+				}
+
+				body := &countingBody{ReadCloser: response.Body}
+				body.onDone = sync.OnceFunc(func() {
+					requestEntry.Write(append(fields,
+						zap.Duration("duration", time.Since(start)),
+						zap.Int64("bytesRead", body.count),
+					)...)
+				})
+
+				response.Body = keepWritable(body, response.Body)
+				return response, err
+			}),
+		)
+	}
+}
@@ -0,0 +1,241 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange"
+	"github.com/xmidt-org/arrange/arrangetest"
+	"github.com/xmidt-org/httpaux"
+	"github.com/xmidt-org/httpaux/roundtrip"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type AccessLogSuite struct {
+	suite.Suite
+	logger *zap.Logger
+	logs   *observer.ObservedLogs
+}
+
+func (suite *AccessLogSuite) SetupTest() {
+	var core zapcore.Core
+	core, suite.logs = observer.New(zapcore.InfoLevel)
+	suite.logger = zap.New(core)
+}
+
+func (suite *AccessLogSuite) SetupSubTest() {
+	suite.SetupTest()
+}
+
+// onlyEntry asserts that exactly one entry was logged and returns its fields.
+func (suite *AccessLogSuite) onlyEntry() map[string]any {
+	entries := suite.logs.TakeAll()
+	suite.Require().Len(entries, 1)
+	return entries[0].ContextMap()
+}
+
+func (suite *AccessLogSuite) TestServerMiddleware() {
+	alc := AccessLogConfig{
+		Headers: []string{"x-custom", "authorization", "x-missing"},
+	}
+
+	handler := alc.ServerMiddleware(suite.logger)(
+		http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			io.Copy(io.Discard, request.Body)
+			response.WriteHeader(299)
+			response.Write([]byte("hello"))
+		}),
+	)
+
+	request := httptest.NewRequest("POST", "/test?foo=bar", strings.NewReader("request body"))
+	request.Header.Set("X-Custom", "value")
+	request.Header.Set("Authorization", "Bearer secret")
+	request.Header.Set("X-Request-Id", "1234")
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	fields := suite.onlyEntry()
+	suite.Equal("POST", fields["method"])
+	suite.Equal("/test?foo=bar", fields["url"])
+	suite.Equal("1234", fields["requestID"])
+	suite.Equal(int64(299), fields["status"])
+	suite.Equal(int64(5), fields["bytesWritten"])
+	suite.Equal(int64(12), fields["bytesRead"])
+	suite.Equal(request.RemoteAddr, fields["remoteAddr"])
+	suite.Contains(fields, "duration")
+	suite.Contains(fields, "headerDuration")
+	suite.NotContains(fields, "tlsVersion")
+	suite.Equal(
+		map[string]any{
+			"X-Custom":      []any{"value"},
+			"Authorization": Redacted,
+		},
+		fields["headers"],
+	)
+}
+
+func (suite *AccessLogSuite) TestServerMiddlewareSampling() {
+	alc := AccessLogConfig{
+		SampleInitial:    2,
+		SampleThereafter: 1000,
+	}
+
+	handler := alc.ServerMiddleware(suite.logger)(httpaux.ConstantHandler{StatusCode: 200})
+	for i := 0; i < 10; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}
+
+	suite.Equal(2, suite.logs.Len())
+}
+
+func (suite *AccessLogSuite) TestServerMiddlewareNilLogger() {
+	handler := AccessLogConfig{}.ServerMiddleware(nil)(httpaux.ConstantHandler{StatusCode: 299})
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	suite.Equal(299, response.Code)
+}
+
+func (suite *AccessLogSuite) testClientMiddlewareSuccess() {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		response.WriteHeader(299)
+		response.Write([]byte("response body"))
+	}))
+
+	defer server.Close()
+	client := server.Client()
+	suite.Require().NoError(
+		ClientMiddleware(AccessLogConfig{}.ClientMiddleware(suite.logger)).Apply(client),
+	)
+
+	response, err := client.Get(server.URL + "/test")
+	suite.Require().NoError(err)
+	suite.Zero(suite.logs.Len(), "the entry should not be written until the body is consumed")
+
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+
+	fields := suite.onlyEntry()
+	suite.Equal("GET", fields["method"])
+	suite.Equal(int64(299), fields["status"])
+	suite.Equal(int64(13), fields["bytesRead"])
+	suite.Contains(fields, "tlsVersion")
+	suite.Contains(fields, "duration")
+	suite.Contains(fields, "headerDuration")
+}
+
+func (suite *AccessLogSuite) testClientMiddlewareError() {
+	expectedErr := errors.New("expected")
+	transport := AccessLogConfig{}.ClientMiddleware(suite.logger)(
+		roundtrip.Func(func(*http.Request) (*http.Response, error) {
+			return nil, expectedErr
+		}),
+	)
+
+	response, err := transport.RoundTrip(httptest.NewRequest("GET", "http://localhost/", nil))
+	suite.Nil(response)
+	suite.ErrorIs(err, expectedErr)
+
+	fields := suite.onlyEntry()
+	suite.Equal(expectedErr.Error(), fields["error"])
+}
+
+func (suite *AccessLogSuite) testClientMiddlewareUpgrade() {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	transport := AccessLogConfig{}.ClientMiddleware(suite.logger)(
+		roundtrip.Func(func(*http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusSwitchingProtocols, Body: conn}, nil
+		}),
+	)
+
+	response, err := transport.RoundTrip(httptest.NewRequest("GET", "http://localhost/", nil))
+	suite.Require().NoError(err)
+
+	rwc, ok := response.Body.(io.ReadWriteCloser)
+	suite.Require().True(ok, "the body of an upgraded response must be writable")
+
+	go io.WriteString(rwc, "ping")
+	ping := make([]byte, len("ping"))
+	_, err = io.ReadFull(peer, ping)
+	suite.Require().NoError(err)
+	suite.Equal("ping", string(ping))
+
+	rwc.Close()
+	fields := suite.onlyEntry()
+	suite.Equal(int64(http.StatusSwitchingProtocols), fields["status"])
+}
+
+func (suite *AccessLogSuite) TestClientMiddleware() {
+	suite.Run("Success", suite.testClientMiddlewareSuccess)
+	suite.Run("Error", suite.testClientMiddlewareError)
+	suite.Run("Upgrade", suite.testClientMiddlewareUpgrade)
+}
+
+func (suite *AccessLogSuite) TestProvide() {
+	var (
+		server *http.Server
+		client *http.Client
+	)
+
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(suite.logger),
+		fx.Supply(
+			fx.Annotated{
+				Name: "server.config",
+				Target: ServerConfig{
+					Address:   ":0",
+					AccessLog: AccessLogConfig{Enabled: true},
+				},
+			},
+			fx.Annotated{
+				Name: "client.config",
+				Target: ClientConfig{
+					AccessLog: AccessLogConfig{Enabled: true},
+				},
+			},
+		),
+		ProvideServer("server"),
+		ProvideClient("client"),
+		fx.Populate(
+			fx.Annotate(
+				&server,
+				arrange.Tags().Name("server").ParamTags(),
+			),
+			fx.Annotate(
+				&client,
+				arrange.Tags().Name("client").ParamTags(),
+			),
+		),
+	)
+
+	app.RequireStart()
+	defer app.RequireStop()
+
+	testServer := httptest.NewServer(server.Handler)
+	defer testServer.Close()
+
+	response, err := client.Get(testServer.URL)
+	suite.Require().NoError(err)
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+
+	suite.Equal(1, suite.logs.FilterMessage("http request").Len())
+	suite.Equal(1, suite.logs.FilterMessage("http client request").Len())
+}
+
+func TestAccessLog(t *testing.T) {
+	suite.Run(t, new(AccessLogSuite))
+}
@@ -0,0 +1,223 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"encoding/json"
+	"expvar"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/xmidt-org/arrange"
+	"github.com/xmidt-org/arrange/arrangepprof"
+	"go.uber.org/fx"
+)
+
+const (
+	// DefaultAdminHost is the host an admin server binds to when its address has no host.
+	DefaultAdminHost = "127.0.0.1"
+
+	// DefaultExpvarPath is the path of the expvar endpoint when AdminConfig.Expvar.Path is unset.
+	DefaultExpvarPath = "/debug/vars"
+
+	// DefaultBuildInfoPath is the path of the build information endpoint when
+	// AdminConfig.BuildInfo.Path is unset.
+	DefaultBuildInfoPath = "/debug/buildinfo"
+)
+
+// readBuildInfo is the source of build information.  Tests can replace this.
+var readBuildInfo = debug.ReadBuildInfo
+
+// AdminEndpoint is the unmarshaled configuration for one of an admin server's endpoints.
+// Endpoints are enabled by default.
+type AdminEndpoint struct {
+	// Disabled turns off this endpoint.
+	Disabled bool `json:"disabled" yaml:"disabled"`
+
+	// Path is the path of this endpoint.  Each endpoint has its own default.
+	Path string `json:"path" yaml:"path"`
+}
+
+// path returns the configured path, or def if no path is configured.
+func (ae AdminEndpoint) path(def string) string {
+	if len(ae.Path) > 0 {
+		return ae.Path
+	}
+
+	return def
+}
+
+// AdminConfig is the ServerFactory for admin servers.  All of ServerConfig's settings apply,
+// except for ServerConfig.Maintenance, but an address without a host binds to DefaultAdminHost
+// so that an admin server is only reachable from the loopback interface unless configured otherwise.
+type AdminConfig struct {
+	ServerConfig `yaml:",inline"`
+
+	// Pprof is the pprof endpoint, served under arrangepprof.DefaultPathPrefix by default.
+	Pprof AdminEndpoint `json:"pprof" yaml:"pprof"`
+
+	// Expvar is the expvar endpoint, served at DefaultExpvarPath by default.
+	Expvar AdminEndpoint `json:"expvar" yaml:"expvar"`
+
+	// Liveness is the liveness endpoint, served at DefaultLivenessPath by default.
+	// This endpoint requires a *Health.  See ProvideHealth.
+	Liveness AdminEndpoint `json:"liveness" yaml:"liveness"`
+
+	// Readiness is the readiness endpoint, served at DefaultReadinessPath by default.
+	// This endpoint requires a *Health.  See ProvideHealth.
+	Readiness AdminEndpoint `json:"readiness" yaml:"readiness"`
+
+	// MetricsEndpoint is the endpoint that exposes the Registry, served at DefaultMetricsPath
+	// by default.  This endpoint requires a Registry that is also an http.Handler, such as
+	// a *MetricsRegistry.
+	MetricsEndpoint AdminEndpoint `json:"metricsEndpoint" yaml:"metricsEndpoint"`
+
+	// BuildInfo is the build information endpoint, served at DefaultBuildInfoPath by default.
+	BuildInfo AdminEndpoint `json:"buildInfo" yaml:"buildInfo"`
+}
+
+// adminAddress binds an address without a host to DefaultAdminHost.
+func adminAddress(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	switch {
+	case len(address) == 0:
+		return net.JoinHostPort(DefaultAdminHost, "0")
+
+	case err != nil || len(host) > 0:
+		// let the listener report any problem with the address
+		return address
+
+	default:
+		return net.JoinHostPort(DefaultAdminHost, port)
+	}
+}
+
+// maintenanceConfig exempts admin servers from having a maintenance switch, since the
+// switches of other servers are typically mounted on the admin server.
+func (ac AdminConfig) maintenanceConfig() (MaintenanceConfig, bool) {
+	return MaintenanceConfig{}, false
+}
+
+// NewServer creates the admin *http.Server, binding to DefaultAdminHost if
+// the configured address has no host.
+func (ac AdminConfig) NewServer() (s *http.Server, err error) {
+	s, err = ac.ServerConfig.NewServer()
+	if err == nil {
+		s.Addr = adminAddress(s.Addr)
+	}
+
+	return
+}
+
+// BuildInfo is the build information served by an admin server.
+type BuildInfo struct {
+	GoVersion string            `json:"goVersion"`
+	Path      string            `json:"path"`
+	Version   string            `json:"version"`
+	Sum       string            `json:"sum,omitempty"`
+	Settings  map[string]string `json:"settings,omitempty"`
+}
+
+// BuildInfoHandler returns an http.Handler that serves the executable's build information,
+// as reported by debug.ReadBuildInfo, as JSON.
+func BuildInfoHandler() http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		info, ok := readBuildInfo()
+		if !ok {
+			http.Error(response, "No build information is available", http.StatusNotFound)
+			return
+		}
+
+		bi := BuildInfo{
+			GoVersion: info.GoVersion,
+			Path:      info.Path,
+			Version:   info.Main.Version,
+			Sum:       info.Main.Sum,
+			Settings:  make(map[string]string, len(info.Settings)),
+		}
+
+		for _, s := range info.Settings {
+			bi.Settings[s.Key] = s.Value
+		}
+
+		response.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(response).Encode(bi)
+	})
+}
+
+// adminRoutes builds the routes for the enabled admin endpoints.
+func adminRoutes(ac AdminConfig, h *Health, r Registry) (routes []Route) {
+	if !ac.Pprof.Disabled {
+		var (
+			prefix = ac.Pprof.path(arrangepprof.DefaultPathPrefix)
+			mux    = arrangepprof.HTTP{PathPrefix: prefix}.New()
+			root   = strings.TrimSuffix(prefix, "/")
+		)
+
+		// the mux serves the pprof index both with and without a trailing slash
+		routes = append(routes, Route{Pattern: root + "/", Handler: mux})
+		if len(root) > 0 {
+			routes = append(routes, Route{Pattern: root, Handler: mux})
+		}
+	}
+
+	if !ac.Expvar.Disabled {
+		routes = append(routes, Route{Pattern: ac.Expvar.path(DefaultExpvarPath), Handler: expvar.Handler()})
+	}
+
+	if h != nil && !ac.Liveness.Disabled {
+		routes = append(routes, Route{Pattern: ac.Liveness.path(DefaultLivenessPath), Handler: h.LivenessHandler()})
+	}
+
+	if h != nil && !ac.Readiness.Disabled {
+		routes = append(routes, Route{Pattern: ac.Readiness.path(DefaultReadinessPath), Handler: h.ReadinessHandler()})
+	}
+
+	if r == nil {
+		r = DefaultRegistry()
+	}
+
+	if rh, ok := r.(http.Handler); ok && !ac.MetricsEndpoint.Disabled {
+		routes = append(routes, Route{Pattern: ac.MetricsEndpoint.path(DefaultMetricsPath), Handler: rh})
+	}
+
+	if !ac.BuildInfo.Disabled {
+		routes = append(routes, Route{Pattern: ac.BuildInfo.path(DefaultBuildInfoPath), Handler: BuildInfoHandler()})
+	}
+
+	return
+}
+
+// ProvideAdminServer provides an admin server as a component named serverName.  The admin
+// server is created with ProvideServerCustom, using AdminConfig as the ServerFactory, so
+// everything that applies to ProvideServer applies here, except that the AdminConfig is the
+// optional serverName+".config" dependency.
+//
+// Each of the following endpoints is mounted as a Route unless disabled in the AdminConfig:
+//
+//   - pprof, via arrangepprof.HTTP
+//   - expvar
+//   - liveness and readiness, if a *Health is supplied.  See ProvideHealth.
+//   - the Registry, if it is an http.Handler.  If no Registry is supplied, DefaultRegistry is used.
+//   - build information from debug.ReadBuildInfo
+//
+// Additional routes can be contributed to the serverName+".routes" value group.
+func ProvideAdminServer(serverName string, external ...any) fx.Option {
+	return fx.Options(
+		fx.Provide(
+			fx.Annotate(
+				adminRoutes,
+				arrange.Tags().Push(serverName).
+					OptionalName("config").
+					Optional().
+					Optional().
+					ParamTags(),
+				arrange.Tags().Push(serverName).Group("routes,flatten").ResultTags(),
+			),
+		),
+		ProvideServerCustom[http.Handler, AdminConfig](serverName, external...),
+	)
+}
@@ -0,0 +1,197 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange"
+	"github.com/xmidt-org/arrange/arrangetest"
+	"go.uber.org/fx"
+)
+
+type AdminSuite struct {
+	suite.Suite
+}
+
+func (suite *AdminSuite) get(h http.Handler, target string) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, httptest.NewRequest("GET", target, nil))
+	return response
+}
+
+func (suite *AdminSuite) TestAdminAddress() {
+	testData := []struct {
+		address  string
+		expected string
+	}{
+		{"", "127.0.0.1:0"},
+		{":0", "127.0.0.1:0"},
+		{":9090", "127.0.0.1:9090"},
+		{"0.0.0.0:9090", "0.0.0.0:9090"},
+		{"localhost:9090", "localhost:9090"},
+		{"[::1]:9090", "[::1]:9090"},
+		{"invalid", "invalid"},
+	}
+
+	for _, record := range testData {
+		suite.Equal(record.expected, adminAddress(record.address), record.address)
+	}
+
+	s, err := AdminConfig{ServerConfig: ServerConfig{Address: ":8080"}}.NewServer()
+	suite.Require().NoError(err)
+	suite.Equal("127.0.0.1:8080", s.Addr)
+}
+
+func (suite *AdminSuite) TestBuildInfoHandler() {
+	defer func(original func() (*debug.BuildInfo, bool)) {
+		readBuildInfo = original
+	}(readBuildInfo)
+
+	readBuildInfo = func() (*debug.BuildInfo, bool) {
+		return &debug.BuildInfo{
+			GoVersion: "go1.99",
+			Path:      "example.com/cmd/service",
+			Main:      debug.Module{Version: "v1.2.3", Sum: "h1:abc"},
+			Settings:  []debug.BuildSetting{{Key: "vcs.revision", Value: "deadbeef"}},
+		}, true
+	}
+
+	response := suite.get(BuildInfoHandler(), "/")
+	suite.Equal(http.StatusOK, response.Code)
+	suite.Equal("application/json", response.Header().Get("Content-Type"))
+
+	var bi BuildInfo
+	suite.Require().NoError(json.Unmarshal(response.Body.Bytes(), &bi))
+	suite.Equal(
+		BuildInfo{
+			GoVersion: "go1.99",
+			Path:      "example.com/cmd/service",
+			Version:   "v1.2.3",
+			Sum:       "h1:abc",
+			Settings:  map[string]string{"vcs.revision": "deadbeef"},
+		},
+		bi,
+	)
+
+	readBuildInfo = func() (*debug.BuildInfo, bool) { return nil, false }
+	suite.Equal(http.StatusNotFound, suite.get(BuildInfoHandler(), "/").Code)
+}
+
+func (suite *AdminSuite) TestProvide() {
+	var (
+		server  *http.Server
+		capture = make(chan net.Addr, 1)
+		r       = NewMetricsRegistry()
+	)
+
+	r.Counter("test_total", "a test counter", Labels{"label": "value"}).Add(1)
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(fx.Annotate(r, fx.As(new(Registry)))),
+		fx.Provide(
+			fx.Annotate(
+				func() HealthCheck {
+					return HealthCheck{
+						Name:  "injected",
+						Check: func(context.Context) error { return nil },
+					}
+				},
+				arrange.Tags().Group(HealthChecksGroup).ResultTags(),
+			),
+			fx.Annotate(
+				func() Route {
+					return Route{
+						Pattern: "GET /custom",
+						Handler: http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+							response.WriteHeader(299)
+						}),
+					}
+				},
+				arrange.Tags().Group("admin.routes").ResultTags(),
+			),
+		),
+		ProvideHealth(),
+		ProvideAdminServer("admin", arrangetest.ListenCapture(capture)),
+		fx.Populate(
+			fx.Annotate(
+				&server,
+				arrange.Tags().Name("admin").ParamTags(),
+			),
+		),
+	)
+
+	app.RequireStart()
+	defer app.RequireStop()
+
+	addr := arrangetest.ListenReceive(suite, capture, 2*time.Second)
+	suite.True(addr.(*net.TCPAddr).IP.IsLoopback())
+
+	for _, path := range []string{"/debug/pprof", "/debug/pprof/", "/debug/pprof/cmdline", DefaultExpvarPath, DefaultLivenessPath, DefaultBuildInfoPath} {
+		suite.Equal(http.StatusOK, suite.get(server.Handler, path).Code, path)
+	}
+
+	response := suite.get(server.Handler, DefaultReadinessPath)
+	suite.Equal(http.StatusOK, response.Code)
+	suite.Contains(response.Body.String(), `"injected"`)
+
+	response = suite.get(server.Handler, DefaultMetricsPath)
+	suite.Equal(http.StatusOK, response.Code)
+	suite.Contains(response.Body.String(), `test_total{label="value"} 1`)
+
+	suite.Equal(299, suite.get(server.Handler, "/custom").Code)
+}
+
+func (suite *AdminSuite) TestProvideCustom() {
+	var server *http.Server
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			fx.Annotated{
+				Name: "admin.config",
+				Target: AdminConfig{
+					ServerConfig: ServerConfig{Address: ":0"},
+					Pprof:        AdminEndpoint{Path: "/pprof/"},
+					Expvar:       AdminEndpoint{Disabled: true},
+					BuildInfo:    AdminEndpoint{Path: "/version"},
+					MetricsEndpoint: AdminEndpoint{
+						Disabled: true,
+					},
+				},
+			},
+		),
+		ProvideAdminServer("admin"),
+		fx.Populate(
+			fx.Annotate(
+				&server,
+				arrange.Tags().Name("admin").ParamTags(),
+			),
+		),
+	)
+
+	app.RequireStart()
+	defer app.RequireStop()
+
+	suite.Equal("127.0.0.1:0", server.Addr)
+	for _, path := range []string{"/pprof", "/pprof/", "/version"} {
+		suite.Equal(http.StatusOK, suite.get(server.Handler, path).Code, path)
+	}
+
+	// disabled, or no *Health
+	for _, path := range []string{DefaultExpvarPath, DefaultMetricsPath, DefaultLivenessPath, DefaultReadinessPath, DefaultBuildInfoPath} {
+		suite.Equal(http.StatusNotFound, suite.get(server.Handler, path).Code, path)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	suite.Run(t, new(AdminSuite))
+}
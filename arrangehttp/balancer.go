@@ -0,0 +1,403 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xmidt-org/arrange/internal/arrangereflect"
+	"github.com/xmidt-org/httpaux/roundtrip"
+)
+
+const (
+	// RoundRobin is the balancing policy that cycles through endpoints in order.
+	// This is the default policy.
+	RoundRobin = "roundRobin"
+
+	// LeastInFlight is the balancing policy that selects the endpoint with the
+	// fewest outstanding requests.
+	LeastInFlight = "leastInFlight"
+
+	// RandomTwoChoices is the balancing policy that selects two endpoints at random
+	// and uses the one with fewer outstanding requests.
+	RandomTwoChoices = "randomTwoChoices"
+
+	// DefaultEjectionTime is the amount of time an endpoint is ejected when
+	// BalancerConfig.EjectionTime is unset.
+	DefaultEjectionTime = 30 * time.Second
+
+	// DefaultProbeInterval is the interval between active health probes when
+	// BalancerConfig.ProbeInterval is unset.
+	DefaultProbeInterval = 10 * time.Second
+)
+
+var (
+	// ErrNoEndpoints indicates that a BalancerConfig had no endpoints.
+	ErrNoEndpoints = errors.New("At least one endpoint is required")
+)
+
+// BalancerConfig is the unmarshaled configuration for client-side load balancing.
+type BalancerConfig struct {
+	// Endpoints are the base URLs of each endpoint, e.g. "http://host1:8080".  Only the
+	// scheme and host of each endpoint are used.  If empty, no load balancing is done.
+	Endpoints []string `json:"endpoints" yaml:"endpoints"`
+
+	// Policy is the balancing policy, one of RoundRobin, LeastInFlight, or RandomTwoChoices.
+	// If unset, RoundRobin is used.
+	Policy string `json:"policy" yaml:"policy"`
+
+	// MaxFailures is the number of consecutive failures after which an endpoint is ejected.
+	// A failure is either a transport error or a 5xx response.  If unset, endpoints are
+	// never ejected due to failures.
+	MaxFailures int `json:"maxFailures" yaml:"maxFailures"`
+
+	// EjectionTime is how long an endpoint stays ejected.  If unset, DefaultEjectionTime is used.
+	EjectionTime time.Duration `json:"ejectionTime" yaml:"ejectionTime"`
+
+	// ProbePath is the path requested on each endpoint to determine its health.  If unset,
+	// no active health probing is done.  Any 2xx response marks the endpoint as healthy.
+	ProbePath string `json:"probePath" yaml:"probePath"`
+
+	// ProbeInterval is the time between health probes.  If unset, DefaultProbeInterval is used.
+	ProbeInterval time.Duration `json:"probeInterval" yaml:"probeInterval"`
+
+	// ProbeTimeout is the timeout for each individual health probe.  If unset, ProbeInterval is used.
+	ProbeTimeout time.Duration `json:"probeTimeout" yaml:"probeTimeout"`
+}
+
+// NewBalancer creates a Balancer that sends requests through the given transport.
+// If next is nil, http.DefaultTransport is used.
+func (bc BalancerConfig) NewBalancer(next http.RoundTripper) (b *Balancer, err error) {
+	if len(bc.Endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+
+	b = &Balancer{
+		next:          arrangereflect.Safe[http.RoundTripper](next, http.DefaultTransport),
+		endpoints:     make([]*endpoint, 0, len(bc.Endpoints)),
+		maxFailures:   bc.MaxFailures,
+		ejectionTime:  bc.EjectionTime,
+		probePath:     bc.ProbePath,
+		probeInterval: bc.ProbeInterval,
+		probeTimeout:  bc.ProbeTimeout,
+		now:           time.Now,
+	}
+
+	switch bc.Policy {
+	case "", RoundRobin:
+		b.pick = b.pickRoundRobin
+
+	case LeastInFlight:
+		b.pick = b.pickLeastInFlight
+
+	case RandomTwoChoices:
+		b.pick = b.pickRandomTwoChoices
+
+	default:
+		return nil, fmt.Errorf("Invalid balancing policy: %s", bc.Policy)
+	}
+
+	if b.ejectionTime <= 0 {
+		b.ejectionTime = DefaultEjectionTime
+	}
+
+	if b.probeInterval <= 0 {
+		b.probeInterval = DefaultProbeInterval
+	}
+
+	if b.probeTimeout <= 0 {
+		b.probeTimeout = b.probeInterval
+	}
+
+	for _, e := range bc.Endpoints {
+		u, parseErr := url.Parse(e)
+		if parseErr != nil {
+			return nil, parseErr
+		} else if len(u.Scheme) == 0 || len(u.Host) == 0 {
+			return nil, fmt.Errorf("Invalid endpoint: %s", e)
+		}
+
+		b.endpoints = append(b.endpoints, &endpoint{
+			scheme: u.Scheme,
+			host:   u.Host,
+		})
+	}
+
+	return
+}
+
+// Apply allows a BalancerConfig to be used as an Option[http.Client].  If there are
+// no configured endpoints, this method does nothing.
+func (bc BalancerConfig) Apply(c *http.Client) error {
+	_, err := bc.apply(c)
+	return err
+}
+
+// apply decorates a client's transport with a Balancer, returning that Balancer.  If there
+// are no configured endpoints, this method does nothing and returns a nil Balancer.
+func (bc BalancerConfig) apply(c *http.Client) (b *Balancer, err error) {
+	if len(bc.Endpoints) > 0 {
+		b, err = bc.NewBalancer(c.Transport)
+		if err == nil {
+			c.Transport = b
+		}
+	}
+
+	return
+}
+
+// endpoint holds the balancing state for a single endpoint.
+type endpoint struct {
+	scheme string
+	host   string
+
+	inFlight atomic.Int64
+
+	lock         sync.Mutex
+	failures     int
+	ejectedUntil time.Time
+	unhealthy    bool
+}
+
+// available tests if this endpoint should receive requests.
+func (e *endpoint) available(now time.Time) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return !e.unhealthy && !now.Before(e.ejectedUntil)
+}
+
+// Balancer is an http.RoundTripper that spreads requests over several endpoints.  Each
+// request's scheme and host are rewritten to that of the selected endpoint.
+//
+// Endpoints that fail consecutively are ejected for a period of time.  If configured,
+// endpoints are also actively probed for health.  When no endpoint is available, a
+// Balancer falls back to using all endpoints.
+//
+// Active health probing runs in the background.  It starts when the enclosing fx.App starts,
+// for a client created by ProvideClient, or otherwise with the first request.  Endpoints are
+// considered healthy until they are first probed.  Probing stops for good when
+// CloseIdleConnections is called, which happens when a client bound with BindClient is stopped.
+type Balancer struct {
+	next      http.RoundTripper
+	endpoints []*endpoint
+	pick      func([]*endpoint) *endpoint
+	counter   atomic.Uint64
+
+	maxFailures  int
+	ejectionTime time.Duration
+
+	probePath     string
+	probeInterval time.Duration
+	probeTimeout  time.Duration
+	probeLock     sync.Mutex
+	probeCancel   context.CancelFunc
+	probeStopped  bool
+
+	now func() time.Time
+}
+
+var _ http.RoundTripper = (*Balancer)(nil)
+var _ roundtrip.CloseIdler = (*Balancer)(nil)
+
+func (b *Balancer) pickRoundRobin(candidates []*endpoint) *endpoint {
+	return candidates[b.counter.Add(1)%uint64(len(candidates))]
+}
+
+func (b *Balancer) pickLeastInFlight(candidates []*endpoint) *endpoint {
+	// start at a rotating offset so that ties are spread out
+	offset := b.counter.Add(1)
+	choice := candidates[offset%uint64(len(candidates))]
+	for i := 1; i < len(candidates); i++ {
+		c := candidates[(offset+uint64(i))%uint64(len(candidates))]
+		if c.inFlight.Load() < choice.inFlight.Load() {
+			choice = c
+		}
+	}
+
+	return choice
+}
+
+func (b *Balancer) pickRandomTwoChoices(candidates []*endpoint) *endpoint {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	i := rand.IntN(len(candidates))     // #nosec G404 -- load balancing doesn't require a CSPRNG
+	j := rand.IntN(len(candidates) - 1) // #nosec G404
+	if j >= i {
+		j++
+	}
+
+	if candidates[j].inFlight.Load() < candidates[i].inFlight.Load() {
+		return candidates[j]
+	}
+
+	return candidates[i]
+}
+
+// nextEndpoint selects the endpoint for the next request.
+func (b *Balancer) nextEndpoint() *endpoint {
+	now := b.now()
+	candidates := make([]*endpoint, 0, len(b.endpoints))
+	for _, e := range b.endpoints {
+		if e.available(now) {
+			candidates = append(candidates, e)
+		}
+	}
+
+	if len(candidates) == 0 {
+		candidates = b.endpoints
+	}
+
+	return b.pick(candidates)
+}
+
+// record updates the passive outlier detection state for an endpoint.
+func (b *Balancer) record(e *endpoint, failed bool) {
+	if b.maxFailures <= 0 {
+		return
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if !failed {
+		e.failures = 0
+		return
+	}
+
+	e.failures++
+	if e.failures >= b.maxFailures {
+		e.failures = 0
+		e.ejectedUntil = b.now().Add(b.ejectionTime)
+	}
+}
+
+// RoundTrip sends the request to the next endpoint.  The original request is not modified.
+func (b *Balancer) RoundTrip(request *http.Request) (*http.Response, error) {
+	b.startProbing()
+
+	e := b.nextEndpoint()
+	outbound := request.Clone(request.Context())
+	outbound.URL.Scheme = e.scheme
+	outbound.URL.Host = e.host
+	outbound.Host = ""
+
+	e.inFlight.Add(1)
+	response, err := b.next.RoundTrip(outbound)
+	b.record(e, err != nil || response.StatusCode >= 500)
+
+	if err != nil || response.Body == nil {
+		e.inFlight.Add(-1)
+		return response, err
+	}
+
+	response.Body = newTrackedBody(response.Body, func() {
+		e.inFlight.Add(-1)
+	})
+
+	return response, nil
+}
+
+// CloseIdleConnections stops any active health probing and closes idle
+// connections on the decorated transport.  Probing does not restart afterward.
+func (b *Balancer) CloseIdleConnections() {
+	b.stopProbing()
+	roundtrip.CloseIdleConnections(b.next)
+}
+
+// startProbing starts active health probing in the background if it is configured
+// and has neither started nor been stopped.
+func (b *Balancer) startProbing() {
+	if len(b.probePath) == 0 {
+		return
+	}
+
+	b.probeLock.Lock()
+	defer b.probeLock.Unlock()
+	if b.probeCancel == nil && !b.probeStopped {
+		var ctx context.Context
+		ctx, b.probeCancel = context.WithCancel(context.Background())
+		go b.probeLoop(ctx)
+	}
+}
+
+// stopProbing stops any active health probing and prevents it from starting again.
+func (b *Balancer) stopProbing() {
+	b.probeLock.Lock()
+	defer b.probeLock.Unlock()
+	if b.probeCancel != nil {
+		b.probeCancel()
+	}
+
+	b.probeStopped = true
+}
+
+func (b *Balancer) probeLoop(ctx context.Context) {
+	ticker := time.NewTicker(b.probeInterval)
+	defer ticker.Stop()
+
+	for {
+		b.probeAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeAll concurrently probes each endpoint, waiting for all probes to complete.
+func (b *Balancer) probeAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, e := range b.endpoints {
+		wg.Add(1)
+		go func(e *endpoint) {
+			defer wg.Done()
+			healthy := b.probe(ctx, e)
+			if ctx.Err() != nil {
+				// probing was stopped, so the result says nothing about the endpoint
+				return
+			}
+
+			e.lock.Lock()
+			e.unhealthy = !healthy
+			e.lock.Unlock()
+		}(e)
+	}
+
+	wg.Wait()
+}
+
+func (b *Balancer) probe(ctx context.Context, e *endpoint) bool {
+	ctx, cancel := context.WithTimeout(ctx, b.probeTimeout)
+	defer cancel()
+
+	u := url.URL{
+		Scheme: e.scheme,
+		Host:   e.host,
+		Path:   b.probePath,
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false
+	}
+
+	response, err := b.next.RoundTrip(request)
+	if err != nil {
+		return false
+	}
+
+	response.Body.Close()
+	return response.StatusCode >= 200 && response.StatusCode < 300
+}
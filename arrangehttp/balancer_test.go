@@ -0,0 +1,378 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+)
+
+// balancerBackend is a test server that counts the requests it receives.
+type balancerBackend struct {
+	*httptest.Server
+	requests   atomic.Int32
+	probes     atomic.Int32
+	statusCode atomic.Int32
+	healthy    atomic.Bool
+}
+
+func newBalancerBackend() *balancerBackend {
+	bb := new(balancerBackend)
+	bb.statusCode.Store(http.StatusOK)
+	bb.healthy.Store(true)
+	bb.Server = httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/health" {
+			bb.probes.Add(1)
+			if !bb.healthy.Load() {
+				response.WriteHeader(http.StatusServiceUnavailable)
+			}
+
+			return
+		}
+
+		bb.requests.Add(1)
+		response.WriteHeader(int(bb.statusCode.Load()))
+	}))
+
+	return bb
+}
+
+type BalancerSuite struct {
+	suite.Suite
+	backends []*balancerBackend
+}
+
+func (suite *BalancerSuite) SetupTest() {
+	suite.backends = []*balancerBackend{
+		newBalancerBackend(),
+		newBalancerBackend(),
+		newBalancerBackend(),
+	}
+}
+
+func (suite *BalancerSuite) SetupSubTest() {
+	suite.TearDownSubTest()
+	suite.SetupTest()
+}
+
+func (suite *BalancerSuite) TearDownTest() {
+	for _, bb := range suite.backends {
+		bb.Close()
+	}
+
+	suite.backends = nil
+}
+
+func (suite *BalancerSuite) TearDownSubTest() {
+	suite.TearDownTest()
+}
+
+func (suite *BalancerSuite) endpoints() (e []string) {
+	for _, bb := range suite.backends {
+		e = append(e, bb.URL)
+	}
+
+	return
+}
+
+func (suite *BalancerSuite) newClient(bc BalancerConfig) *http.Client {
+	c := new(http.Client)
+	suite.Require().NoError(bc.Apply(c))
+	suite.Require().IsType((*Balancer)(nil), c.Transport)
+	return c
+}
+
+func (suite *BalancerSuite) send(c *http.Client, count int) {
+	for i := 0; i < count; i++ {
+		// the host here is always rewritten
+		response, err := c.Get("http://inventory/test")
+		suite.Require().NoError(err)
+		io.Copy(io.Discard, response.Body)
+		response.Body.Close()
+	}
+}
+
+func (suite *BalancerSuite) requests() (r []int32) {
+	for _, bb := range suite.backends {
+		r = append(r, bb.requests.Load())
+	}
+
+	return
+}
+
+func (suite *BalancerSuite) TestNewBalancerErrors() {
+	testCases := []struct {
+		name string
+		bc   BalancerConfig
+	}{
+		{
+			name: "NoEndpoints",
+		},
+		{
+			name: "InvalidPolicy",
+			bc: BalancerConfig{
+				Endpoints: []string{"http://localhost:8080"},
+				Policy:    "nosuch",
+			},
+		},
+		{
+			name: "MissingHost",
+			bc: BalancerConfig{
+				Endpoints: []string{"/relative"},
+			},
+		},
+		{
+			name: "UnparseableEndpoint",
+			bc: BalancerConfig{
+				Endpoints: []string{"http://[::1"},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		suite.Run(testCase.name, func() {
+			b, err := testCase.bc.NewBalancer(nil)
+			suite.Error(err)
+			suite.Nil(b)
+		})
+	}
+}
+
+func (suite *BalancerSuite) TestApplyNoEndpoints() {
+	c := new(http.Client)
+	suite.NoError(BalancerConfig{}.Apply(c))
+	suite.Nil(c.Transport)
+}
+
+func (suite *BalancerSuite) TestRoundRobin() {
+	c := suite.newClient(BalancerConfig{
+		Endpoints: suite.endpoints(),
+	})
+
+	suite.send(c, 9)
+	suite.Equal([]int32{3, 3, 3}, suite.requests())
+}
+
+func (suite *BalancerSuite) TestLeastInFlight() {
+	c := suite.newClient(BalancerConfig{
+		Endpoints: suite.endpoints(),
+		Policy:    LeastInFlight,
+	})
+
+	// hold open a response to the first endpoint chosen
+	response, err := c.Get("http://inventory/test")
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+
+	held := suite.requests()
+	suite.send(c, 10)
+	for i, r := range suite.requests() {
+		if held[i] > 0 {
+			suite.Equal(int32(1), r, "the busy endpoint should not have been chosen")
+		} else {
+			suite.Positive(r)
+		}
+	}
+}
+
+func (suite *BalancerSuite) TestRandomTwoChoices() {
+	c := suite.newClient(BalancerConfig{
+		Endpoints: suite.endpoints(),
+		Policy:    RandomTwoChoices,
+	})
+
+	suite.send(c, 60)
+	for _, r := range suite.requests() {
+		suite.Positive(r)
+	}
+}
+
+func (suite *BalancerSuite) TestOutlierEjection() {
+	c := suite.newClient(BalancerConfig{
+		Endpoints:    suite.endpoints(),
+		MaxFailures:  2,
+		EjectionTime: time.Hour,
+	})
+
+	suite.backends[1].statusCode.Store(http.StatusInternalServerError)
+	suite.send(c, 12)
+	suite.Equal(int32(2), suite.backends[1].requests.Load())
+	suite.Equal(int32(10), suite.backends[0].requests.Load()+suite.backends[2].requests.Load())
+}
+
+func (suite *BalancerSuite) TestAllEjected() {
+	c := suite.newClient(BalancerConfig{
+		Endpoints:    suite.endpoints(),
+		MaxFailures:  1,
+		EjectionTime: time.Hour,
+	})
+
+	for _, bb := range suite.backends {
+		bb.statusCode.Store(http.StatusBadGateway)
+	}
+
+	// every endpoint gets ejected, but the balancer keeps sending requests
+	suite.send(c, 6)
+	suite.Equal([]int32{2, 2, 2}, suite.requests())
+}
+
+func (suite *BalancerSuite) TestActiveProbing() {
+	suite.backends[0].healthy.Store(false)
+	c := suite.newClient(BalancerConfig{
+		Endpoints:     suite.endpoints(),
+		ProbePath:     "/health",
+		ProbeInterval: 20 * time.Millisecond,
+	})
+
+	defer c.CloseIdleConnections()
+
+	// the first request starts probing in the background, and the unhealthy
+	// endpoint stops receiving requests once it has been probed
+	suite.Eventually(
+		func() bool {
+			before := suite.backends[0].requests.Load()
+			suite.send(c, 3)
+			return suite.backends[0].probes.Load() > 0 && suite.backends[0].requests.Load() == before
+		},
+		time.Second,
+		30*time.Millisecond,
+	)
+
+	suite.backends[0].healthy.Store(true)
+	suite.Eventually(
+		func() bool {
+			suite.send(c, 3)
+			return suite.backends[0].requests.Load() > 0
+		},
+		time.Second,
+		30*time.Millisecond,
+	)
+}
+
+func (suite *BalancerSuite) TestProbingDoesNotBlock() {
+	// a backend whose health endpoint never responds
+	hung := make(chan struct{})
+	dead := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/health" {
+			<-hung
+		}
+	}))
+
+	defer dead.Close()
+	defer close(hung)
+
+	c := suite.newClient(BalancerConfig{
+		Endpoints:     []string{dead.URL, suite.backends[0].URL},
+		ProbePath:     "/health",
+		ProbeInterval: time.Hour,
+	})
+
+	defer c.CloseIdleConnections()
+
+	start := time.Now()
+	suite.send(c, 4)
+	suite.Less(time.Since(start), time.Second)
+	suite.Equal(int32(2), suite.backends[0].requests.Load())
+}
+
+func (suite *BalancerSuite) TestProbingStops() {
+	c := suite.newClient(BalancerConfig{
+		Endpoints:     suite.endpoints(),
+		ProbePath:     "/health",
+		ProbeInterval: 10 * time.Millisecond,
+	})
+
+	suite.send(c, 1)
+	suite.Eventually(
+		func() bool { return suite.backends[0].probes.Load() > 0 },
+		time.Second,
+		10*time.Millisecond,
+	)
+
+	c.CloseIdleConnections()
+	time.Sleep(50 * time.Millisecond) // let any in-flight probe finish
+
+	// requests after CloseIdleConnections do not restart probing
+	suite.send(c, 3)
+	probes := suite.backends[0].probes.Load()
+	time.Sleep(100 * time.Millisecond)
+	suite.Equal(probes, suite.backends[0].probes.Load())
+}
+
+func (suite *BalancerSuite) TestProvideClient() {
+	var c *http.Client
+	app := fxtest.New(
+		suite.T(),
+		fx.Supply(
+			fx.Annotated{
+				Name: "client.config",
+				Target: ClientConfig{
+					Balancer: BalancerConfig{
+						Endpoints:     suite.endpoints(),
+						ProbePath:     "/health",
+						ProbeInterval: 10 * time.Millisecond,
+					},
+				},
+			},
+		),
+		ProvideClient("client"),
+		fx.Populate(
+			fx.Annotate(
+				&c,
+				arrange.Tags().Name("client").ParamTags(),
+			),
+		),
+	)
+
+	suite.Require().NotNil(c)
+	suite.Zero(suite.backends[0].probes.Load())
+
+	// probing starts with the application, before any request is sent
+	app.RequireStart()
+	suite.Eventually(
+		func() bool { return suite.backends[0].probes.Load() > 0 },
+		time.Second,
+		10*time.Millisecond,
+	)
+
+	app.RequireStop()
+	time.Sleep(50 * time.Millisecond) // let any in-flight probe finish
+	probes := suite.backends[0].probes.Load()
+	time.Sleep(100 * time.Millisecond)
+	suite.Equal(probes, suite.backends[0].probes.Load())
+	suite.Zero(suite.backends[0].requests.Load())
+}
+
+func (suite *BalancerSuite) TestClientConfig() {
+	cc := ClientConfig{
+		Header: http.Header{
+			"Custom": []string{"true"},
+		},
+		Balancer: BalancerConfig{
+			Endpoints: suite.endpoints(),
+		},
+	}
+
+	c, err := NewClient(cc)
+	suite.Require().NoError(err)
+	suite.send(c, 3)
+	suite.Equal([]int32{1, 1, 1}, suite.requests())
+
+	cc.Balancer.Policy = "nosuch"
+	_, err = NewClient(cc)
+	suite.Error(err)
+}
+
+func TestBalancer(t *testing.T) {
+	suite.Run(t, new(BalancerSuite))
+}
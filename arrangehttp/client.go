@@ -6,9 +6,11 @@ package arrangehttp
 import (
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/xmidt-org/arrange"
 	"go.uber.org/fx"
+	"go.uber.org/zap"
 )
 
 var (
@@ -43,6 +45,107 @@ func NewClientCustom[F ClientFactory](cf F, opts ...Option[http.Client]) (c *htt
 	return
 }
 
+// clientProvider is an internal strategy for managing a client's lifecycle within an
+// enclosing fx.App.
+type clientProvider[F ClientFactory] struct {
+	clientName string
+
+	// options are the externally supplied options.  These are not injected, but are
+	// supplied via the ProvideXXX call.
+	options []Option[http.Client]
+}
+
+// newClient is the client constructor function.  The returned client is bound to
+// the enclosing fx.App's lifecycle via BindClient.
+func (cp clientProvider[F]) newClient(lc fx.Lifecycle, cf F, logger *zap.Logger, r Registry, e SpanExporter, injected ...Option[http.Client]) (c *http.Client, err error) {
+	var metrics func(http.RoundTripper) http.RoundTripper
+	if mf, ok := any(cf).(metricsFactory); ok {
+		if mc := mf.metricsConfig(); mc.Enabled {
+			if r == nil {
+				r = DefaultRegistry()
+			}
+
+			metrics = mc.ClientMiddleware(WithLabels(r, Labels{"client": cp.clientName}))
+		}
+	}
+
+	var b *Balancer
+	if cc, ok := any(cf).(ClientConfig); ok {
+		// equivalent to NewClientCustom, but with access to any Balancer the config installs
+		c, err = cc.NewClient()
+		if err == nil {
+			c, err = ApplyOptions(c, injected...)
+		}
+
+		if err == nil && metrics != nil {
+			// metrics are recorded beneath any Balancer, so that each request is
+			// attributed to the endpoint it was actually sent to
+			c.Transport = metrics(c.Transport)
+			metrics = nil
+		}
+
+		if err == nil {
+			b, err = cc.applyBalanced(c)
+		}
+	} else {
+		c, err = NewClientCustom(cf, injected...)
+	}
+
+	if err == nil {
+		c, err = ApplyOptions(c, cp.options...)
+	}
+
+	if alf, ok := any(cf).(accessLogFactory); ok && err == nil && logger != nil {
+		if alc := alf.accessLogConfig(); alc.Enabled {
+			c, err = ApplyOptions(c, ClientMiddleware(alc.ClientMiddleware(logger)))
+		}
+	}
+
+	if err == nil && metrics != nil {
+		c, err = ApplyOptions(c, ClientMiddleware(metrics))
+	}
+
+	if tf, ok := any(cf).(traceFactory); ok && err == nil {
+		if tc := tf.traceConfig(); tc.Enabled {
+			c, err = ApplyOptions(c, ClientMiddleware(tc.ClientMiddleware(e)))
+		}
+	}
+
+	if err == nil {
+		var requestIDHeader string
+		if rif, ok := any(cf).(requestIDHeaderFactory); ok {
+			requestIDHeader = rif.requestIDHeader()
+		}
+
+		c, err = ApplyOptions(c, ClientMiddleware(RequestIDClientMiddleware(requestIDHeader)))
+	}
+
+	if err == nil {
+		var stopTimeout time.Duration
+		if stf, ok := any(cf).(stopTimeoutFactory); ok {
+			stopTimeout = stf.clientStopTimeout()
+		}
+
+		BindClient(lc, c, stopTimeout)
+		if b != nil {
+			// active health probing runs while the enclosing fx.App is running
+			lc.Append(fx.StartStopHook(b.startProbing, b.stopProbing))
+		}
+	}
+
+	return
+}
+
+// newJSONClient is the constructor for the JSONClient that accompanies each client.
+func (cp clientProvider[F]) newJSONClient(c *http.Client, cf F) (*JSONClient, error) {
+	var baseURL string
+	if buf, ok := any(cf).(baseURLFactory); ok {
+		baseURL = buf.clientBaseURL()
+	}
+
+	return NewJSONClient(c, baseURL)
+}
+
 // ProvideClient assembles a client out of application components in a standard, opinionated way.
 // The clientName parameter is used as both the name of the *http.Client component and a prefix
 // for that server's dependencies:
@@ -50,29 +153,65 @@ func NewClientCustom[F ClientFactory](cf F, opts ...Option[http.Client]) (c *htt
 //   - NewClient is used to create the client as a component named clientName
 //   - ClientConfig is an optional dependency with the name clientName+".config"
 //   - []ClientOption is an value group dependency with the name clientName+".options"
+//   - *zap.Logger is an optional, unnamed dependency used for access logging
+//   - Registry is an optional, unnamed dependency used for ClientConfig.Metrics.  If not
+//     supplied, DefaultRegistry is used.  Metrics are labeled with the client name.  When
+//     the ClientFactory is ClientConfig, metrics are recorded for each request as it is
+//     sent, i.e. after ClientConfig.Balancer selects an endpoint.
+//   - SpanExporter is an optional, unnamed dependency that records client spans due to
+//     ClientConfig.Trace.  See JSONLinesExporter.
+//
+// A *JSONClient that uses the *http.Client is also provided as a component named
+// clientName+".json".  Its base URL is ClientConfig.BaseURL.
+//
+// Each client propagates the request identifier from an outgoing request's context, as
+// stored by RequestIDConfig.ServerMiddleware, onto ClientConfig.RequestIDHeader.
 //
 // The external set of options, if supplied, is applied to the client after any injected options.
 // This allows for options that come from outside the enclosing fx.App, as might be the case
 // for options driven by the command line.
+//
+// The client is bound to the enclosing fx.App's lifecycle via BindClient.  Once the fx.App
+// stops, the client rejects requests with ErrClientStopped.  ClientConfig.StopTimeout controls
+// how long the fx.App waits for outstanding requests.  When the ClientFactory is ClientConfig,
+// active health probing due to ClientConfig.Balancer starts when the fx.App starts.
 func ProvideClient(clientName string, external ...Option[http.Client]) fx.Option {
 	return ProvideClientCustom[ClientConfig](clientName, external...)
 }
 
 // ProvideClientCustom is like ProvideClient, but it allows customization of the concrete
-// ClientFactory dependency.
+// ClientFactory dependency.  A custom ClientFactory that embeds ClientConfig will honor
+// ClientConfig.StopTimeout.
 func ProvideClientCustom[F ClientFactory](clientName string, external ...Option[http.Client]) fx.Option {
 	if len(clientName) == 0 {
 		return fx.Error(ErrClientNameRequired)
 	}
 
+	cp := clientProvider[F]{
+		clientName: clientName,
+		options:    append([]Option[http.Client]{}, external...),
+	}
+
 	return fx.Provide(
 		fx.Annotate(
-			NewClientCustom[F],
+			cp.newClient,
 			arrange.Tags().
+				Skip().
 				OptionalName(clientName+".config").
+				Optional().
+				Optional().
+				Optional().
 				Group(clientName+".options").
 				ParamTags(),
 			arrange.Tags().Name(clientName).ResultTags(),
 		),
+		fx.Annotate(
+			cp.newJSONClient,
+			arrange.Tags().
+				Name(clientName).
+				OptionalName(clientName+".config").
+				ParamTags(),
+			arrange.Tags().Name(clientName+".json").ResultTags(),
+		),
 	)
 }
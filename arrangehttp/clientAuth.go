@@ -0,0 +1,417 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xmidt-org/arrange/internal/arrangereflect"
+	"github.com/xmidt-org/httpaux/roundtrip"
+)
+
+const (
+	// DefaultTokenFileRefresh is how often a bearer token file is reread when
+	// BearerFileConfig.RefreshInterval is unset.
+	DefaultTokenFileRefresh = time.Minute
+
+	// DefaultTokenExpiryDelta is how long before its actual expiry an OAuth2 token
+	// is refreshed when OAuth2Config.ExpiryDelta is unset.
+	DefaultTokenExpiryDelta = 10 * time.Second
+)
+
+var (
+	// ErrMultipleAuthMethods indicates that more than one authentication method
+	// was configured in an AuthConfig.
+	ErrMultipleAuthMethods = errors.New("Only one client authentication method may be configured")
+
+	// ErrTokenFileEmpty indicates that a bearer token file contained no token.
+	ErrTokenFileEmpty = errors.New("The token file is empty")
+)
+
+// Token is a credential used to authorize outgoing requests.
+type Token struct {
+	// Value is the credential written after the scheme in the Authorization header.
+	Value string
+
+	// Expiry is the time after which this token should no longer be used.  A zero
+	// Expiry means the token does not expire.
+	Expiry time.Time
+}
+
+// TokenSource is the strategy for obtaining tokens for outgoing requests.
+type TokenSource interface {
+	// Token returns the token to use for a request.
+	Token(context.Context) (Token, error)
+}
+
+// TokenInvalidator is optionally implemented by a TokenSource that can discard a rejected
+// token.  TokenAuth uses this interface to refresh tokens when a server responds with 401.
+type TokenInvalidator interface {
+	// Invalidate discards the given token, if it is still current.  The next call to Token
+	// obtains a new token.
+	Invalidate(Token)
+}
+
+// StaticTokenSource is a TokenSource that always returns the same token.
+type StaticTokenSource Token
+
+// Token returns this static token.
+func (sts StaticTokenSource) Token(context.Context) (Token, error) {
+	return Token(sts), nil
+}
+
+// TokenFunc is a closure that fetches a new token.
+type TokenFunc func(context.Context) (Token, error)
+
+// CachedTokenSource is a TokenSource that caches tokens obtained from a TokenFunc until they
+// expire or are invalidated.  Concurrent requests for a new token result in only one call
+// to the TokenFunc.
+type CachedTokenSource struct {
+	fetch TokenFunc
+	now   func() time.Time
+
+	lock    sync.Mutex
+	current Token
+	valid   bool
+	pending chan struct{}
+}
+
+var _ TokenSource = (*CachedTokenSource)(nil)
+var _ TokenInvalidator = (*CachedTokenSource)(nil)
+
+// NewCachedTokenSource creates a CachedTokenSource that obtains tokens from the given closure.
+func NewCachedTokenSource(fetch TokenFunc) *CachedTokenSource {
+	return &CachedTokenSource{
+		fetch: fetch,
+		now:   time.Now,
+	}
+}
+
+// Token returns the cached token if it is still valid.  Otherwise, a new token is fetched.
+// If another goroutine is already fetching a token, this method waits for that fetch.
+func (cts *CachedTokenSource) Token(ctx context.Context) (Token, error) {
+	for {
+		cts.lock.Lock()
+		if cts.valid && (cts.current.Expiry.IsZero() || cts.now().Before(cts.current.Expiry)) {
+			t := cts.current
+			cts.lock.Unlock()
+			return t, nil
+		}
+
+		pending := cts.pending
+		if pending == nil {
+			// this goroutine does the fetch
+			pending = make(chan struct{})
+			cts.pending = pending
+			cts.lock.Unlock()
+
+			t, err := cts.fetch(ctx)
+			cts.lock.Lock()
+			cts.pending = nil
+			cts.current, cts.valid = t, err == nil
+			cts.lock.Unlock()
+			close(pending)
+
+			return t, err
+		}
+
+		cts.lock.Unlock()
+		select {
+		case <-pending:
+			// loop around and check the new token
+
+		case <-ctx.Done():
+			return Token{}, ctx.Err()
+		}
+	}
+}
+
+// Invalidate discards the given token if it is the currently cached token.
+func (cts *CachedTokenSource) Invalidate(t Token) {
+	cts.lock.Lock()
+	if cts.valid && cts.current == t {
+		cts.valid = false
+	}
+
+	cts.lock.Unlock()
+}
+
+// replayable tests if a request can be sent more than once.
+func replayable(request *http.Request) bool {
+	return request.Body == nil || request.Body == http.NoBody || request.GetBody != nil
+}
+
+// authorize clones a request and sets its Authorization header.
+func authorize(request *http.Request, scheme string, t Token) (*http.Request, error) {
+	authorized := request.Clone(request.Context())
+	if request.Body != nil && request.Body != http.NoBody && request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+
+		authorized.Body = body
+	}
+
+	authorized.Header.Set("Authorization", scheme+" "+t.Value)
+	return authorized, nil
+}
+
+// TokenAuth returns client middleware that sets the Authorization header of each request to
+// the scheme followed by a token from the given TokenSource.  If the TokenSource implements
+// TokenInvalidator, a 401 response invalidates the token and the request is retried once with
+// a new token, provided the request body can be replayed.
+func TokenAuth(scheme string, ts TokenSource) func(http.RoundTripper) http.RoundTripper {
+	ti, _ := ts.(TokenInvalidator)
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundtrip.PreserveCloseIdler(
+			next,
+			roundtrip.Func(func(request *http.Request) (*http.Response, error) {
+				t, err := ts.Token(request.Context())
+				if err != nil {
+					return nil, err
+				}
+
+				authorized, err := authorize(request, scheme, t)
+				if err != nil {
+					return nil, err
+				}
+
+				response, err := next.RoundTrip(authorized)
+				if err != nil || response.StatusCode != http.StatusUnauthorized || ti == nil || !replayable(request) {
+					return response, err
+				}
+
+				ti.Invalidate(t)
+				if t, err = ts.Token(request.Context()); err != nil {
+					// the original 401 is more informative than the token error
+					return response, nil
+				}
+
+				io.Copy(io.Discard, response.Body)
+				response.Body.Close()
+				if authorized, err = authorize(request, scheme, t); err != nil {
+					return nil, err
+				}
+
+				return next.RoundTrip(authorized)
+			}),
+		)
+	}
+}
+
+// BasicAuthConfig configures HTTP basic authentication.
+type BasicAuthConfig struct {
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+}
+
+// TokenSource returns a static TokenSource for the basic credentials.
+func (bac BasicAuthConfig) TokenSource() TokenSource {
+	return StaticTokenSource{
+		Value: base64.StdEncoding.EncodeToString([]byte(bac.Username + ":" + bac.Password)),
+	}
+}
+
+// BearerFileConfig configures bearer tokens read from a file, such as a projected
+// service account token that is periodically rotated.
+type BearerFileConfig struct {
+	// Path is the file containing the token.  Leading and trailing whitespace is ignored.
+	Path string `json:"path" yaml:"path"`
+
+	// RefreshInterval is how often the file is reread.  If unset, DefaultTokenFileRefresh
+	// is used.  The file is also reread whenever a server rejects the token.
+	RefreshInterval time.Duration `json:"refreshInterval" yaml:"refreshInterval"`
+}
+
+// TokenSource returns a CachedTokenSource that reads the token file.
+func (bfc BearerFileConfig) TokenSource() TokenSource {
+	cts := NewCachedTokenSource(nil)
+	refresh := bfc.RefreshInterval
+	if refresh <= 0 {
+		refresh = DefaultTokenFileRefresh
+	}
+
+	cts.fetch = func(context.Context) (t Token, err error) {
+		var contents []byte
+		contents, err = os.ReadFile(bfc.Path)
+		if err == nil {
+			t.Value = strings.TrimSpace(string(contents))
+			t.Expiry = cts.now().Add(refresh)
+			if len(t.Value) == 0 {
+				err = ErrTokenFileEmpty
+			}
+		}
+
+		return
+	}
+
+	return cts
+}
+
+// OAuth2Config configures the OAuth2 client credentials grant.
+type OAuth2Config struct {
+	// TokenURL is the token endpoint of the authorization server.
+	TokenURL string `json:"tokenURL" yaml:"tokenURL"`
+
+	// ClientID and ClientSecret are the client's credentials.  They are sent to the
+	// token endpoint using basic authentication.
+	ClientID     string `json:"clientID" yaml:"clientID"`
+	ClientSecret string `json:"clientSecret" yaml:"clientSecret"`
+
+	// Scopes are the optional scopes to request.
+	Scopes []string `json:"scopes" yaml:"scopes"`
+
+	// ExpiryDelta is how long before a token's expiry that a new token is fetched.
+	// If unset, DefaultTokenExpiryDelta is used.  For short-lived tokens, at most half
+	// of a token's lifetime is used.
+	ExpiryDelta time.Duration `json:"expiryDelta" yaml:"expiryDelta"`
+}
+
+// TokenError is returned when a token endpoint responds with a non-2xx status.
+type TokenError struct {
+	StatusCode int
+	Body       string
+}
+
+// Error satisfies the error interface.
+func (te *TokenError) Error() string {
+	return fmt.Sprintf("Token endpoint returned status %d: %s", te.StatusCode, te.Body)
+}
+
+// tokenResponse is the JSON response from a token endpoint.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// TokenSource returns a CachedTokenSource that fetches tokens from the token endpoint using
+// the given transport.  If transport is nil, http.DefaultTransport is used.
+func (oc OAuth2Config) TokenSource(transport http.RoundTripper) TokenSource {
+	var (
+		client = &http.Client{
+			Transport: arrangereflect.Safe[http.RoundTripper](transport, http.DefaultTransport),
+		}
+
+		cts   = NewCachedTokenSource(nil)
+		delta = oc.ExpiryDelta
+	)
+
+	if delta <= 0 {
+		delta = DefaultTokenExpiryDelta
+	}
+
+	cts.fetch = func(ctx context.Context) (t Token, err error) {
+		form := url.Values{"grant_type": {"client_credentials"}}
+		if len(oc.Scopes) > 0 {
+			form.Set("scope", strings.Join(oc.Scopes, " "))
+		}
+
+		var request *http.Request
+		request, err = http.NewRequestWithContext(ctx, http.MethodPost, oc.TokenURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return
+		}
+
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Accept", "application/json")
+		request.SetBasicAuth(url.QueryEscape(oc.ClientID), url.QueryEscape(oc.ClientSecret))
+
+		var response *http.Response
+		response, err = client.Do(request)
+		if err != nil {
+			return
+		}
+
+		defer response.Body.Close()
+		var body []byte
+		body, err = io.ReadAll(io.LimitReader(response.Body, 1<<20))
+		if err != nil {
+			return
+		} else if response.StatusCode < 200 || response.StatusCode > 299 {
+			err = &TokenError{StatusCode: response.StatusCode, Body: string(bytes.TrimSpace(body))}
+			return
+		}
+
+		var tr tokenResponse
+		if err = json.Unmarshal(body, &tr); err != nil {
+			return
+		}
+
+		t.Value = tr.AccessToken
+		if tr.ExpiresIn > 0 {
+			// a token must outlive its delta, or every request would fetch a new token
+			lifetime := time.Duration(tr.ExpiresIn) * time.Second
+			t.Expiry = cts.now().Add(lifetime - min(delta, lifetime/2))
+		}
+
+		return
+	}
+
+	return cts
+}
+
+// AuthConfig is the unmarshaled configuration for client authentication.  At most one
+// authentication method may be configured.
+type AuthConfig struct {
+	// Basic configures HTTP basic authentication.
+	Basic *BasicAuthConfig `json:"basic" yaml:"basic"`
+
+	// BearerFile configures bearer tokens read from a file.
+	BearerFile *BearerFileConfig `json:"bearerFile" yaml:"bearerFile"`
+
+	// OAuth2 configures bearer tokens obtained via the OAuth2 client credentials grant.
+	OAuth2 *OAuth2Config `json:"oauth2" yaml:"oauth2"`
+}
+
+// Middleware returns the client middleware for the configured authentication method.  The
+// tokenTransport is used to obtain tokens from any token endpoint.  If no authentication
+// method is configured, this method returns nil.
+func (ac AuthConfig) Middleware(tokenTransport http.RoundTripper) (m func(http.RoundTripper) http.RoundTripper, err error) {
+	count := 0
+	if ac.Basic != nil {
+		count++
+		m = TokenAuth("Basic", ac.Basic.TokenSource())
+	}
+
+	if ac.BearerFile != nil {
+		count++
+		m = TokenAuth("Bearer", ac.BearerFile.TokenSource())
+	}
+
+	if ac.OAuth2 != nil {
+		count++
+		m = TokenAuth("Bearer", ac.OAuth2.TokenSource(tokenTransport))
+	}
+
+	if count > 1 {
+		m, err = nil, ErrMultipleAuthMethods
+	}
+
+	return
+}
+
+// Apply allows an AuthConfig to be used as an Option[http.Client].  The client's current
+// transport is used both to send requests and to obtain tokens.
+func (ac AuthConfig) Apply(c *http.Client) error {
+	m, err := ac.Middleware(c.Transport)
+	if m != nil {
+		c.Transport = m(arrangereflect.Safe[http.RoundTripper](c.Transport, http.DefaultTransport))
+	}
+
+	return err
+}
@@ -0,0 +1,415 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ClientAuthSuite struct {
+	suite.Suite
+
+	// acceptedAuthorization is the Authorization header value the resource server accepts
+	acceptedAuthorization atomic.Value
+	resourceServer        *httptest.Server
+
+	tokenRequests  atomic.Int32
+	tokenStatus    atomic.Int32
+	tokenExpiresIn atomic.Int32
+	tokenServer    *httptest.Server
+}
+
+func (suite *ClientAuthSuite) handleResource(response http.ResponseWriter, request *http.Request) {
+	io.Copy(io.Discard, request.Body)
+	if request.Header.Get("Authorization") != suite.acceptedAuthorization.Load().(string) {
+		response.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	response.WriteHeader(299)
+}
+
+// handleToken is a stand-in OAuth2 token endpoint.  Each token it issues is distinct.
+func (suite *ClientAuthSuite) handleToken(response http.ResponseWriter, request *http.Request) {
+	count := suite.tokenRequests.Add(1)
+	clientID, clientSecret, _ := request.BasicAuth()
+	suite.Equal("client", clientID)
+	suite.Equal("secret", clientSecret)
+	suite.NoError(request.ParseForm())
+	suite.Equal("client_credentials", request.PostForm.Get("grant_type"))
+	suite.Equal("read write", request.PostForm.Get("scope"))
+
+	if status := int(suite.tokenStatus.Load()); status != http.StatusOK {
+		response.WriteHeader(status)
+		response.Write([]byte("nope"))
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(response).Encode(map[string]any{
+		"access_token": "token-" + strconv.Itoa(int(count)),
+		"token_type":   "Bearer",
+		"expires_in":   suite.tokenExpiresIn.Load(),
+	})
+}
+
+func (suite *ClientAuthSuite) SetupSuite() {
+	suite.resourceServer = httptest.NewServer(http.HandlerFunc(suite.handleResource))
+	suite.tokenServer = httptest.NewServer(http.HandlerFunc(suite.handleToken))
+}
+
+func (suite *ClientAuthSuite) SetupTest() {
+	suite.acceptedAuthorization.Store("")
+	suite.tokenRequests.Store(0)
+	suite.tokenStatus.Store(http.StatusOK)
+	suite.tokenExpiresIn.Store(3600)
+}
+
+func (suite *ClientAuthSuite) SetupSubTest() {
+	suite.SetupTest()
+}
+
+func (suite *ClientAuthSuite) TearDownSuite() {
+	suite.resourceServer.Close()
+	suite.tokenServer.Close()
+}
+
+func (suite *ClientAuthSuite) newClient(ac AuthConfig) *http.Client {
+	c := new(http.Client)
+	suite.Require().NoError(ac.Apply(c))
+	return c
+}
+
+func (suite *ClientAuthSuite) get(c *http.Client) int {
+	response, err := c.Get(suite.resourceServer.URL)
+	suite.Require().NoError(err)
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	return response.StatusCode
+}
+
+func (suite *ClientAuthSuite) oauth2Config() *OAuth2Config {
+	return &OAuth2Config{
+		TokenURL:     suite.tokenServer.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Scopes:       []string{"read", "write"},
+	}
+}
+
+func (suite *ClientAuthSuite) TestCachedTokenSourceSingleFlight() {
+	var (
+		fetches atomic.Int32
+		release = make(chan struct{})
+		cts     = NewCachedTokenSource(func(context.Context) (Token, error) {
+			fetches.Add(1)
+			<-release
+			return Token{Value: "test"}, nil
+		})
+
+		wg sync.WaitGroup
+	)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t, err := cts.Token(context.Background())
+			suite.NoError(err)
+			suite.Equal("test", t.Value)
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	suite.Equal(int32(1), fetches.Load())
+}
+
+func (suite *ClientAuthSuite) TestCachedTokenSourceExpiryAndInvalidate() {
+	var (
+		now   = time.Now()
+		count = 0
+		cts   = NewCachedTokenSource(func(context.Context) (Token, error) {
+			count++
+			return Token{Value: strconv.Itoa(count), Expiry: now.Add(time.Minute)}, nil
+		})
+	)
+
+	cts.now = func() time.Time { return now }
+	first, err := cts.Token(context.Background())
+	suite.Require().NoError(err)
+	suite.Equal("1", first.Value)
+
+	cached, err := cts.Token(context.Background())
+	suite.Require().NoError(err)
+	suite.Equal(first, cached)
+
+	cts.Invalidate(Token{Value: "stale"}) // not current, so ignored
+	cached, err = cts.Token(context.Background())
+	suite.Require().NoError(err)
+	suite.Equal(first, cached)
+
+	cts.Invalidate(first)
+	second, err := cts.Token(context.Background())
+	suite.Require().NoError(err)
+	suite.Equal("2", second.Value)
+
+	now = now.Add(2 * time.Minute)
+	third, err := cts.Token(context.Background())
+	suite.Require().NoError(err)
+	suite.Equal("3", third.Value)
+}
+
+func (suite *ClientAuthSuite) TestCachedTokenSourceError() {
+	expectedErr := errors.New("expected")
+	cts := NewCachedTokenSource(func(context.Context) (Token, error) {
+		return Token{}, expectedErr
+	})
+
+	_, err := cts.Token(context.Background())
+	suite.ErrorIs(err, expectedErr)
+
+	c := &http.Client{
+		Transport: TokenAuth("Bearer", cts)(http.DefaultTransport),
+	}
+
+	_, err = c.Get(suite.resourceServer.URL)
+	suite.ErrorIs(err, expectedErr)
+}
+
+func (suite *ClientAuthSuite) testTokenAuthRefreshOn401() {
+	var (
+		count = 0
+		cts   = NewCachedTokenSource(func(context.Context) (Token, error) {
+			count++
+			return Token{Value: strconv.Itoa(count)}, nil
+		})
+
+		c = &http.Client{
+			Transport: TokenAuth("Bearer", cts)(http.DefaultTransport),
+		}
+	)
+
+	suite.acceptedAuthorization.Store("Bearer 2")
+	response, err := c.Post(suite.resourceServer.URL, "text/plain", strings.NewReader("body"))
+	suite.Require().NoError(err)
+	response.Body.Close()
+	suite.Equal(299, response.StatusCode)
+	suite.Equal(2, count)
+}
+
+func (suite *ClientAuthSuite) testTokenAuthNotReplayable() {
+	var (
+		count = 0
+		cts   = NewCachedTokenSource(func(context.Context) (Token, error) {
+			count++
+			return Token{Value: strconv.Itoa(count)}, nil
+		})
+
+		c = &http.Client{
+			Transport: TokenAuth("Bearer", cts)(http.DefaultTransport),
+		}
+	)
+
+	suite.acceptedAuthorization.Store("Bearer 2")
+	request, err := http.NewRequest("POST", suite.resourceServer.URL, io.NopCloser(strings.NewReader("body")))
+	suite.Require().NoError(err)
+	suite.Require().Nil(request.GetBody)
+
+	response, err := c.Do(request)
+	suite.Require().NoError(err)
+	response.Body.Close()
+	suite.Equal(http.StatusUnauthorized, response.StatusCode)
+	suite.Equal(1, count)
+}
+
+func (suite *ClientAuthSuite) testTokenAuthStatic() {
+	c := &http.Client{
+		Transport: TokenAuth("Bearer", StaticTokenSource{Value: "wrong"})(http.DefaultTransport),
+	}
+
+	suite.acceptedAuthorization.Store("Bearer right")
+	suite.Equal(http.StatusUnauthorized, suite.get(c))
+}
+
+func (suite *ClientAuthSuite) TestTokenAuth() {
+	suite.Run("RefreshOn401", suite.testTokenAuthRefreshOn401)
+	suite.Run("NotReplayable", suite.testTokenAuthNotReplayable)
+	suite.Run("Static", suite.testTokenAuthStatic)
+}
+
+func (suite *ClientAuthSuite) TestBasic() {
+	c := suite.newClient(AuthConfig{
+		Basic: &BasicAuthConfig{
+			Username: "user",
+			Password: "pass",
+		},
+	})
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.SetBasicAuth("user", "pass")
+	suite.acceptedAuthorization.Store(request.Header.Get("Authorization"))
+	suite.Equal(299, suite.get(c))
+}
+
+func (suite *ClientAuthSuite) testBearerFileRotation() {
+	path := filepath.Join(suite.T().TempDir(), "token")
+	suite.Require().NoError(os.WriteFile(path, []byte("first\n"), 0600))
+
+	c := suite.newClient(AuthConfig{
+		BearerFile: &BearerFileConfig{
+			Path:            path,
+			RefreshInterval: time.Hour,
+		},
+	})
+
+	suite.acceptedAuthorization.Store("Bearer first")
+	suite.Equal(299, suite.get(c))
+
+	// rotate the token: the 401 causes the file to be reread
+	suite.Require().NoError(os.WriteFile(path, []byte("second"), 0600))
+	suite.acceptedAuthorization.Store("Bearer second")
+	suite.Equal(299, suite.get(c))
+}
+
+func (suite *ClientAuthSuite) testBearerFileEmpty() {
+	path := filepath.Join(suite.T().TempDir(), "token")
+	suite.Require().NoError(os.WriteFile(path, []byte(" \n"), 0600))
+
+	c := suite.newClient(AuthConfig{
+		BearerFile: &BearerFileConfig{
+			Path: path,
+		},
+	})
+
+	_, err := c.Get(suite.resourceServer.URL)
+	suite.ErrorIs(err, ErrTokenFileEmpty)
+}
+
+func (suite *ClientAuthSuite) testBearerFileMissing() {
+	c := suite.newClient(AuthConfig{
+		BearerFile: &BearerFileConfig{
+			Path: filepath.Join(suite.T().TempDir(), "nosuch"),
+		},
+	})
+
+	_, err := c.Get(suite.resourceServer.URL)
+	suite.ErrorIs(err, os.ErrNotExist)
+}
+
+func (suite *ClientAuthSuite) TestBearerFile() {
+	suite.Run("Rotation", suite.testBearerFileRotation)
+	suite.Run("Empty", suite.testBearerFileEmpty)
+	suite.Run("Missing", suite.testBearerFileMissing)
+}
+
+func (suite *ClientAuthSuite) testOAuth2Cached() {
+	c := suite.newClient(AuthConfig{OAuth2: suite.oauth2Config()})
+	suite.acceptedAuthorization.Store("Bearer token-1")
+	for i := 0; i < 5; i++ {
+		suite.Equal(299, suite.get(c))
+	}
+
+	suite.Equal(int32(1), suite.tokenRequests.Load())
+}
+
+func (suite *ClientAuthSuite) testOAuth2ShortExpiry() {
+	// the token lifetime is shorter than DefaultTokenExpiryDelta
+	suite.tokenExpiresIn.Store(5)
+	c := suite.newClient(AuthConfig{OAuth2: suite.oauth2Config()})
+	suite.acceptedAuthorization.Store("Bearer token-1")
+	for i := 0; i < 5; i++ {
+		suite.Equal(299, suite.get(c))
+	}
+
+	suite.Equal(int32(1), suite.tokenRequests.Load())
+}
+
+func (suite *ClientAuthSuite) testOAuth2RefreshOn401() {
+	c := suite.newClient(AuthConfig{OAuth2: suite.oauth2Config()})
+	suite.acceptedAuthorization.Store("Bearer token-2")
+	suite.Equal(299, suite.get(c))
+	suite.Equal(int32(2), suite.tokenRequests.Load())
+}
+
+func (suite *ClientAuthSuite) testOAuth2TokenError() {
+	suite.tokenStatus.Store(http.StatusBadRequest)
+	c := suite.newClient(AuthConfig{OAuth2: suite.oauth2Config()})
+
+	_, err := c.Get(suite.resourceServer.URL)
+	var te *TokenError
+	suite.Require().ErrorAs(err, &te)
+	suite.Equal(http.StatusBadRequest, te.StatusCode)
+	suite.Equal("nope", te.Body)
+	suite.Contains(te.Error(), "nope")
+}
+
+func (suite *ClientAuthSuite) TestOAuth2() {
+	suite.Run("Cached", suite.testOAuth2Cached)
+	suite.Run("ShortExpiry", suite.testOAuth2ShortExpiry)
+	suite.Run("RefreshOn401", suite.testOAuth2RefreshOn401)
+	suite.Run("TokenError", suite.testOAuth2TokenError)
+}
+
+func (suite *ClientAuthSuite) TestAuthConfig() {
+	suite.Run("None", func() {
+		c := suite.newClient(AuthConfig{})
+		suite.Nil(c.Transport)
+	})
+
+	suite.Run("Multiple", func() {
+		err := AuthConfig{
+			Basic:  new(BasicAuthConfig),
+			OAuth2: suite.oauth2Config(),
+		}.Apply(new(http.Client))
+
+		suite.ErrorIs(err, ErrMultipleAuthMethods)
+	})
+}
+
+func (suite *ClientAuthSuite) TestClientConfig() {
+	cc := ClientConfig{
+		Auth: AuthConfig{
+			OAuth2: suite.oauth2Config(),
+		},
+		Balancer: BalancerConfig{
+			// token requests must not be sent here
+			Endpoints: []string{suite.resourceServer.URL},
+		},
+	}
+
+	c, err := NewClient(cc)
+	suite.Require().NoError(err)
+	suite.acceptedAuthorization.Store("Bearer token-1")
+
+	response, err := c.Get("http://inventory/")
+	suite.Require().NoError(err)
+	response.Body.Close()
+	suite.Equal(299, response.StatusCode)
+	suite.Equal(int32(1), suite.tokenRequests.Load())
+
+	cc.Auth.Basic = new(BasicAuthConfig)
+	_, err = NewClient(cc)
+	suite.ErrorIs(err, ErrMultipleAuthMethods)
+}
+
+func TestClientAuth(t *testing.T) {
+	suite.Run(t, new(ClientAuthSuite))
+}
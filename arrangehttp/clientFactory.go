@@ -5,6 +5,8 @@ package arrangehttp
 
 import (
 	"net/http"
+	"net/http/cookiejar"
+	"net/url"
 	"time"
 
 	"github.com/xmidt-org/arrange/arrangetls"
@@ -69,22 +71,167 @@ type ClientConfig struct {
 	Transport TransportConfig
 	Header    http.Header
 	TLS       *arrangetls.Config
+
+	// StopTimeout is the maximum time to wait for outstanding requests when the
+	// enclosing fx.App stops.  If unset, the client does not wait.  This field is
+	// only used when the client is bound to an fx.App, e.g. via ProvideClient.
+	StopTimeout time.Duration
+
+	// Balancer is the optional client-side load balancing configuration.  If any
+	// endpoints are configured, requests are spread across them.
+	Balancer BalancerConfig
+
+	// AccessLog configures structured access logging for this client.  Access logs
+	// require a *zap.Logger, which ProvideClient obtains from the enclosing fx.App.
+	AccessLog AccessLogConfig
+
+	// Hedge is the optional configuration for hedged requests.  Hedged requests are
+	// sent through the load balancer, if one is configured.
+	Hedge HedgeConfig
+
+	// Metrics configures request and connection metrics for this client.  Metrics
+	// are only recorded when the client is created by ProvideClient.
+	Metrics MetricsConfig
+
+	// RequestIDHeader is the header onto which ProvideClient copies the request identifier
+	// held in each outgoing request's context.  If unset, DefaultRequestIDHeader is used.
+	// See RequestIDClientMiddleware.
+	RequestIDHeader string
+
+	// Trace configures W3C Trace Context propagation for this client.  Tracing is only
+	// applied when the client is created by ProvideClient.
+	Trace TraceConfig
+
+	// Auth is the optional authentication configuration for outgoing requests.
+	Auth AuthConfig
+
+	// Redirect controls how the client follows redirects.
+	Redirect RedirectConfig
+
+	// CookieJar enables an in-memory cookie jar for the client.  The jar ignores the Domain
+	// attribute of cookies, so each cookie is only sent back to the host that set it.
+	CookieJar bool
+
+	// MaxResponseBodySize is the maximum size, in bytes, of any response body.  If unset,
+	// response bodies are not limited.  See MaxResponseBodySize.
+	MaxResponseBodySize int64
+
+	// BaseURL is the URL that relative request paths are resolved against.  This
+	// field is used by the JSONClient that ProvideClient creates.
+	BaseURL string
+}
+
+// clientStopTimeout returns the configured stop timeout.  See BindClient.
+func (cc ClientConfig) clientStopTimeout() time.Duration {
+	return cc.StopTimeout
 }
 
 // NewClient produces an http.Client given these unmarshaled configuration options
 func (cc ClientConfig) NewClient() (client *http.Client, err error) {
 	client = &http.Client{
-		Timeout: cc.Timeout,
+		Timeout:       cc.Timeout,
+		CheckRedirect: cc.Redirect.CheckRedirect(),
+	}
+
+	if cc.CookieJar {
+		client.Jar, err = newHostOnlyJar()
+	}
+
+	if err == nil {
+		client.Transport, err = cc.Transport.NewTransport(cc.TLS)
 	}
 
-	client.Transport, err = cc.Transport.NewTransport(cc.TLS)
 	return
 }
 
+// hostOnlyJar is an http.CookieJar that makes every cookie host-only.  Without a public
+// suffix list, a cookiejar.Jar would accept a Domain such as co.uk and share that cookie
+// with every host in the domain.
+type hostOnlyJar struct {
+	*cookiejar.Jar
+}
+
+func newHostOnlyJar() (http.CookieJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return hostOnlyJar{Jar: jar}, nil
+}
+
+func (hoj hostOnlyJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	hostOnly := make([]*http.Cookie, len(cookies))
+	for i, c := range cookies {
+		clone := *c
+		clone.Domain = ""
+		hostOnly[i] = &clone
+	}
+
+	hoj.Jar.SetCookies(u, hostOnly)
+}
+
+// clientBaseURL returns the base URL for ProvideClient's JSONClient.
+func (cc ClientConfig) clientBaseURL() string {
+	return cc.BaseURL
+}
+
+// metricsConfig returns the metrics configuration for ProvideClient.
+func (cc ClientConfig) metricsConfig() MetricsConfig {
+	return cc.Metrics
+}
+
+// requestIDHeader returns the request identifier header for ProvideClient.
+func (cc ClientConfig) requestIDHeader() string {
+	return cc.RequestIDHeader
+}
+
+// traceConfig returns the trace context configuration for ProvideClient.
+func (cc ClientConfig) traceConfig() TraceConfig {
+	return cc.Trace
+}
+
+// accessLogConfig returns the access log configuration for ProvideClient.
+func (cc ClientConfig) accessLogConfig() AccessLogConfig {
+	return cc.AccessLog
+}
+
 // Apply allows a ClientConfig to be used as an Option[http.Client].  This method
 // decorates the client's transport so that the configured headers are supplied
-// with every request.
+// with every request and, if configured, requests are authenticated, hedged, and load balanced.
 func (cc ClientConfig) Apply(c *http.Client) error {
+	_, err := cc.applyBalanced(c)
+	return err
+}
+
+// applyBalanced is the implementation of Apply.  It returns the Balancer installed in the
+// client's transport, if any, so that ProvideClient can bind it to the application lifecycle.
+func (cc ClientConfig) applyBalanced(c *http.Client) (*Balancer, error) {
+	// tokens are obtained with the undecorated transport, so that token
+	// requests are not load balanced
+	tokenTransport := c.Transport
+	if cc.MaxResponseBodySize > 0 {
+		c.Transport = MaxResponseBodySize(cc.MaxResponseBodySize)(
+			arrangereflect.Safe(c.Transport, http.DefaultTransport),
+		)
+	}
+
+	b, err := cc.Balancer.apply(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cc.Hedge.Apply(c); err != nil {
+		return nil, err
+	}
+
+	auth, err := cc.Auth.Middleware(tokenTransport)
+	if err != nil {
+		return nil, err
+	} else if auth != nil {
+		c.Transport = auth(arrangereflect.Safe(c.Transport, http.DefaultTransport))
+	}
+
 	if len(cc.Header) > 0 {
 		header := httpaux.NewHeader(cc.Header)
 		c.Transport = roundtrip.Header(header.SetTo)(
@@ -92,5 +239,5 @@ func (cc ClientConfig) Apply(c *http.Client) error {
 		)
 	}
 
-	return nil
+	return b, nil
 }
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
@@ -161,6 +162,56 @@ func (suite *ClientConfigSuite) TestNewClient() {
 	suite.Equal(299, response.StatusCode)
 }
 
+func (suite *ClientConfigSuite) TestNewClientPolicies() {
+	cc := ClientConfig{
+		Redirect: RedirectConfig{
+			MaxRedirects: 3,
+		},
+		CookieJar:           true,
+		MaxResponseBodySize: 1024,
+	}
+
+	client := suite.getClient(cc)
+	suite.NotNil(client.CheckRedirect)
+	suite.NotNil(client.Jar)
+
+	// the jar should retain cookies set by the server
+	u, err := url.Parse(suite.server.URL)
+	suite.Require().NoError(err)
+	client.Jar.SetCookies(u, []*http.Cookie{{Name: "test", Value: "value"}})
+	suite.addTestRequestAssertions(
+		func(candidate *http.Request) {
+			cookie, err := candidate.Cookie("test")
+			suite.Require().NoError(err)
+			suite.Equal("value", cookie.Value)
+		},
+	)
+
+	suite.Require().NoError(cc.Apply(client))
+	response := suite.sendRequest(client, "GET", nil)
+	suite.Equal(299, response.StatusCode)
+}
+
+func (suite *ClientConfigSuite) TestCookieJarHostOnly() {
+	client := suite.getClient(ClientConfig{CookieJar: true})
+	suite.Require().NotNil(client.Jar)
+
+	var (
+		foo, _    = url.Parse("https://foo.co.uk/")
+		bar, _    = url.Parse("https://bar.co.uk/")
+		subFoo, _ = url.Parse("https://sub.foo.co.uk/")
+	)
+
+	client.Jar.SetCookies(foo, []*http.Cookie{
+		{Name: "public", Value: "value", Domain: "co.uk"},
+		{Name: "domain", Value: "value", Domain: "foo.co.uk"},
+	})
+
+	suite.Len(client.Jar.Cookies(foo), 2)
+	suite.Empty(client.Jar.Cookies(bar))
+	suite.Empty(client.Jar.Cookies(subFoo))
+}
+
 func (suite *ClientConfigSuite) testApplyNoHeader() {
 	cc := ClientConfig{
 		Timeout: 15 * time.Second,
@@ -0,0 +1,197 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/xmidt-org/arrange/internal/arrangereflect"
+	"github.com/xmidt-org/httpaux/roundtrip"
+	"go.uber.org/fx"
+)
+
+var (
+	// ErrClientStopped is returned by a client's transport when a request is attempted
+	// after the client has been stopped by its enclosing fx.App.
+	ErrClientStopped = errors.New("The client has been stopped")
+)
+
+// stopTimeoutFactory is implemented by client factories that configure how long
+// a client waits for outstanding requests, such as ClientConfig.
+type stopTimeoutFactory interface {
+	clientStopTimeout() time.Duration
+}
+
+// clientTracker is an http.RoundTripper decorator that tracks outstanding requests.
+// A request is outstanding until its response body is closed or fully read.
+type clientTracker struct {
+	next http.RoundTripper
+
+	lock     sync.Mutex
+	stopped  bool
+	inFlight int
+	drained  chan struct{}
+}
+
+func newClientTracker(next http.RoundTripper) *clientTracker {
+	return &clientTracker{
+		next:    next,
+		drained: make(chan struct{}),
+	}
+}
+
+// begin marks the start of a request.  If this tracker has been stopped,
+// this method returns false.
+func (ct *clientTracker) begin() (ok bool) {
+	ct.lock.Lock()
+	ok = !ct.stopped
+	if ok {
+		ct.inFlight++
+	}
+
+	ct.lock.Unlock()
+	return
+}
+
+// end marks the completion of an outstanding request.
+func (ct *clientTracker) end() {
+	ct.lock.Lock()
+	ct.inFlight--
+	if ct.stopped && ct.inFlight == 0 {
+		close(ct.drained)
+	}
+
+	ct.lock.Unlock()
+}
+
+// stop prevents any further requests.  The returned channel is closed once
+// all outstanding requests have completed.
+func (ct *clientTracker) stop() <-chan struct{} {
+	ct.lock.Lock()
+	if !ct.stopped {
+		ct.stopped = true
+		if ct.inFlight == 0 {
+			close(ct.drained)
+		}
+	}
+
+	ct.lock.Unlock()
+	return ct.drained
+}
+
+func (ct *clientTracker) RoundTrip(request *http.Request) (*http.Response, error) {
+	if !ct.begin() {
+		return nil, ErrClientStopped
+	}
+
+	response, err := ct.next.RoundTrip(request)
+	if err != nil || response == nil || response.Body == nil {
+		ct.end()
+		return response, err
+	}
+
+	response.Body = newTrackedBody(response.Body, ct.end)
+
+	return response, nil
+}
+
+// CloseIdleConnections delegates to the decorated transport, if supported.
+func (ct *clientTracker) CloseIdleConnections() {
+	roundtrip.CloseIdleConnections(ct.next)
+}
+
+// trackedBody decorates a response body so that the owning clientTracker
+// knows when a request is no longer outstanding.
+type trackedBody struct {
+	io.ReadCloser
+	end func()
+}
+
+// readWriteBody is a decorated response body that writes to the original body, which is
+// how the body of a 101 Switching Protocols response is used.
+type readWriteBody struct {
+	io.ReadCloser
+	io.Writer
+}
+
+// keepWritable returns the decorated body, made writable if the original body is writable.
+// Any decorator of response bodies must use this to preserve protocol upgrades.
+func keepWritable(decorated, original io.ReadCloser) io.ReadCloser {
+	if w, ok := original.(io.Writer); ok {
+		return readWriteBody{
+			ReadCloser: decorated,
+			Writer:     w,
+		}
+	}
+
+	return decorated
+}
+
+// newTrackedBody decorates a response body so that end is called exactly once, when the body
+// is either fully read or closed.  If the body is writable, the returned body is also writable,
+// which preserves protocol upgrades.
+func newTrackedBody(body io.ReadCloser, end func()) io.ReadCloser {
+	return keepWritable(
+		&trackedBody{
+			ReadCloser: body,
+			end:        sync.OnceFunc(end),
+		},
+		body,
+	)
+}
+
+func (tb *trackedBody) Read(p []byte) (n int, err error) {
+	n, err = tb.ReadCloser.Read(p)
+	if err == io.EOF {
+		tb.end()
+	}
+
+	return
+}
+
+func (tb *trackedBody) Close() error {
+	defer tb.end()
+	return tb.ReadCloser.Close()
+}
+
+// BindClient binds an *http.Client to the lifecycle of an enclosing fx.App.  The client's
+// transport is decorated so that outstanding requests are tracked.
+//
+// When the enclosing fx.App stops, the client rejects any new requests with ErrClientStopped
+// and closes its idle connections.  If stopTimeout is positive, the stop hook then waits up
+// to that duration for outstanding requests to complete before closing idle connections
+// again.  The stop hook never waits longer than the fx.App's stop context allows.
+//
+// ProvideClient and ProvideClientCustom automatically use this function.
+func BindClient(lc fx.Lifecycle, c *http.Client, stopTimeout time.Duration) {
+	ct := newClientTracker(
+		arrangereflect.Safe[http.RoundTripper](c.Transport, http.DefaultTransport),
+	)
+
+	c.Transport = ct
+	lc.Append(fx.StopHook(
+		func(ctx context.Context) {
+			drained := ct.stop()
+			ct.CloseIdleConnections()
+			if stopTimeout <= 0 {
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, stopTimeout)
+			defer cancel()
+
+			select {
+			case <-drained:
+				ct.CloseIdleConnections()
+
+			case <-ctx.Done():
+			}
+		},
+	))
+}
@@ -0,0 +1,249 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange"
+	"github.com/xmidt-org/arrange/arrangetest"
+	"github.com/xmidt-org/httpaux"
+	"github.com/xmidt-org/httpaux/roundtrip"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+)
+
+type ClientLifecycleSuite struct {
+	suite.Suite
+}
+
+// newPipeClient creates a client whose transport always returns a 200 response
+// with the given body.
+func (suite *ClientLifecycleSuite) newPipeClient(body io.ReadCloser) *http.Client {
+	return &http.Client{
+		Transport: roundtrip.Func(func(*http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       body,
+			}, nil
+		}),
+	}
+}
+
+// stopAsync stops the lifecycle in a goroutine, returning a channel that is
+// closed when the stop completes.
+func (suite *ClientLifecycleSuite) stopAsync(lc *fxtest.Lifecycle) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		suite.NoError(lc.Stop(context.Background()))
+	}()
+
+	return done
+}
+
+func (suite *ClientLifecycleSuite) testBindClientRejectsAfterStop() {
+	var (
+		lc     = fxtest.NewLifecycle(suite.T())
+		client = suite.newPipeClient(http.NoBody)
+	)
+
+	BindClient(lc, client, 0)
+	lc.RequireStart()
+
+	response, err := client.Get("http://localhost/")
+	suite.Require().NoError(err)
+	response.Body.Close()
+
+	lc.RequireStop()
+	response, err = client.Get("http://localhost/")
+	if response != nil {
+		response.Body.Close()
+	}
+
+	suite.ErrorIs(err, ErrClientStopped)
+}
+
+func (suite *ClientLifecycleSuite) testBindClientWaitsForOutstanding() {
+	var (
+		lc     = fxtest.NewLifecycle(suite.T())
+		r, w   = io.Pipe()
+		client = suite.newPipeClient(r)
+	)
+
+	defer w.Close()
+	BindClient(lc, client, time.Minute)
+	lc.RequireStart()
+
+	response, err := client.Get("http://localhost/")
+	suite.Require().NoError(err)
+
+	done := suite.stopAsync(lc)
+	select {
+	case <-done:
+		suite.Fail("the stop hook did not wait for the outstanding request")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	response.Body.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		suite.Fail("the stop hook did not complete")
+	}
+}
+
+func (suite *ClientLifecycleSuite) testBindClientStopTimeout() {
+	var (
+		lc     = fxtest.NewLifecycle(suite.T())
+		r, w   = io.Pipe()
+		client = suite.newPipeClient(r)
+	)
+
+	defer w.Close()
+	BindClient(lc, client, 50*time.Millisecond)
+	lc.RequireStart()
+
+	response, err := client.Get("http://localhost/")
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+
+	select {
+	case <-suite.stopAsync(lc):
+	case <-time.After(time.Second):
+		suite.Fail("the stop hook did not honor the stop timeout")
+	}
+}
+
+func (suite *ClientLifecycleSuite) testBindClientEOF() {
+	var (
+		lc     = fxtest.NewLifecycle(suite.T())
+		r, w   = io.Pipe()
+		client = suite.newPipeClient(r)
+	)
+
+	BindClient(lc, client, time.Minute)
+	lc.RequireStart()
+
+	response, err := client.Get("http://localhost/")
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+
+	w.Close()
+	_, err = io.ReadAll(response.Body)
+	suite.Require().NoError(err)
+
+	select {
+	case <-suite.stopAsync(lc):
+	case <-time.After(time.Second):
+		suite.Fail("a fully read response should not be outstanding")
+	}
+}
+
+func (suite *ClientLifecycleSuite) testBindClientUpgrade() {
+	// the server switches to a line-based echo protocol
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		conn, brw, err := http.NewResponseController(response).Hijack()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		brw.Flush()
+
+		line, _ := brw.ReadString('\n')
+		brw.WriteString(line)
+		brw.Flush()
+	}))
+
+	defer server.Close()
+
+	var (
+		lc     = fxtest.NewLifecycle(suite.T())
+		client = new(http.Client)
+	)
+
+	BindClient(lc, client, 0)
+	lc.RequireStart()
+	defer lc.RequireStop()
+
+	request, err := http.NewRequest("GET", server.URL, nil)
+	suite.Require().NoError(err)
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", "echo")
+
+	response, err := client.Do(request)
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+	suite.Require().Equal(http.StatusSwitchingProtocols, response.StatusCode)
+
+	rwc, ok := response.Body.(io.ReadWriteCloser)
+	suite.Require().True(ok, "the body of an upgraded response must be writable")
+
+	_, err = io.WriteString(rwc, "ping\n")
+	suite.Require().NoError(err)
+
+	echo := make([]byte, len("ping\n"))
+	_, err = io.ReadFull(rwc, echo)
+	suite.Require().NoError(err)
+	suite.Equal("ping\n", string(echo))
+}
+
+func (suite *ClientLifecycleSuite) TestBindClient() {
+	suite.Run("RejectsAfterStop", suite.testBindClientRejectsAfterStop)
+	suite.Run("WaitsForOutstanding", suite.testBindClientWaitsForOutstanding)
+	suite.Run("StopTimeout", suite.testBindClientStopTimeout)
+	suite.Run("EOF", suite.testBindClientEOF)
+	suite.Run("Upgrade", suite.testBindClientUpgrade)
+}
+
+func (suite *ClientLifecycleSuite) TestProvideClient() {
+	server := httptest.NewServer(httpaux.ConstantHandler{StatusCode: 299})
+	defer server.Close()
+
+	var client *http.Client
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			fx.Annotated{
+				Name: "client.config",
+				Target: ClientConfig{
+					StopTimeout: time.Second,
+				},
+			},
+		),
+		ProvideClient("client"),
+		fx.Populate(
+			fx.Annotate(
+				&client,
+				arrange.Tags().Name("client").ParamTags(),
+			),
+		),
+	)
+
+	app.RequireStart()
+	response, err := client.Get(server.URL)
+	suite.Require().NoError(err)
+	response.Body.Close()
+	suite.Equal(299, response.StatusCode)
+
+	app.RequireStop()
+	response, err = client.Get(server.URL)
+	if response != nil {
+		response.Body.Close()
+	}
+
+	suite.ErrorIs(err, ErrClientStopped)
+}
+
+func TestClientLifecycle(t *testing.T) {
+	suite.Run(t, new(ClientLifecycleSuite))
+}
@@ -0,0 +1,242 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xmidt-org/arrange/internal/arrangereflect"
+	"github.com/xmidt-org/httpaux/roundtrip"
+)
+
+const (
+	// ClientRequestsTotal counts client requests by host and status class.  Requests that
+	// fail without a response have a class of "error".
+	ClientRequestsTotal = "http_client_requests_total"
+
+	// ClientInFlightRequests is the number of client requests, by host, that are outstanding.
+	// A request is outstanding until its response body is closed or fully read.
+	ClientInFlightRequests = "http_client_in_flight_requests"
+
+	// ClientDNSDuration is the DNS lookup latency, by host.
+	ClientDNSDuration = "http_client_dns_duration_seconds"
+
+	// ClientConnectDuration is the latency of establishing new connections, by host.
+	ClientConnectDuration = "http_client_connect_duration_seconds"
+
+	// ClientTLSHandshakeDuration is the TLS handshake latency, by host.
+	ClientTLSHandshakeDuration = "http_client_tls_handshake_duration_seconds"
+
+	// ClientFirstByteDuration is the time to the first response byte, by host.
+	ClientFirstByteDuration = "http_client_first_byte_duration_seconds"
+
+	// ClientConnectionsTotal counts the connections obtained by requests, by host and
+	// whether the connection was reused from the pool.
+	ClientConnectionsTotal = "http_client_connections_total"
+)
+
+// statusClass returns the class of a status code, e.g. "2xx".
+func statusClass(statusCode int) string {
+	return strconv.Itoa(statusCode/100) + "xx"
+}
+
+// clientTiming records the start times of the phases of a single request.  The
+// httptrace callbacks can be invoked from other goroutines.
+type clientTiming struct {
+	lock         sync.Mutex
+	dnsStart     time.Time
+	connectStart map[string]time.Time
+	tlsStart     time.Time
+}
+
+func (ct *clientTiming) start(t *time.Time) {
+	ct.lock.Lock()
+	*t = time.Now()
+	ct.lock.Unlock()
+}
+
+func (ct *clientTiming) since(t *time.Time) (d time.Duration) {
+	ct.lock.Lock()
+	d = time.Since(*t)
+	ct.lock.Unlock()
+	return
+}
+
+// clientHostMetrics holds the metrics for a single host.  These are obtained from
+// the Registry once, rather than with each request.
+type clientHostMetrics struct {
+	r    Registry
+	host string
+
+	inFlight     Gauge
+	dns          Histogram
+	connect      Histogram
+	tlsHandshake Histogram
+	firstByte    Histogram
+	newConns     Counter
+	reusedConns  Counter
+
+	// requests holds the request Counter for each status class
+	requests sync.Map
+}
+
+func newClientHostMetrics(r Registry, host string, buckets []float64) *clientHostMetrics {
+	labels := Labels{"host": host}
+	return &clientHostMetrics{
+		r:            r,
+		host:         host,
+		inFlight:     r.Gauge(ClientInFlightRequests, "Outstanding client requests", labels),
+		dns:          r.Histogram(ClientDNSDuration, "DNS lookup latency", buckets, labels),
+		connect:      r.Histogram(ClientConnectDuration, "New connection latency", buckets, labels),
+		tlsHandshake: r.Histogram(ClientTLSHandshakeDuration, "TLS handshake latency", buckets, labels),
+		firstByte:    r.Histogram(ClientFirstByteDuration, "Time to the first response byte", buckets, labels),
+		newConns: r.Counter(
+			ClientConnectionsTotal,
+			"Connections obtained by requests",
+			Labels{"host": host, "reused": "false"},
+		),
+		reusedConns: r.Counter(
+			ClientConnectionsTotal,
+			"Connections obtained by requests",
+			Labels{"host": host, "reused": "true"},
+		),
+	}
+}
+
+// requestsFor returns the request Counter for the given status class.
+func (chm *clientHostMetrics) requestsFor(class string) Counter {
+	if c, ok := chm.requests.Load(class); ok {
+		return c.(Counter)
+	}
+
+	c, _ := chm.requests.LoadOrStore(class, chm.r.Counter(
+		ClientRequestsTotal,
+		"Client requests by host and status class",
+		Labels{"host": chm.host, "class": class},
+	))
+
+	return c.(Counter)
+}
+
+func (chm *clientHostMetrics) newTrace(start time.Time) *httptrace.ClientTrace {
+	ct := &clientTiming{
+		connectStart: make(map[string]time.Time),
+	}
+
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			ct.start(&ct.dnsStart)
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			if info.Err == nil {
+				chm.dns.Observe(ct.since(&ct.dnsStart).Seconds())
+			}
+		},
+		ConnectStart: func(network, addr string) {
+			ct.lock.Lock()
+			ct.connectStart[network+addr] = time.Now()
+			ct.lock.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			ct.lock.Lock()
+			connectStart, ok := ct.connectStart[network+addr]
+			ct.lock.Unlock()
+			if ok && err == nil {
+				chm.connect.Observe(time.Since(connectStart).Seconds())
+			}
+		},
+		TLSHandshakeStart: func() {
+			ct.start(&ct.tlsStart)
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				chm.tlsHandshake.Observe(ct.since(&ct.tlsStart).Seconds())
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				chm.reusedConns.Add(1)
+			} else {
+				chm.newConns.Add(1)
+			}
+		},
+		GotFirstResponseByte: func() {
+			chm.firstByte.Observe(time.Since(start).Seconds())
+		},
+	}
+}
+
+// clientMetrics is the http.RoundTripper that instruments requests.
+type clientMetrics struct {
+	next    http.RoundTripper
+	r       Registry
+	buckets []float64
+
+	// hosts holds the *clientHostMetrics for each host
+	hosts sync.Map
+}
+
+// forHost returns the metrics for the given host.
+func (cm *clientMetrics) forHost(host string) *clientHostMetrics {
+	if chm, ok := cm.hosts.Load(host); ok {
+		return chm.(*clientHostMetrics)
+	}
+
+	chm, _ := cm.hosts.LoadOrStore(host, newClientHostMetrics(cm.r, host, cm.buckets))
+	return chm.(*clientHostMetrics)
+}
+
+func (cm *clientMetrics) RoundTrip(request *http.Request) (*http.Response, error) {
+	var (
+		chm   = cm.forHost(request.URL.Host)
+		start = time.Now()
+	)
+
+	chm.inFlight.Add(1)
+	response, err := cm.next.RoundTrip(
+		request.WithContext(
+			httptrace.WithClientTrace(request.Context(), chm.newTrace(start)),
+		),
+	)
+
+	class := "error"
+	if err == nil {
+		class = statusClass(response.StatusCode)
+	}
+
+	chm.requestsFor(class).Add(1)
+	if err != nil || response.Body == nil {
+		chm.inFlight.Add(-1)
+	} else {
+		response.Body = newTrackedBody(response.Body, func() {
+			chm.inFlight.Add(-1)
+		})
+	}
+
+	return response, err
+}
+
+// ClientMiddleware returns a client middleware that records request metrics, using
+// httptrace, to the given Registry.  Connection pool behavior is visible through DNS,
+// connect, and TLS handshake latencies together with the count of reused connections.
+//
+// This method does not check the Enabled flag.  ProvideClient uses that flag to decide
+// whether to apply this middleware.
+func (mc MetricsConfig) ClientMiddleware(r Registry) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		next = arrangereflect.Safe(next, http.DefaultTransport)
+		cm := &clientMetrics{
+			next:    next,
+			r:       r,
+			buckets: mc.buckets(),
+		}
+
+		return roundtrip.PreserveCloseIdler(next, cm)
+	}
+}
@@ -0,0 +1,242 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange"
+	"github.com/xmidt-org/arrange/arrangetest"
+	"go.uber.org/fx"
+)
+
+type ClientMetricsSuite struct {
+	suite.Suite
+	server *httptest.Server
+	host   string
+}
+
+func (suite *ClientMetricsSuite) SetupSuite() {
+	suite.server = httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/missing" {
+			response.WriteHeader(http.StatusNotFound)
+		}
+
+		io.WriteString(response, "hello")
+	}))
+
+	u, err := url.Parse(suite.server.URL)
+	suite.Require().NoError(err)
+	suite.host = u.Host
+}
+
+func (suite *ClientMetricsSuite) TearDownSuite() {
+	suite.server.Close()
+}
+
+func (suite *ClientMetricsSuite) get(c *http.Client, path string) {
+	response, err := c.Get(suite.server.URL + path)
+	suite.Require().NoError(err)
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+}
+
+func (suite *ClientMetricsSuite) text(r *MetricsRegistry) string {
+	var b strings.Builder
+	suite.Require().NoError(r.WriteText(&b))
+	return b.String()
+}
+
+func (suite *ClientMetricsSuite) TestClientMiddleware() {
+	var (
+		r         = NewMetricsRegistry()
+		transport = new(http.Transport)
+		c         = &http.Client{
+			Transport: MetricsConfig{}.ClientMiddleware(r)(transport),
+		}
+	)
+
+	defer transport.CloseIdleConnections()
+	suite.get(c, "/")
+	suite.get(c, "/")
+	suite.get(c, "/missing")
+
+	text := suite.text(r)
+	suite.Contains(text, `http_client_requests_total{class="2xx",host="`+suite.host+`"} 2`)
+	suite.Contains(text, `http_client_requests_total{class="4xx",host="`+suite.host+`"} 1`)
+	suite.Contains(text, `http_client_connections_total{host="`+suite.host+`",reused="false"} 1`)
+	suite.Contains(text, `http_client_connections_total{host="`+suite.host+`",reused="true"} 2`)
+	suite.Contains(text, `http_client_in_flight_requests{host="`+suite.host+`"} 0`)
+	suite.Contains(text, `http_client_connect_duration_seconds_count{host="`+suite.host+`"} 1`)
+	suite.Contains(text, `http_client_first_byte_duration_seconds_count{host="`+suite.host+`"} 3`)
+
+	// the connection pool is still reachable through the decorator
+	c.CloseIdleConnections()
+}
+
+func (suite *ClientMetricsSuite) TestInFlight() {
+	var (
+		r = NewMetricsRegistry()
+		c = &http.Client{
+			Transport: MetricsConfig{}.ClientMiddleware(r)(nil),
+		}
+	)
+
+	response, err := c.Get(suite.server.URL)
+	suite.Require().NoError(err)
+	suite.Contains(suite.text(r), `http_client_in_flight_requests{host="`+suite.host+`"} 1`)
+
+	response.Body.Close()
+	suite.Contains(suite.text(r), `http_client_in_flight_requests{host="`+suite.host+`"} 0`)
+}
+
+func (suite *ClientMetricsSuite) TestError() {
+	var (
+		r = NewMetricsRegistry()
+		c = &http.Client{
+			Transport: MetricsConfig{}.ClientMiddleware(r)(nil),
+		}
+	)
+
+	_, err := c.Get("http://[::1]:1/")
+	suite.Error(err)
+
+	text := suite.text(r)
+	suite.Contains(text, `http_client_requests_total{class="error",host="[::1]:1"} 1`)
+	suite.Contains(text, `http_client_in_flight_requests{host="[::1]:1"} 0`)
+}
+
+func (suite *ClientMetricsSuite) TestProvideClient() {
+	var (
+		r      = NewMetricsRegistry()
+		client *http.Client
+	)
+
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			fx.Annotate(r, fx.As(new(Registry))),
+			fx.Annotated{
+				Name: "client.config",
+				Target: ClientConfig{
+					Metrics: MetricsConfig{Enabled: true},
+				},
+			},
+		),
+		ProvideClient("client"),
+		fx.Populate(
+			fx.Annotate(
+				&client,
+				arrange.Tags().Name("client").ParamTags(),
+			),
+		),
+	)
+
+	app.RequireStart()
+	defer app.RequireStop()
+
+	suite.get(client, "/")
+	suite.Contains(
+		suite.text(r),
+		`http_client_requests_total{class="2xx",client="client",host="`+suite.host+`"} 1`,
+	)
+}
+
+// countingRegistry is a Registry that counts the metrics obtained through it.
+type countingRegistry struct {
+	Registry
+	lookups atomic.Int32
+}
+
+func (cr *countingRegistry) Counter(name, help string, labels Labels) Counter {
+	cr.lookups.Add(1)
+	return cr.Registry.Counter(name, help, labels)
+}
+
+func (cr *countingRegistry) Gauge(name, help string, labels Labels) Gauge {
+	cr.lookups.Add(1)
+	return cr.Registry.Gauge(name, help, labels)
+}
+
+func (cr *countingRegistry) Histogram(name, help string, buckets []float64, labels Labels) Histogram {
+	cr.lookups.Add(1)
+	return cr.Registry.Histogram(name, help, buckets, labels)
+}
+
+func (suite *ClientMetricsSuite) TestMetricsResolvedOnce() {
+	var (
+		r         = &countingRegistry{Registry: NewMetricsRegistry()}
+		transport = new(http.Transport)
+		c         = &http.Client{
+			Transport: MetricsConfig{}.ClientMiddleware(r)(transport),
+		}
+	)
+
+	defer transport.CloseIdleConnections()
+	suite.get(c, "/")
+	lookups := r.lookups.Load()
+	suite.Positive(lookups)
+
+	suite.get(c, "/")
+	suite.get(c, "/")
+	suite.Equal(lookups, r.lookups.Load())
+
+	// a new status class requires only its counter
+	suite.get(c, "/missing")
+	suite.Equal(lookups+1, r.lookups.Load())
+}
+
+func (suite *ClientMetricsSuite) TestProvideClientBalanced() {
+	var (
+		r      = NewMetricsRegistry()
+		client *http.Client
+	)
+
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			fx.Annotate(r, fx.As(new(Registry))),
+			fx.Annotated{
+				Name: "client.config",
+				Target: ClientConfig{
+					Metrics: MetricsConfig{Enabled: true},
+					Balancer: BalancerConfig{
+						Endpoints: []string{suite.server.URL},
+					},
+				},
+			},
+		),
+		ProvideClient("client"),
+		fx.Populate(
+			fx.Annotate(
+				&client,
+				arrange.Tags().Name("client").ParamTags(),
+			),
+		),
+	)
+
+	app.RequireStart()
+	defer app.RequireStop()
+
+	response, err := client.Get("http://logical.example/")
+	suite.Require().NoError(err)
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+
+	// the request is recorded against the endpoint the balancer selected
+	text := suite.text(r)
+	suite.Contains(text, `http_client_requests_total{class="2xx",client="client",host="`+suite.host+`"} 1`)
+	suite.NotContains(text, "logical.example")
+}
+
+func TestClientMetrics(t *testing.T) {
+	suite.Run(t, new(ClientMetricsSuite))
+}
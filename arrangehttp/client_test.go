@@ -127,7 +127,9 @@ func (suite *ClientSuite) testProvideClientWithConfig() {
 	app.RequireStop()
 
 	suite.Equal(15*time.Second, client.Timeout)
-	suite.Same(mockTransport, client.Transport)
+	suite.Require().IsType((*clientTracker)(nil), client.Transport)
+	suite.Require().IsType((*requestIDTransport)(nil), client.Transport.(*clientTracker).next)
+	suite.Same(mockTransport, client.Transport.(*clientTracker).next.(*requestIDTransport).next)
 	mockTransport.AssertExpectations()
 }
 
@@ -178,7 +180,9 @@ func (suite *ClientSuite) TestProvideClientCustom() {
 	app.RequireStop()
 
 	suite.Equal(167*time.Second, client.Timeout)
-	suite.Same(mockTransport, client.Transport)
+	suite.Require().IsType((*clientTracker)(nil), client.Transport)
+	suite.Require().IsType((*requestIDTransport)(nil), client.Transport.(*clientTracker).next)
+	suite.Same(mockTransport, client.Transport.(*clientTracker).next.(*requestIDTransport).next)
 	mockTransport.AssertExpectations()
 }
 
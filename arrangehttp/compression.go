@@ -0,0 +1,407 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/xmidt-org/arrange/internal/arrangereflect"
+)
+
+const (
+	// DefaultCompressionMinSize is the minimum response size compressed when
+	// CompressionConfig.MinSize is unset.
+	DefaultCompressionMinSize = 1024
+
+	// EncodingGzip is the gzip content coding.
+	EncodingGzip = "gzip"
+
+	// EncodingDeflate is the deflate content coding.
+	EncodingDeflate = "deflate"
+
+	// EncodingZstd is the zstd content coding.  No encoder is built in for this coding.
+	EncodingZstd = "zstd"
+
+	// EncodingBrotli is the brotli content coding.  No encoder is built in for this coding.
+	EncodingBrotli = "br"
+)
+
+var (
+	// DefaultCompressionContentTypes are the media types compressed when
+	// CompressionConfig.ContentTypes is unset.
+	DefaultCompressionContentTypes = []string{
+		"text/*",
+		"application/json",
+		"application/problem+json",
+		"application/javascript",
+		"application/xml",
+		"image/svg+xml",
+	}
+
+	// DefaultEncodings is the server's order of preference for content codings when
+	// CompressionConfig.Encodings is unset.  Codings without an encoder are skipped.
+	DefaultEncodings = []string{EncodingZstd, EncodingBrotli, EncodingGzip, EncodingDeflate}
+)
+
+// Encoder creates a compressing writer for a content coding.  The level is
+// the configured compression level, or -1 to request the encoder's default.
+type Encoder func(w io.Writer, level int) (io.WriteCloser, error)
+
+// GzipEncoder is the built-in Encoder for the gzip content coding.
+func GzipEncoder(w io.Writer, level int) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, level)
+}
+
+// DeflateEncoder is the built-in Encoder for the deflate content coding.
+func DeflateEncoder(w io.Writer, level int) (io.WriteCloser, error) {
+	return flate.NewWriter(w, level)
+}
+
+// CompressionConfig is the unmarshaled configuration for response compression.
+type CompressionConfig struct {
+	// Enabled turns on response compression.
+	Enabled bool `json:"enabled" yaml:"enabled"`
+
+	// MinSize is the minimum size of a response body that will be compressed.  If unset,
+	// DefaultCompressionMinSize is used.  Responses that are flushed before reaching this
+	// size are compressed if their content type allows it.
+	MinSize int `json:"minSize" yaml:"minSize"`
+
+	// ContentTypes are the media types that will be compressed.  An entry may end with
+	// a wildcard subtype, e.g. "text/*".  If unset, DefaultCompressionContentTypes is used.
+	ContentTypes []string `json:"contentTypes" yaml:"contentTypes"`
+
+	// Level is the compression level, from -2 (Huffman only) to 9 (best compression).
+	// If unset, each encoder's default level is used.
+	Level int `json:"level" yaml:"level"`
+
+	// Encodings is the server's order of preference for content codings, used when a
+	// client accepts several codings equally.  If unset, DefaultEncodings is used.
+	Encodings []string `json:"encodings" yaml:"encodings"`
+
+	// Encoders supplies additional or replacement encoders, keyed by content coding.
+	// This is how codings such as zstd or brotli are plugged in.  Gzip and deflate
+	// are built in.
+	Encoders map[string]Encoder `json:"-" yaml:"-"`
+}
+
+// compression is the compiled form of a CompressionConfig.
+type compression struct {
+	minSize      int
+	contentTypes []string
+	level        int
+	encodings    []string
+	encoders     map[string]Encoder
+}
+
+// allowContentType tests if a Content-Type may be compressed.
+func (c *compression) allowContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, allowed := range c.contentTypes {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok {
+			if strings.HasPrefix(mediaType, prefix) {
+				return true
+			}
+		} else if mediaType == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// negotiate selects the content coding for a request based upon its Accept-Encoding values.
+// The coding with the highest quality value wins, with ties going to the server's preference.
+// This method returns the empty string if no coding is acceptable.
+func (c *compression) negotiate(acceptEncoding []string) (encoding string) {
+	var (
+		qvalues  = make(map[string]float64, len(c.encodings))
+		starQ    = -1.0
+		selected = 0.0
+	)
+
+	for _, value := range acceptEncoding {
+		for _, item := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(item, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			q := 1.0
+			if qv, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				var err error
+				if q, err = strconv.ParseFloat(qv, 64); err != nil || q < 0.0 || q > 1.0 {
+					continue
+				}
+			}
+
+			switch name {
+			case "":
+			case "*":
+				starQ = q
+			default:
+				qvalues[name] = q
+			}
+		}
+	}
+
+	for _, candidate := range c.encodings {
+		q, ok := qvalues[candidate]
+		if !ok {
+			q = starQ
+		}
+
+		if q > selected {
+			encoding, selected = candidate, q
+		}
+	}
+
+	return
+}
+
+func (c *compression) then(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Header().Add("Vary", "Accept-Encoding")
+		encoding := c.negotiate(request.Header.Values("Accept-Encoding"))
+		if len(encoding) == 0 || request.Method == http.MethodHead {
+			next.ServeHTTP(response, request)
+			return
+		}
+
+		cw := &compressWriter{
+			ResponseWriter: response,
+			compression:    c,
+			encoding:       encoding,
+		}
+
+		defer cw.close()
+		next.ServeHTTP(cw, request)
+	})
+}
+
+// compressWriter is the http.ResponseWriter that buffers a response until it
+// can decide whether to compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	compression *compression
+	encoding    string
+
+	code     int
+	buffer   []byte
+	decided  bool
+	hijacked bool
+	encoder  io.WriteCloser
+}
+
+// eligible tests if the response, as it stands, can be compressed.
+func (cw *compressWriter) eligible() bool {
+	h := cw.Header()
+	return cw.code >= 200 &&
+		cw.code != http.StatusNoContent &&
+		cw.code != http.StatusNotModified &&
+		len(h.Get("Content-Encoding")) == 0 &&
+		len(h.Get("Content-Range")) == 0 &&
+		cw.compression.allowContentType(h.Get("Content-Type"))
+}
+
+// decide writes the response header, compressing the body if the response is eligible.
+// The sized flag indicates whether the body is large enough, or has been flushed.
+func (cw *compressWriter) decide(sized bool) (err error) {
+	cw.decided = true
+	if cw.code == 0 {
+		cw.code = http.StatusOK
+	}
+
+	h := cw.Header()
+	if len(h.Get("Content-Type")) == 0 && len(cw.buffer) > 0 {
+		// once compressed, net/http can no longer sniff the content type
+		h.Set("Content-Type", http.DetectContentType(cw.buffer))
+	}
+
+	if sized && cw.eligible() {
+		encoder, encoderErr := cw.compression.encoders[cw.encoding](cw.ResponseWriter, cw.compression.level)
+		if encoderErr == nil {
+			h.Del("Content-Length")
+			h.Set("Content-Encoding", cw.encoding)
+			cw.encoder = encoder
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.code)
+	if len(cw.buffer) > 0 {
+		buffer := cw.buffer
+		cw.buffer = nil
+		_, err = cw.write(buffer)
+	}
+
+	return
+}
+
+func (cw *compressWriter) write(p []byte) (int, error) {
+	if cw.encoder != nil {
+		return cw.encoder.Write(p)
+	}
+
+	return cw.ResponseWriter.Write(p)
+}
+
+// WriteHeader records the status code until the response is decided.
+// Informational responses are written immediately.
+func (cw *compressWriter) WriteHeader(code int) {
+	switch {
+	case cw.decided || (code >= 100 && code < 200):
+		cw.ResponseWriter.WriteHeader(code)
+
+	case cw.code == 0:
+		cw.code = code
+	}
+}
+
+// Write buffers output until the minimum size is reached.
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.decided {
+		return cw.write(p)
+	}
+
+	cw.buffer = append(cw.buffer, p...)
+	if len(cw.buffer) >= cw.compression.minSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush decides the response, if necessary, and flushes any compressed output.
+func (cw *compressWriter) Flush() {
+	if cw.hijacked {
+		return
+	}
+
+	if !cw.decided {
+		cw.decide(true)
+	}
+
+	if f, ok := cw.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack passes through to the decorated writer, if it supports hijacking.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	conn, rw, err := h.Hijack()
+	cw.hijacked = err == nil
+	return conn, rw, err
+}
+
+// Unwrap returns the decorated writer, for use by http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close finishes the response once the handler returns.
+func (cw *compressWriter) close() {
+	if cw.hijacked {
+		return
+	}
+
+	if !cw.decided {
+		cw.decide(false)
+	}
+
+	if cw.encoder != nil {
+		cw.encoder.Close()
+	}
+}
+
+// Middleware returns a server middleware that compresses responses according to each
+// request's Accept-Encoding.  Every response varies by Accept-Encoding.  Responses to
+// HEAD requests, partial responses, and responses that already have a Content-Encoding
+// are never compressed.
+//
+// If Enabled is false, this method returns a nil middleware.
+func (cc CompressionConfig) Middleware() (func(http.Handler) http.Handler, error) {
+	if !cc.Enabled {
+		return nil, nil
+	}
+
+	if cc.Level < gzip.HuffmanOnly || cc.Level > gzip.BestCompression {
+		return nil, fmt.Errorf("Invalid compression level: %d", cc.Level)
+	}
+
+	c := &compression{
+		minSize:      cc.MinSize,
+		contentTypes: cc.ContentTypes,
+		level:        cc.Level,
+		encoders: map[string]Encoder{
+			EncodingGzip:    GzipEncoder,
+			EncodingDeflate: DeflateEncoder,
+		},
+	}
+
+	if c.minSize <= 0 {
+		c.minSize = DefaultCompressionMinSize
+	}
+
+	if len(c.contentTypes) == 0 {
+		c.contentTypes = DefaultCompressionContentTypes
+	}
+
+	c.contentTypes = slices.Clone(c.contentTypes)
+	for i, ct := range c.contentTypes {
+		c.contentTypes[i] = strings.ToLower(ct)
+	}
+
+	if c.level == 0 {
+		c.level = gzip.DefaultCompression
+	}
+
+	for encoding, encoder := range cc.Encoders {
+		c.encoders[strings.ToLower(encoding)] = encoder
+	}
+
+	preference := cc.Encodings
+	if len(preference) == 0 {
+		preference = DefaultEncodings
+	}
+
+	for _, encoding := range preference {
+		encoding = strings.ToLower(encoding)
+		if c.encoders[encoding] != nil {
+			c.encodings = append(c.encodings, encoding)
+		} else if len(cc.Encodings) > 0 {
+			return nil, fmt.Errorf("No encoder for content coding: %s", encoding)
+		}
+	}
+
+	return c.then, nil
+}
+
+// Apply allows a CompressionConfig to be used as an Option[http.Server].  If Enabled
+// is false, this method does nothing.
+func (cc CompressionConfig) Apply(s *http.Server) error {
+	m, err := cc.Middleware()
+	if m != nil {
+		s.Handler = m(arrangereflect.Safe[http.Handler](s.Handler, http.DefaultServeMux))
+	}
+
+	return err
+}
@@ -0,0 +1,330 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CompressionSuite struct {
+	suite.Suite
+}
+
+// serve sends a request through compression middleware.
+func (suite *CompressionSuite) serve(cc CompressionConfig, acceptEncoding string, h http.HandlerFunc) *httptest.ResponseRecorder {
+	cc.Enabled = true
+	m, err := cc.Middleware()
+	suite.Require().NoError(err)
+	suite.Require().NotNil(m)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	if len(acceptEncoding) > 0 {
+		request.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+
+	response := httptest.NewRecorder()
+	m(h).ServeHTTP(response, request)
+	return response
+}
+
+func (suite *CompressionSuite) gunzip(r io.Reader) string {
+	gr, err := gzip.NewReader(r)
+	suite.Require().NoError(err)
+	b, err := io.ReadAll(gr)
+	suite.Require().NoError(err)
+	return string(b)
+}
+
+func (suite *CompressionSuite) TestDisabled() {
+	m, err := CompressionConfig{}.Middleware()
+	suite.NoError(err)
+	suite.Nil(m)
+
+	s := &http.Server{}
+	suite.NoError(CompressionConfig{}.Apply(s))
+	suite.Nil(s.Handler)
+}
+
+func (suite *CompressionSuite) TestInvalid() {
+	for _, invalid := range []CompressionConfig{
+		{Enabled: true, Level: 10},
+		{Enabled: true, Level: -3},
+		{Enabled: true, Encodings: []string{"zstd"}},
+	} {
+		m, err := invalid.Middleware()
+		suite.Error(err)
+		suite.Nil(m)
+	}
+
+	suite.Error(ServerConfig{Compression: CompressionConfig{Enabled: true, Level: 10}}.Apply(new(http.Server)))
+}
+
+func (suite *CompressionSuite) TestNegotiate() {
+	c := &compression{
+		encodings: []string{EncodingBrotli, EncodingGzip, EncodingDeflate},
+	}
+
+	testData := []struct {
+		acceptEncoding []string
+		expected       string
+	}{
+		{nil, ""},
+		{[]string{"identity"}, ""},
+		{[]string{"gzip"}, EncodingGzip},
+		{[]string{"deflate, gzip"}, EncodingGzip},
+		{[]string{"deflate", "GZIP;q=0.5"}, EncodingDeflate},
+		{[]string{"gzip;q=0.5, deflate;q=0.9"}, EncodingDeflate},
+		{[]string{"*"}, EncodingBrotli},
+		{[]string{"*, br;q=0"}, EncodingGzip},
+		{[]string{"*;q=0"}, ""},
+		{[]string{"gzip;q=bad, deflate;q=2, br;q=0.1"}, EncodingBrotli},
+		{[]string{"gzip;q=0.001"}, EncodingGzip},
+		{[]string{"zstd"}, ""},
+	}
+
+	for _, record := range testData {
+		suite.Equal(record.expected, c.negotiate(record.acceptEncoding), record.acceptEncoding)
+	}
+}
+
+func (suite *CompressionSuite) TestGzip() {
+	body := strings.Repeat("compress me please ", 100)
+	response := suite.serve(CompressionConfig{}, "gzip, deflate", func(response http.ResponseWriter, _ *http.Request) {
+		response.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		response.Header().Set("Content-Length", "1900")
+		response.WriteHeader(299)
+		io.WriteString(response, body[:500])
+		io.WriteString(response, body[500:])
+	})
+
+	suite.Equal(299, response.Code)
+	suite.Equal(EncodingGzip, response.Header().Get("Content-Encoding"))
+	suite.Empty(response.Header().Get("Content-Length"))
+	suite.Equal([]string{"Accept-Encoding"}, response.Header().Values("Vary"))
+	suite.Less(response.Body.Len(), len(body))
+	suite.Equal(body, suite.gunzip(response.Body))
+}
+
+func (suite *CompressionSuite) TestDeflate() {
+	body := strings.Repeat("x", 2048)
+	response := suite.serve(CompressionConfig{Level: flate.BestSpeed}, "deflate", func(response http.ResponseWriter, _ *http.Request) {
+		response.Header().Set("Content-Type", "application/json")
+		io.WriteString(response, body)
+	})
+
+	suite.Equal(http.StatusOK, response.Code)
+	suite.Equal(EncodingDeflate, response.Header().Get("Content-Encoding"))
+
+	b, err := io.ReadAll(flate.NewReader(response.Body))
+	suite.Require().NoError(err)
+	suite.Equal(body, string(b))
+}
+
+func (suite *CompressionSuite) TestCustomEncoder() {
+	var used bool
+	cc := CompressionConfig{
+		Encodings: []string{"zstd", "gzip"},
+		Encoders: map[string]Encoder{
+			"zstd": func(w io.Writer, level int) (io.WriteCloser, error) {
+				used = true
+				suite.Equal(gzip.DefaultCompression, level)
+				return GzipEncoder(w, level)
+			},
+		},
+	}
+
+	response := suite.serve(cc, "gzip, zstd", func(response http.ResponseWriter, _ *http.Request) {
+		io.WriteString(response, strings.Repeat("<p>hello</p>", 200))
+	})
+
+	suite.True(used)
+	suite.Equal(EncodingZstd, response.Header().Get("Content-Encoding"))
+	suite.Equal("text/html; charset=utf-8", response.Header().Get("Content-Type"))
+}
+
+func (suite *CompressionSuite) TestNotCompressed() {
+	large := strings.Repeat("a", 4096)
+	testData := []struct {
+		name           string
+		acceptEncoding string
+		handler        http.HandlerFunc
+		expectedBody   string
+	}{
+		{
+			name:           "no Accept-Encoding",
+			acceptEncoding: "",
+			handler: func(response http.ResponseWriter, _ *http.Request) {
+				response.Header().Set("Content-Type", "text/plain")
+				io.WriteString(response, large)
+			},
+			expectedBody: large,
+		},
+		{
+			name:           "too small",
+			acceptEncoding: "gzip",
+			handler: func(response http.ResponseWriter, _ *http.Request) {
+				response.Header().Set("Content-Type", "text/plain")
+				io.WriteString(response, "small")
+			},
+			expectedBody: "small",
+		},
+		{
+			name:           "content type",
+			acceptEncoding: "gzip",
+			handler: func(response http.ResponseWriter, _ *http.Request) {
+				response.Header().Set("Content-Type", "image/png")
+				io.WriteString(response, large)
+			},
+			expectedBody: large,
+		},
+		{
+			name:           "already encoded",
+			acceptEncoding: "gzip",
+			handler: func(response http.ResponseWriter, _ *http.Request) {
+				response.Header().Set("Content-Type", "text/plain")
+				response.Header().Set("Content-Encoding", "custom")
+				io.WriteString(response, large)
+			},
+			expectedBody: large,
+		},
+		{
+			name:           "partial",
+			acceptEncoding: "gzip",
+			handler: func(response http.ResponseWriter, _ *http.Request) {
+				response.Header().Set("Content-Type", "text/plain")
+				response.Header().Set("Content-Range", "bytes 0-4095/8192")
+				response.WriteHeader(http.StatusPartialContent)
+				io.WriteString(response, large)
+			},
+			expectedBody: large,
+		},
+		{
+			name:           "no body",
+			acceptEncoding: "gzip",
+			handler: func(response http.ResponseWriter, _ *http.Request) {
+				response.WriteHeader(http.StatusNoContent)
+			},
+			expectedBody: "",
+		},
+	}
+
+	for _, record := range testData {
+		suite.Run(record.name, func() {
+			response := suite.serve(CompressionConfig{}, record.acceptEncoding, record.handler)
+			suite.NotEqual(EncodingGzip, response.Header().Get("Content-Encoding"))
+			suite.Equal("Accept-Encoding", response.Header().Get("Vary"))
+			suite.Equal(record.expectedBody, response.Body.String())
+		})
+	}
+}
+
+func (suite *CompressionSuite) TestHead() {
+	m, err := CompressionConfig{Enabled: true}.Middleware()
+	suite.Require().NoError(err)
+
+	request := httptest.NewRequest("HEAD", "/", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	response := httptest.NewRecorder()
+	m(http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		response.Header().Set("Content-Type", "text/plain")
+		response.Header().Set("Content-Length", "10000")
+	})).ServeHTTP(response, request)
+
+	suite.Empty(response.Header().Get("Content-Encoding"))
+	suite.Equal("10000", response.Header().Get("Content-Length"))
+}
+
+func (suite *CompressionSuite) TestFlush() {
+	response := suite.serve(CompressionConfig{}, "gzip", func(response http.ResponseWriter, _ *http.Request) {
+		response.Header().Set("Content-Type", "application/octet-stream")
+		io.WriteString(response, "data: first\n\n")
+		suite.NoError(http.NewResponseController(response).Flush())
+	})
+
+	suite.True(response.Flushed)
+	suite.Empty(response.Header().Get("Content-Encoding"))
+	suite.Equal("data: first\n\n", response.Body.String())
+
+	response = suite.serve(CompressionConfig{}, "gzip", func(response http.ResponseWriter, _ *http.Request) {
+		response.Header().Set("Content-Type", "text/plain")
+		io.WriteString(response, "first")
+		suite.NoError(http.NewResponseController(response).Flush())
+		io.WriteString(response, " second")
+	})
+
+	suite.True(response.Flushed)
+	suite.Equal(EncodingGzip, response.Header().Get("Content-Encoding"))
+	suite.Equal("first second", suite.gunzip(response.Body))
+}
+
+// hijackRecorder is an httptest.ResponseRecorder that can be hijacked.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (hr *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hr.conn, nil, nil
+}
+
+func (suite *CompressionSuite) TestHijack() {
+	m, err := CompressionConfig{Enabled: true}.Middleware()
+	suite.Require().NoError(err)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+
+	// not supported
+	response := httptest.NewRecorder()
+	m(http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		_, _, err := http.NewResponseController(response).Hijack()
+		suite.ErrorIs(err, http.ErrNotSupported)
+	})).ServeHTTP(response, request)
+
+	// supported
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	hr := &hijackRecorder{ResponseRecorder: httptest.NewRecorder(), conn: server}
+	m(http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		conn, _, err := http.NewResponseController(response).Hijack()
+		suite.NoError(err)
+		suite.Equal(server, conn)
+	})).ServeHTTP(hr, request)
+
+	suite.False(hr.ResponseRecorder.Flushed)
+	suite.Zero(hr.ResponseRecorder.Body.Len())
+}
+
+func (suite *CompressionSuite) TestServerConfig() {
+	s := &http.Server{
+		Handler: http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+			io.WriteString(response, strings.Repeat("text ", 500))
+		}),
+	}
+
+	suite.Require().NoError(ServerConfig{Compression: CompressionConfig{Enabled: true}}.Apply(s))
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	response := httptest.NewRecorder()
+	s.Handler.ServeHTTP(response, request)
+	suite.Equal(EncodingGzip, response.Header().Get("Content-Encoding"))
+	suite.Equal(strings.Repeat("text ", 500), suite.gunzip(response.Body))
+}
+
+func TestCompression(t *testing.T) {
+	suite.Run(t, new(CompressionSuite))
+}
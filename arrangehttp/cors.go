@@ -0,0 +1,287 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xmidt-org/arrange/internal/arrangereflect"
+)
+
+const (
+	// OriginHeader is the request header that identifies the origin of a cross-origin request.
+	OriginHeader = "Origin"
+
+	// AccessControlRequestMethodHeader is the preflight request header that carries the
+	// method of the actual request.
+	AccessControlRequestMethodHeader = "Access-Control-Request-Method"
+
+	// AccessControlRequestHeadersHeader is the preflight request header that carries the
+	// headers of the actual request.
+	AccessControlRequestHeadersHeader = "Access-Control-Request-Headers"
+
+	// AccessControlAllowOriginHeader is the response header that grants access to an origin.
+	AccessControlAllowOriginHeader = "Access-Control-Allow-Origin"
+
+	// AccessControlAllowMethodsHeader is the preflight response header that lists the allowed methods.
+	AccessControlAllowMethodsHeader = "Access-Control-Allow-Methods"
+
+	// AccessControlAllowHeadersHeader is the preflight response header that lists the allowed headers.
+	AccessControlAllowHeadersHeader = "Access-Control-Allow-Headers"
+
+	// AccessControlExposeHeadersHeader is the response header that lists the headers a browser
+	// exposes to scripts.
+	AccessControlExposeHeadersHeader = "Access-Control-Expose-Headers"
+
+	// AccessControlAllowCredentialsHeader is the response header that allows credentialed requests.
+	AccessControlAllowCredentialsHeader = "Access-Control-Allow-Credentials"
+
+	// AccessControlMaxAgeHeader is the preflight response header that controls how long a
+	// preflight response may be cached.
+	AccessControlMaxAgeHeader = "Access-Control-Max-Age"
+)
+
+var (
+	// DefaultCORSMethods are the methods allowed when CORSConfig.AllowedMethods is unset.
+	DefaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+
+	// ErrCORSAnyOriginWithCredentials indicates that a CORSConfig allowed any origin together
+	// with credentials, which would expose credentialed responses to every site.
+	ErrCORSAnyOriginWithCredentials = errors.New("CORS cannot allow any origin together with credentials")
+)
+
+// CORSConfig is the unmarshaled configuration for Cross-Origin Resource Sharing.
+type CORSConfig struct {
+	// AllowedOrigins are the origins allowed to make cross-origin requests.  Each origin
+	// may contain at most one wildcard, e.g. "https://*.example.com".  A single "*" allows
+	// any origin, and cannot be used with AllowCredentials.  If unset, CORS is disabled.
+	AllowedOrigins []string `json:"allowedOrigins" yaml:"allowedOrigins"`
+
+	// AllowedMethods are the methods allowed for cross-origin requests.  If unset,
+	// DefaultCORSMethods is used.
+	AllowedMethods []string `json:"allowedMethods" yaml:"allowedMethods"`
+
+	// AllowedHeaders are the request headers allowed for cross-origin requests.  A single
+	// "*" allows any headers.
+	AllowedHeaders []string `json:"allowedHeaders" yaml:"allowedHeaders"`
+
+	// ExposedHeaders are the response headers that browsers expose to scripts.
+	ExposedHeaders []string `json:"exposedHeaders" yaml:"exposedHeaders"`
+
+	// AllowCredentials allows cross-origin requests to include credentials, such as cookies.
+	AllowCredentials bool `json:"allowCredentials" yaml:"allowCredentials"`
+
+	// MaxAge is how long browsers may cache preflight responses.  It is sent in whole seconds.
+	// If unset, no max age is sent and browsers use their own default.
+	MaxAge time.Duration `json:"maxAge" yaml:"maxAge"`
+}
+
+// originPattern matches origins against a pattern with an optional wildcard.
+type originPattern struct {
+	prefix, suffix string
+	wildcard       bool
+}
+
+func (op originPattern) matches(origin string) bool {
+	if !op.wildcard {
+		return origin == op.prefix
+	}
+
+	return len(origin) >= len(op.prefix)+len(op.suffix) &&
+		strings.HasPrefix(origin, op.prefix) &&
+		strings.HasSuffix(origin, op.suffix)
+}
+
+// cors is the compiled form of a CORSConfig.
+type cors struct {
+	anyOrigin      bool
+	origins        []originPattern
+	methods        []string
+	anyHeader      bool
+	headers        []string
+	allowMethods   string
+	exposedHeaders string
+	credentials    bool
+	maxAge         string
+}
+
+func (c *cors) allowOrigin(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	for _, op := range c.origins {
+		if op.matches(origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowHeaders tests if all the headers in an Access-Control-Request-Headers value are allowed.
+func (c *cors) allowHeaders(requested string) bool {
+	if c.anyHeader {
+		return true
+	}
+
+	for _, h := range strings.Split(requested, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); len(h) > 0 && !slices.Contains(c.headers, h) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// setOrigin writes the response headers common to preflight and actual requests.
+func (c *cors) setOrigin(h http.Header, origin string) {
+	if c.anyOrigin {
+		h.Set(AccessControlAllowOriginHeader, "*")
+	} else {
+		h.Set(AccessControlAllowOriginHeader, origin)
+	}
+
+	if c.credentials {
+		h.Set(AccessControlAllowCredentialsHeader, "true")
+	}
+}
+
+func (c *cors) preflight(response http.ResponseWriter, request *http.Request) {
+	h := response.Header()
+	h.Add("Vary", OriginHeader)
+	h.Add("Vary", AccessControlRequestMethodHeader)
+	h.Add("Vary", AccessControlRequestHeadersHeader)
+
+	var (
+		origin    = request.Header.Get(OriginHeader)
+		requested = strings.Join(request.Header.Values(AccessControlRequestHeadersHeader), ",")
+	)
+
+	if c.allowOrigin(origin) &&
+		slices.Contains(c.methods, request.Header.Get(AccessControlRequestMethodHeader)) &&
+		c.allowHeaders(requested) {
+		c.setOrigin(h, origin)
+		h.Set(AccessControlAllowMethodsHeader, c.allowMethods)
+		if len(strings.TrimSpace(requested)) > 0 {
+			// echoing the request is permitted, since every requested header is allowed
+			h.Set(AccessControlAllowHeadersHeader, requested)
+		}
+
+		if len(c.maxAge) > 0 {
+			h.Set(AccessControlMaxAgeHeader, c.maxAge)
+		}
+	}
+
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func (c *cors) then(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		origin := request.Header.Get(OriginHeader)
+		switch {
+		case len(origin) == 0:
+			if !c.anyOrigin {
+				// caches must not reuse this response for cross-origin requests
+				response.Header().Add("Vary", OriginHeader)
+			}
+
+			next.ServeHTTP(response, request)
+
+		case request.Method == http.MethodOptions && len(request.Header.Get(AccessControlRequestMethodHeader)) > 0:
+			c.preflight(response, request)
+
+		default:
+			h := response.Header()
+			h.Add("Vary", OriginHeader)
+			if c.allowOrigin(origin) {
+				c.setOrigin(h, origin)
+				if len(c.exposedHeaders) > 0 {
+					h.Set(AccessControlExposeHeadersHeader, c.exposedHeaders)
+				}
+			}
+
+			next.ServeHTTP(response, request)
+		}
+	})
+}
+
+// Middleware returns a server middleware that implements CORS.  Preflight requests are
+// answered by the returned middleware and are never passed to the decorated handler.
+// Every cross-origin response varies by Origin.  Unless any origin is allowed, responses to
+// requests without an Origin also vary by Origin.
+//
+// If AllowedOrigins is unset, this method returns a nil middleware.
+func (cc CORSConfig) Middleware() (func(http.Handler) http.Handler, error) {
+	if len(cc.AllowedOrigins) == 0 {
+		return nil, nil
+	}
+
+	c := &cors{
+		credentials: cc.AllowCredentials,
+	}
+
+	for _, o := range cc.AllowedOrigins {
+		o = strings.ToLower(strings.TrimSpace(o))
+		if o == "*" {
+			c.anyOrigin = true
+			continue
+		}
+
+		prefix, suffix, wildcard := strings.Cut(o, "*")
+		if len(o) == 0 || strings.Contains(suffix, "*") {
+			return nil, fmt.Errorf("Invalid CORS origin: %s", o)
+		}
+
+		c.origins = append(c.origins, originPattern{prefix: prefix, suffix: suffix, wildcard: wildcard})
+	}
+
+	if c.anyOrigin && c.credentials {
+		return nil, ErrCORSAnyOriginWithCredentials
+	}
+
+	c.methods = cc.AllowedMethods
+	if len(c.methods) == 0 {
+		c.methods = DefaultCORSMethods
+	}
+
+	c.methods = slices.Clone(c.methods)
+	for i, m := range c.methods {
+		c.methods[i] = strings.ToUpper(m)
+	}
+
+	c.allowMethods = strings.Join(c.methods, ", ")
+	for _, h := range cc.AllowedHeaders {
+		if h == "*" {
+			c.anyHeader = true
+		} else {
+			c.headers = append(c.headers, strings.ToLower(h))
+		}
+	}
+
+	c.exposedHeaders = strings.Join(cc.ExposedHeaders, ", ")
+	if cc.MaxAge > 0 {
+		c.maxAge = strconv.FormatInt(int64(cc.MaxAge/time.Second), 10)
+	}
+
+	return c.then, nil
+}
+
+// Apply allows a CORSConfig to be used as an Option[http.Server].  If AllowedOrigins
+// is unset, this method does nothing.
+func (cc CORSConfig) Apply(s *http.Server) error {
+	m, err := cc.Middleware()
+	if m != nil {
+		s.Handler = m(arrangereflect.Safe[http.Handler](s.Handler, http.DefaultServeMux))
+	}
+
+	return err
+}
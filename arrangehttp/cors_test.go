@@ -0,0 +1,212 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type CORSSuite struct {
+	suite.Suite
+}
+
+// serve sends a request through CORS middleware, returning the response and
+// whether the decorated handler was invoked.
+func (suite *CORSSuite) serve(cc CORSConfig, method string, header http.Header) (response *httptest.ResponseRecorder, called bool) {
+	m, err := cc.Middleware()
+	suite.Require().NoError(err)
+	suite.Require().NotNil(m)
+
+	h := m(http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		called = true
+		response.WriteHeader(299)
+	}))
+
+	request := httptest.NewRequest(method, "/", nil)
+	for name, values := range header {
+		request.Header[name] = values
+	}
+
+	response = httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+	return
+}
+
+func (suite *CORSSuite) TestDisabled() {
+	m, err := CORSConfig{}.Middleware()
+	suite.NoError(err)
+	suite.Nil(m)
+
+	s := &http.Server{}
+	suite.NoError(CORSConfig{}.Apply(s))
+	suite.Nil(s.Handler)
+}
+
+func (suite *CORSSuite) TestInvalid() {
+	for _, invalid := range []string{"", "https://*.*.example.com"} {
+		m, err := CORSConfig{AllowedOrigins: []string{invalid}}.Middleware()
+		suite.Error(err, invalid)
+		suite.Nil(m)
+	}
+
+	s := &http.Server{}
+	suite.Error(ServerConfig{CORS: CORSConfig{AllowedOrigins: []string{""}}}.Apply(s))
+}
+
+func (suite *CORSSuite) TestNoOrigin() {
+	response, called := suite.serve(CORSConfig{AllowedOrigins: []string{"*"}}, "GET", nil)
+	suite.True(called)
+	suite.Empty(response.Header().Get(AccessControlAllowOriginHeader))
+	suite.Empty(response.Header().Values("Vary"))
+
+	// a response that lacks CORS headers only for some origins varies by Origin
+	response, called = suite.serve(CORSConfig{AllowedOrigins: []string{"https://example.com"}}, "GET", nil)
+	suite.True(called)
+	suite.Empty(response.Header().Get(AccessControlAllowOriginHeader))
+	suite.Equal([]string{OriginHeader}, response.Header().Values("Vary"))
+}
+
+func (suite *CORSSuite) TestActualRequest() {
+	cc := CORSConfig{
+		AllowedOrigins: []string{"https://example.com", "https://*.example.net"},
+		ExposedHeaders: []string{"X-Total", "X-Next"},
+	}
+
+	for _, allowed := range []string{"https://example.com", "https://api.example.net", "HTTPS://API.EXAMPLE.NET"} {
+		response, called := suite.serve(cc, "PUT", http.Header{OriginHeader: {allowed}})
+		suite.True(called)
+		suite.Equal(299, response.Code)
+		suite.Equal(allowed, response.Header().Get(AccessControlAllowOriginHeader))
+		suite.Equal("X-Total, X-Next", response.Header().Get(AccessControlExposeHeadersHeader))
+		suite.Empty(response.Header().Get(AccessControlAllowCredentialsHeader))
+		suite.Equal([]string{OriginHeader}, response.Header().Values("Vary"))
+	}
+
+	for _, denied := range []string{"https://evil.com", "https://example.net", "http://example.com"} {
+		response, called := suite.serve(cc, "GET", http.Header{OriginHeader: {denied}})
+		suite.True(called)
+		suite.Empty(response.Header().Get(AccessControlAllowOriginHeader), denied)
+		suite.Equal([]string{OriginHeader}, response.Header().Values("Vary"))
+	}
+}
+
+func (suite *CORSSuite) TestAnyOrigin() {
+	response, _ := suite.serve(CORSConfig{AllowedOrigins: []string{"*"}}, "GET", http.Header{OriginHeader: {"https://example.com"}})
+	suite.Equal("*", response.Header().Get(AccessControlAllowOriginHeader))
+
+	suite.Empty(response.Header().Get(AccessControlAllowCredentialsHeader))
+
+	// any origin cannot be combined with credentials
+	m, err := CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}.Middleware()
+	suite.ErrorIs(err, ErrCORSAnyOriginWithCredentials)
+	suite.Nil(m)
+
+	s := &http.Server{}
+	suite.ErrorIs(
+		ServerConfig{CORS: CORSConfig{AllowedOrigins: []string{"https://example.com", " * "}, AllowCredentials: true}}.Apply(s),
+		ErrCORSAnyOriginWithCredentials,
+	)
+}
+
+func (suite *CORSSuite) TestPreflight() {
+	cc := CORSConfig{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowedMethods:   []string{"get", "put"},
+		AllowedHeaders:   []string{"Content-Type", "X-Custom"},
+		AllowCredentials: true,
+		MaxAge:           90 * time.Second,
+	}
+
+	response, called := suite.serve(cc, "OPTIONS", http.Header{
+		OriginHeader:                      {"https://app.example.com"},
+		AccessControlRequestMethodHeader:  {"PUT"},
+		AccessControlRequestHeadersHeader: {"content-type, x-custom"},
+	})
+
+	suite.False(called)
+	suite.Equal(http.StatusNoContent, response.Code)
+	suite.Equal("https://app.example.com", response.Header().Get(AccessControlAllowOriginHeader))
+	suite.Equal("GET, PUT", response.Header().Get(AccessControlAllowMethodsHeader))
+	suite.Equal("content-type, x-custom", response.Header().Get(AccessControlAllowHeadersHeader))
+	suite.Equal("true", response.Header().Get(AccessControlAllowCredentialsHeader))
+	suite.Equal("90", response.Header().Get(AccessControlMaxAgeHeader))
+	suite.Contains(response.Header().Values("Vary"), OriginHeader)
+
+	for _, denied := range []http.Header{
+		{OriginHeader: {"https://evil.com"}, AccessControlRequestMethodHeader: {"GET"}},
+		{OriginHeader: {"https://app.example.com"}, AccessControlRequestMethodHeader: {"DELETE"}},
+		{
+			OriginHeader:                      {"https://app.example.com"},
+			AccessControlRequestMethodHeader:  {"GET"},
+			AccessControlRequestHeadersHeader: {"X-Forbidden"},
+		},
+	} {
+		response, called = suite.serve(cc, "OPTIONS", denied)
+		suite.False(called)
+		suite.Equal(http.StatusNoContent, response.Code)
+		suite.Empty(response.Header().Get(AccessControlAllowOriginHeader))
+		suite.Empty(response.Header().Get(AccessControlAllowMethodsHeader))
+	}
+
+	// an OPTIONS request that isn't a preflight goes to the handler
+	response, called = suite.serve(cc, "OPTIONS", http.Header{OriginHeader: {"https://app.example.com"}})
+	suite.True(called)
+	suite.Equal("https://app.example.com", response.Header().Get(AccessControlAllowOriginHeader))
+}
+
+func (suite *CORSSuite) TestPreflightAnyHeader() {
+	response, _ := suite.serve(
+		CORSConfig{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}},
+		"OPTIONS",
+		http.Header{
+			OriginHeader:                      {"https://example.com"},
+			AccessControlRequestMethodHeader:  {"POST"},
+			AccessControlRequestHeadersHeader: {"X-Anything"},
+		},
+	)
+
+	suite.Equal("*", response.Header().Get(AccessControlAllowOriginHeader))
+	suite.Equal("X-Anything", response.Header().Get(AccessControlAllowHeadersHeader))
+	suite.Empty(response.Header().Get(AccessControlMaxAgeHeader))
+}
+
+func (suite *CORSSuite) TestServerConfig() {
+	s := &http.Server{
+		Handler: http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+			response.WriteHeader(299)
+		}),
+	}
+
+	sc := ServerConfig{
+		Header: http.Header{"X-Server": {"true"}},
+		CORS:   CORSConfig{AllowedOrigins: []string{"https://example.com"}},
+	}
+
+	suite.Require().NoError(sc.Apply(s))
+
+	request := httptest.NewRequest("OPTIONS", "/", nil)
+	request.Header.Set(OriginHeader, "https://example.com")
+	request.Header.Set(AccessControlRequestMethodHeader, "GET")
+	response := httptest.NewRecorder()
+	s.Handler.ServeHTTP(response, request)
+	suite.Equal(http.StatusNoContent, response.Code)
+	suite.Equal("https://example.com", response.Header().Get(AccessControlAllowOriginHeader))
+
+	request = httptest.NewRequest("GET", "/", nil)
+	request.Header.Set(OriginHeader, "https://example.com")
+	response = httptest.NewRecorder()
+	s.Handler.ServeHTTP(response, request)
+	suite.Equal(299, response.Code)
+	suite.Equal("true", response.Header().Get("X-Server"))
+	suite.Equal("https://example.com", response.Header().Get(AccessControlAllowOriginHeader))
+}
+
+func TestCORS(t *testing.T) {
+	suite.Run(t, new(CORSSuite))
+}
@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"log"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	tlsHandshakeErrorPrefix = "http: TLS handshake error from "
+	panicServingPrefix      = "http: panic serving "
+	acceptErrorPrefix       = "http: Accept error: "
+	superfluousPrefix       = "http: superfluous response.WriteHeader call from "
+)
+
+// errorLogWriter is an io.Writer that receives the output of a *log.Logger used
+// as an http.Server.ErrorLog and writes it to a zap logger.
+type errorLogWriter struct {
+	logger *zap.Logger
+}
+
+// splitAddr splits text of the form "addr: message".  Network addresses never
+// contain ": ", even IPv6 addresses in brackets.
+func splitAddr(text string) (addr, message string) {
+	addr, message, _ = strings.Cut(text, ": ")
+	return
+}
+
+// parse interprets the known message shapes that net/http writes to its ErrorLog.
+// Messages that aren't recognized are logged as is.
+func (elw errorLogWriter) parse(text string) (level zapcore.Level, message string, fields []zap.Field) {
+	level = zapcore.ErrorLevel
+	message = text
+
+	switch {
+	case strings.HasPrefix(text, tlsHandshakeErrorPrefix):
+		level = zapcore.WarnLevel
+		message = "TLS handshake error"
+		addr, reason := splitAddr(text[len(tlsHandshakeErrorPrefix):])
+		fields = append(fields, zap.String("remoteAddr", addr), zap.String("error", reason))
+
+	case strings.HasPrefix(text, panicServingPrefix):
+		message = "panic serving request"
+		addr, rest := splitAddr(text[len(panicServingPrefix):])
+		value, stack, _ := strings.Cut(rest, "\n")
+		fields = append(fields, zap.String("remoteAddr", addr), zap.String("panic", value))
+		if len(stack) > 0 {
+			fields = append(fields, zap.String("stack", stack))
+		}
+
+	case strings.HasPrefix(text, acceptErrorPrefix):
+		message = "accept error"
+		reason, delay, found := strings.Cut(text[len(acceptErrorPrefix):], "; retrying in ")
+		fields = append(fields, zap.String("error", reason))
+		if found {
+			fields = append(fields, zap.String("retryIn", delay))
+		}
+
+	case strings.HasPrefix(text, superfluousPrefix):
+		level = zapcore.WarnLevel
+		message = "superfluous response.WriteHeader call"
+		fields = append(fields, zap.String("caller", text[len(superfluousPrefix):]))
+	}
+
+	return
+}
+
+func (elw errorLogWriter) Write(p []byte) (int, error) {
+	level, message, fields := elw.parse(strings.TrimSuffix(string(p), "\n"))
+	if ce := elw.logger.Check(level, message); ce != nil {
+		ce.Write(fields...)
+	}
+
+	return len(p), nil
+}
+
+// NewErrorLog creates a *log.Logger, suitable for http.Server.ErrorLog, that writes to the
+// given zap logger.  Known net/http message shapes, such as TLS handshake errors and handler
+// panics, are parsed into structured fields.  If l is nil, the returned logger discards output.
+func NewErrorLog(l *zap.Logger) *log.Logger {
+	if l == nil {
+		l = zap.NewNop()
+	}
+
+	return log.New(errorLogWriter{logger: l}, "", 0)
+}
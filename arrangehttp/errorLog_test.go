@@ -0,0 +1,172 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"log"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange"
+	"github.com/xmidt-org/arrange/arrangetest"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type ErrorLogSuite struct {
+	suite.Suite
+	logger *zap.Logger
+	logs   *observer.ObservedLogs
+}
+
+func (suite *ErrorLogSuite) SetupTest() {
+	var core zapcore.Core
+	core, suite.logs = observer.New(zapcore.DebugLevel)
+	suite.logger = zap.New(core)
+}
+
+func (suite *ErrorLogSuite) SetupSubTest() {
+	suite.SetupTest()
+}
+
+func (suite *ErrorLogSuite) TestNewErrorLog() {
+	testCases := []struct {
+		name            string
+		text            string
+		expectedLevel   zapcore.Level
+		expectedMessage string
+		expectedFields  map[string]any
+	}{
+		{
+			name:            "TLSHandshake",
+			text:            "http: TLS handshake error from [::1]:56789: remote error: tls: bad certificate",
+			expectedLevel:   zapcore.WarnLevel,
+			expectedMessage: "TLS handshake error",
+			expectedFields: map[string]any{
+				"remoteAddr": "[::1]:56789",
+				"error":      "remote error: tls: bad certificate",
+			},
+		},
+		{
+			name:            "Panic",
+			text:            "http: panic serving 127.0.0.1:1234: oops\ngoroutine 1 [running]:\nmain.main()",
+			expectedLevel:   zapcore.ErrorLevel,
+			expectedMessage: "panic serving request",
+			expectedFields: map[string]any{
+				"remoteAddr": "127.0.0.1:1234",
+				"panic":      "oops",
+				"stack":      "goroutine 1 [running]:\nmain.main()",
+			},
+		},
+		{
+			name:            "AcceptError",
+			text:            "http: Accept error: too many open files; retrying in 5ms",
+			expectedLevel:   zapcore.ErrorLevel,
+			expectedMessage: "accept error",
+			expectedFields: map[string]any{
+				"error":   "too many open files",
+				"retryIn": "5ms",
+			},
+		},
+		{
+			name:            "Superfluous",
+			text:            "http: superfluous response.WriteHeader call from main.handler (main.go:12)",
+			expectedLevel:   zapcore.WarnLevel,
+			expectedMessage: "superfluous response.WriteHeader call",
+			expectedFields: map[string]any{
+				"caller": "main.handler (main.go:12)",
+			},
+		},
+		{
+			name:            "Unrecognized",
+			text:            "http2: something unusual happened",
+			expectedLevel:   zapcore.ErrorLevel,
+			expectedMessage: "http2: something unusual happened",
+			expectedFields:  map[string]any{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		suite.Run(testCase.name, func() {
+			NewErrorLog(suite.logger).Print(testCase.text)
+			entries := suite.logs.TakeAll()
+			suite.Require().Len(entries, 1)
+			suite.Equal(testCase.expectedLevel, entries[0].Level)
+			suite.Equal(testCase.expectedMessage, entries[0].Message)
+			suite.Equal(testCase.expectedFields, entries[0].ContextMap())
+		})
+	}
+}
+
+func (suite *ErrorLogSuite) TestNewErrorLogNilLogger() {
+	l := NewErrorLog(nil)
+	suite.Require().NotNil(l)
+	l.Print("http: this should be discarded")
+}
+
+func (suite *ErrorLogSuite) TestZapErrorLog() {
+	s := new(http.Server)
+	suite.Require().NoError(ZapErrorLog(suite.logger).Apply(s))
+	suite.Require().NotNil(s.ErrorLog)
+
+	s.ErrorLog.Print("test")
+	suite.Equal(1, suite.logs.FilterMessage("test").Len())
+}
+
+func (suite *ErrorLogSuite) testProvideServerInjectedLogger() {
+	var server *http.Server
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(suite.logger),
+		ProvideServer("test"),
+		fx.Populate(
+			fx.Annotate(
+				&server,
+				arrange.Tags().Name("test").ParamTags(),
+			),
+		),
+	)
+
+	suite.Require().NoError(app.Err())
+	suite.Require().NotNil(server.ErrorLog)
+	server.ErrorLog.Print("http: TLS handshake error from 127.0.0.1:1234: EOF")
+
+	entries := suite.logs.FilterMessage("TLS handshake error").All()
+	suite.Require().Len(entries, 1)
+	suite.Equal("test", entries[0].ContextMap()["server"])
+}
+
+func (suite *ErrorLogSuite) testProvideServerExplicitErrorLog() {
+	var (
+		server   *http.Server
+		expected = log.New(log.Writer(), "", 0)
+	)
+
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(suite.logger),
+		ProvideServer("test", ErrorLog(expected)),
+		fx.Populate(
+			fx.Annotate(
+				&server,
+				arrange.Tags().Name("test").ParamTags(),
+			),
+		),
+	)
+
+	suite.Require().NoError(app.Err())
+	suite.Same(expected, server.ErrorLog)
+}
+
+func (suite *ErrorLogSuite) TestProvideServer() {
+	suite.Run("InjectedLogger", suite.testProvideServerInjectedLogger)
+	suite.Run("ExplicitErrorLog", suite.testProvideServerExplicitErrorLog)
+}
+
+func TestErrorLog(t *testing.T) {
+	suite.Run(t, new(ErrorLogSuite))
+}
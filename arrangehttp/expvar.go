@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// publishLock serializes publishExpvar, since checking for and publishing an
+// expvar are separate operations.
+var publishLock sync.Mutex
+
+// publishedFunc is an expvar.Var whose value function can be replaced.  This allows
+// the same configuration to be built more than once within a process.
+type publishedFunc struct {
+	f atomic.Pointer[func() any]
+}
+
+func (pf *publishedFunc) String() string {
+	v, _ := json.Marshal((*pf.f.Load())())
+	return string(v)
+}
+
+// publishExpvar publishes f as an expvar with the given name.  If this function has
+// already published that name, the published value is replaced with f.  An expvar with
+// that name that was published by other means is an error.
+func publishExpvar(name string, f func() any) error {
+	publishLock.Lock()
+	defer publishLock.Unlock()
+
+	switch v := expvar.Get(name).(type) {
+	case nil:
+		pf := new(publishedFunc)
+		pf.f.Store(&f)
+		expvar.Publish(name, pf)
+
+	case *publishedFunc:
+		v.f.Store(&f)
+
+	default:
+		return fmt.Errorf("An expvar named %s is already published", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"expvar"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ExpvarSuite struct {
+	suite.Suite
+}
+
+func (suite *ExpvarSuite) TestPublish() {
+	const name = "arrangehttp.TestPublishExpvar"
+	suite.Require().NoError(publishExpvar(name, func() any { return 1 }))
+	suite.Equal("1", expvar.Get(name).String())
+
+	// publishing again replaces the value
+	suite.Require().NoError(publishExpvar(name, func() any { return 2 }))
+	suite.Equal("2", expvar.Get(name).String())
+}
+
+func (suite *ExpvarSuite) TestPublishedElsewhere() {
+	const name = "arrangehttp.TestPublishedElsewhere"
+	if expvar.Get(name) == nil {
+		expvar.NewString(name).Set("original")
+	}
+
+	suite.Error(publishExpvar(name, func() any { return 1 }))
+	suite.Equal(`"original"`, expvar.Get(name).String())
+}
+
+func TestExpvar(t *testing.T) {
+	suite.Run(t, new(ExpvarSuite))
+}
@@ -0,0 +1,405 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xmidt-org/arrange"
+	"go.uber.org/fx"
+)
+
+const (
+	// HealthChecksGroup is the value group from which ProvideHealth collects HealthCheck components.
+	HealthChecksGroup = "health.checks"
+
+	// DefaultLivenessPath is the path at which Health.Middleware serves liveness reports.
+	DefaultLivenessPath = "/healthz"
+
+	// DefaultReadinessPath is the path at which Health.Middleware serves readiness reports.
+	DefaultReadinessPath = "/readyz"
+
+	// DefaultHealthCheckTimeout is the timeout for a HealthCheck that doesn't set one.
+	DefaultHealthCheckTimeout = 5 * time.Second
+
+	// HealthStatusOK is the status of a passing check or report.
+	HealthStatusOK = "ok"
+
+	// HealthStatusFail is the status of a failing check or report.
+	HealthStatusFail = "fail"
+
+	// LifecycleCheckName is the name of the readiness check driven by the application lifecycle.
+	LifecycleCheckName = "lifecycle"
+)
+
+var (
+	// ErrNotStarted is the lifecycle check error before the application has started.
+	ErrNotStarted = errors.New("The application has not started")
+
+	// ErrStopping is the lifecycle check error once the application has begun to stop.
+	ErrStopping = errors.New("The application is stopping")
+)
+
+// HealthCheck is a user-defined check of some part of an application.  HealthCheck components
+// placed into the HealthChecksGroup value group are run by the Health created by ProvideHealth.
+type HealthCheck struct {
+	// Name is the unique name of this check.  This field is required.
+	Name string
+
+	// Check performs the check.  This field is required.
+	Check func(context.Context) error
+
+	// Timeout is the maximum time this check may run.  If unset, DefaultHealthCheckTimeout is used.
+	Timeout time.Duration
+
+	// CacheTTL is how long a result is reused before this check runs again.  If unset,
+	// this check runs for every report.
+	CacheTTL time.Duration
+
+	// Liveness indicates that this check is part of liveness reports.  All checks are
+	// part of readiness reports.
+	Liveness bool
+}
+
+// HealthCheckResult is the outcome of a single check.
+type HealthCheckResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	Cached bool   `json:"cached,omitempty"`
+}
+
+// HealthReport is the outcome of a liveness or readiness request.
+type HealthReport struct {
+	Status string              `json:"status"`
+	Checks []HealthCheckResult `json:"checks,omitempty"`
+}
+
+// OK tests if every check in this report passed.
+func (hr HealthReport) OK() bool {
+	return hr.Status == HealthStatusOK
+}
+
+// WriteText writes this report as plain text, one check per line after the overall status.
+func (hr HealthReport) WriteText(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString(hr.Status)
+	b.WriteByte('\n')
+	for _, r := range hr.Checks {
+		fmt.Fprintf(&b, "%s: %s", r.Name, r.Status)
+		if len(r.Error) > 0 {
+			fmt.Fprintf(&b, ": %s", r.Error)
+		}
+
+		b.WriteByte('\n')
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// healthCheck is the runtime state of a HealthCheck.
+type healthCheck struct {
+	HealthCheck
+
+	lock    sync.Mutex
+	last    HealthCheckResult
+	expires time.Time
+}
+
+func (hc *healthCheck) run() HealthCheckResult {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+
+	if hc.CacheTTL > 0 && time.Now().Before(hc.expires) {
+		r := hc.last
+		r.Cached = true
+		return r
+	}
+
+	timeout := hc.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+
+	// results can be cached and shared, so checks don't use the context of any one request
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- hc.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-result:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	hc.last = newHealthCheckResult(hc.Name, err)
+	hc.expires = time.Now().Add(hc.CacheTTL)
+	return hc.last
+}
+
+func newHealthCheckResult(name string, err error) HealthCheckResult {
+	r := HealthCheckResult{
+		Name:   name,
+		Status: HealthStatusOK,
+	}
+
+	if err != nil {
+		r.Status = HealthStatusFail
+		r.Error = err.Error()
+	}
+
+	return r
+}
+
+// Health reports the liveness and readiness of an application.  Readiness is driven by the
+// application lifecycle: an application isn't ready until it has started, including every
+// server created by ProvideServer, and it stops being ready as soon as it begins to stop.
+//
+// Use ProvideHealth to create a Health bound to an enclosing fx.App.
+type Health struct {
+	lock     sync.Mutex
+	started  bool
+	stopping bool
+	pending  int
+
+	checks []*healthCheck
+}
+
+// NewHealth creates a Health that runs the given checks.  Each check must have a unique,
+// nonempty name and a Check function.
+//
+// The returned Health is not ready until OnStart is called.
+func NewHealth(checks ...HealthCheck) (*Health, error) {
+	h := &Health{
+		checks: make([]*healthCheck, 0, len(checks)),
+	}
+
+	names := make(map[string]bool, len(checks))
+	for i, c := range checks {
+		switch {
+		case len(c.Name) == 0:
+			return nil, fmt.Errorf("Health check %d has no name", i)
+
+		case c.Name == LifecycleCheckName || names[c.Name]:
+			return nil, fmt.Errorf("Duplicate health check name: %s", c.Name)
+
+		case c.Check == nil:
+			return nil, fmt.Errorf("Health check %s has no Check function", c.Name)
+		}
+
+		names[c.Name] = true
+		h.checks = append(h.checks, &healthCheck{HealthCheck: c})
+	}
+
+	return h, nil
+}
+
+// OnStart marks the application as started.  This method can be used as an fx.Hook.
+func (h *Health) OnStart(context.Context) error {
+	h.lock.Lock()
+	h.started = true
+	h.lock.Unlock()
+	return nil
+}
+
+// OnStop marks the application as stopping.  This method can be used as an fx.Hook.
+func (h *Health) OnStop(context.Context) error {
+	h.lock.Lock()
+	h.stopping = true
+	h.lock.Unlock()
+	return nil
+}
+
+// addPending registers a component that must start before the application is ready.
+// The returned closure must be called once the component has started.
+func (h *Health) addPending() (started func()) {
+	h.lock.Lock()
+	h.pending++
+	h.lock.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			h.lock.Lock()
+			h.pending--
+			h.lock.Unlock()
+		})
+	}
+}
+
+// lifecycleErr returns the reason the application lifecycle is not ready, if any.
+func (h *Health) lifecycleErr() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	switch {
+	case h.stopping:
+		return ErrStopping
+
+	case !h.started || h.pending > 0:
+		return ErrNotStarted
+
+	default:
+		return nil
+	}
+}
+
+// Ready tests if the application lifecycle is ready.  User-defined checks are not run.
+func (h *Health) Ready() bool {
+	return h.lifecycleErr() == nil
+}
+
+// report runs the matching checks concurrently, in addition to any initial results.
+func (h *Health) report(liveness bool, initial ...HealthCheckResult) (hr HealthReport) {
+	hr.Status = HealthStatusOK
+	hr.Checks = initial
+
+	var (
+		wg      sync.WaitGroup
+		offset  = len(hr.Checks)
+		matched = make([]*healthCheck, 0, len(h.checks))
+	)
+
+	for _, hc := range h.checks {
+		if !liveness || hc.Liveness {
+			matched = append(matched, hc)
+		}
+	}
+
+	hr.Checks = append(hr.Checks, make([]HealthCheckResult, len(matched))...)
+	for i, hc := range matched {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hr.Checks[offset+i] = hc.run()
+		}()
+	}
+
+	wg.Wait()
+	for _, r := range hr.Checks {
+		if r.Status != HealthStatusOK {
+			hr.Status = HealthStatusFail
+		}
+	}
+
+	return
+}
+
+// Liveness runs the checks marked as Liveness checks.
+func (h *Health) Liveness() HealthReport {
+	return h.report(true)
+}
+
+// Readiness reports the application lifecycle state and runs all checks.
+func (h *Health) Readiness() HealthReport {
+	return h.report(false, newHealthCheckResult(LifecycleCheckName, h.lifecycleErr()))
+}
+
+// writeReport writes a report as JSON or, if the client asks for it, plain text.
+// A failing report results in http.StatusServiceUnavailable.
+func writeReport(response http.ResponseWriter, request *http.Request, hr HealthReport) {
+	status := http.StatusOK
+	if !hr.OK() {
+		status = http.StatusServiceUnavailable
+	}
+
+	response.Header().Set("Cache-Control", "no-store")
+	if request.URL.Query().Get("format") == "text" || strings.HasPrefix(request.Header.Get("Accept"), "text/plain") {
+		response.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		response.WriteHeader(status)
+		hr.WriteText(response)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	json.NewEncoder(response).Encode(hr)
+}
+
+// LivenessHandler returns an http.Handler that serves liveness reports.
+func (h *Health) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		writeReport(response, request, h.Liveness())
+	})
+}
+
+// ReadinessHandler returns an http.Handler that serves readiness reports.
+func (h *Health) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		writeReport(response, request, h.Readiness())
+	})
+}
+
+// Middleware returns a server middleware that serves liveness reports at DefaultLivenessPath
+// and readiness reports at DefaultReadinessPath.  All other requests are passed to the decorated
+// handler.  This allows health endpoints to be mounted on any server:
+//
+//	fx.Provide(
+//	  fx.Annotate(
+//	    func(h *arrangehttp.Health) arrangehttp.Option[http.Server] {
+//	      return arrangehttp.ServerMiddleware(h.Middleware())
+//	    },
+//	    arrange.Tags().Group("main.options").ResultTags(),
+//	  ),
+//	)
+func (h *Health) Middleware() func(http.Handler) http.Handler {
+	var (
+		liveness  = h.LivenessHandler()
+		readiness = h.ReadinessHandler()
+	)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			switch request.URL.Path {
+			case DefaultLivenessPath:
+				liveness.ServeHTTP(response, request)
+
+			case DefaultReadinessPath:
+				readiness.ServeHTTP(response, request)
+
+			default:
+				next.ServeHTTP(response, request)
+			}
+		})
+	}
+}
+
+// newHealth is the fx constructor for a *Health bound to the application lifecycle.
+func newHealth(lc fx.Lifecycle, checks ...HealthCheck) (h *Health, err error) {
+	h, err = NewHealth(checks...)
+	if err == nil {
+		lc.Append(fx.StartStopHook(h.OnStart, h.OnStop))
+	}
+
+	return
+}
+
+// ProvideHealth provides a *Health bound to the lifecycle of the enclosing fx.App.  The
+// HealthCheck components in the HealthChecksGroup value group are run for each report.
+//
+// Servers created by ProvideServer use the *Health, if one is provided, so that the
+// application isn't ready until every server has started and stops being ready before
+// any server stops listening.
+func ProvideHealth() fx.Option {
+	return fx.Provide(
+		fx.Annotate(
+			newHealth,
+			arrange.Tags().Skip().Group(HealthChecksGroup).ParamTags(),
+		),
+	)
+}
@@ -0,0 +1,285 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange"
+	"github.com/xmidt-org/arrange/arrangetest"
+	"go.uber.org/fx"
+)
+
+type HealthSuite struct {
+	suite.Suite
+}
+
+func (suite *HealthSuite) newHealth(checks ...HealthCheck) *Health {
+	h, err := NewHealth(checks...)
+	suite.Require().NoError(err)
+	suite.Require().NotNil(h)
+	return h
+}
+
+func (suite *HealthSuite) serve(h http.Handler, target string, accept string) (response *httptest.ResponseRecorder) {
+	request := httptest.NewRequest("GET", target, nil)
+	if len(accept) > 0 {
+		request.Header.Set("Accept", accept)
+	}
+
+	response = httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+	return
+}
+
+func (suite *HealthSuite) TestNewHealthInvalid() {
+	check := func(context.Context) error { return nil }
+	for _, invalid := range [][]HealthCheck{
+		{{Check: check}},
+		{{Name: "nocheck"}},
+		{{Name: "dupe", Check: check}, {Name: "dupe", Check: check}},
+		{{Name: LifecycleCheckName, Check: check}},
+	} {
+		h, err := NewHealth(invalid...)
+		suite.Error(err)
+		suite.Nil(h)
+	}
+}
+
+func (suite *HealthSuite) TestLifecycle() {
+	h := suite.newHealth()
+	suite.False(h.Ready())
+	hr := h.Readiness()
+	suite.False(hr.OK())
+	suite.Equal([]HealthCheckResult{{Name: LifecycleCheckName, Status: HealthStatusFail, Error: ErrNotStarted.Error()}}, hr.Checks)
+
+	started := h.addPending()
+	suite.NoError(h.OnStart(context.Background()))
+	suite.False(h.Ready())
+
+	started()
+	started() // idempotent
+	suite.True(h.Ready())
+	suite.True(h.Readiness().OK())
+
+	suite.NoError(h.OnStop(context.Background()))
+	suite.False(h.Ready())
+	suite.Equal(ErrStopping.Error(), h.Readiness().Checks[0].Error)
+
+	// liveness doesn't depend on the lifecycle
+	suite.True(h.Liveness().OK())
+	suite.Empty(h.Liveness().Checks)
+}
+
+func (suite *HealthSuite) TestChecks() {
+	h := suite.newHealth(
+		HealthCheck{
+			Name:     "live",
+			Liveness: true,
+			Check:    func(context.Context) error { return nil },
+		},
+		HealthCheck{
+			Name:  "broken",
+			Check: func(context.Context) error { return errors.New("expected") },
+		},
+	)
+
+	h.OnStart(context.Background())
+
+	hr := h.Liveness()
+	suite.True(hr.OK())
+	suite.Equal([]HealthCheckResult{{Name: "live", Status: HealthStatusOK}}, hr.Checks)
+
+	hr = h.Readiness()
+	suite.False(hr.OK())
+	suite.Equal(
+		[]HealthCheckResult{
+			{Name: LifecycleCheckName, Status: HealthStatusOK},
+			{Name: "live", Status: HealthStatusOK},
+			{Name: "broken", Status: HealthStatusFail, Error: "expected"},
+		},
+		hr.Checks,
+	)
+}
+
+func (suite *HealthSuite) TestTimeout() {
+	var (
+		release = make(chan struct{})
+		h       = suite.newHealth(HealthCheck{
+			Name:     "slow",
+			Liveness: true,
+			Timeout:  10 * time.Millisecond,
+			Check: func(context.Context) error {
+				// ignores its context, so the timeout must be enforced by Health
+				<-release
+				return nil
+			},
+		})
+	)
+
+	defer close(release)
+	hr := h.Liveness()
+	suite.False(hr.OK())
+	suite.Equal(context.DeadlineExceeded.Error(), hr.Checks[0].Error)
+}
+
+func (suite *HealthSuite) TestCache() {
+	var (
+		calls atomic.Int32
+		h     = suite.newHealth(HealthCheck{
+			Name:     "cached",
+			Liveness: true,
+			CacheTTL: time.Hour,
+			Check: func(context.Context) error {
+				calls.Add(1)
+				return nil
+			},
+		})
+	)
+
+	suite.False(h.Liveness().Checks[0].Cached)
+	suite.True(h.Liveness().Checks[0].Cached)
+	suite.Equal(int32(1), calls.Load())
+}
+
+func (suite *HealthSuite) TestHandlers() {
+	h := suite.newHealth(HealthCheck{
+		Name:  "broken",
+		Check: func(context.Context) error { return errors.New("expected") },
+	})
+
+	response := suite.serve(h.LivenessHandler(), "/", "")
+	suite.Equal(http.StatusOK, response.Code)
+	suite.Equal("application/json", response.Header().Get("Content-Type"))
+	suite.Equal("no-store", response.Header().Get("Cache-Control"))
+	suite.JSONEq(`{"status": "ok"}`, response.Body.String())
+
+	response = suite.serve(h.ReadinessHandler(), "/", "")
+	suite.Equal(http.StatusServiceUnavailable, response.Code)
+
+	var hr HealthReport
+	suite.Require().NoError(json.Unmarshal(response.Body.Bytes(), &hr))
+	suite.Equal(HealthStatusFail, hr.Status)
+	suite.Len(hr.Checks, 2)
+
+	response = suite.serve(h.ReadinessHandler(), "/?format=text", "")
+	suite.Equal(http.StatusServiceUnavailable, response.Code)
+	suite.Equal("text/plain; charset=utf-8", response.Header().Get("Content-Type"))
+	suite.Equal(
+		"fail\nlifecycle: fail: The application has not started\nbroken: fail: expected\n",
+		response.Body.String(),
+	)
+
+	response = suite.serve(h.LivenessHandler(), "/", "text/plain")
+	suite.Equal("ok\n", response.Body.String())
+}
+
+func (suite *HealthSuite) TestMiddleware() {
+	h := suite.newHealth()
+	handler := h.Middleware()(http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		response.WriteHeader(299)
+	}))
+
+	suite.Equal(http.StatusOK, suite.serve(handler, DefaultLivenessPath, "").Code)
+	suite.Equal(http.StatusServiceUnavailable, suite.serve(handler, DefaultReadinessPath, "").Code)
+	suite.Equal(299, suite.serve(handler, "/other", "").Code)
+}
+
+func (suite *HealthSuite) TestProvide() {
+	var (
+		h      *Health
+		server *http.Server
+
+		// shutdown hooks run concurrently with http.Server.Shutdown
+		readyAtShutdown = make(chan bool, 1)
+	)
+
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			fx.Annotated{
+				Name:   "server.config",
+				Target: ServerConfig{Address: ":0"},
+			},
+		),
+		fx.Provide(
+			fx.Annotate(
+				func() HealthCheck {
+					return HealthCheck{
+						Name:  "injected",
+						Check: func(context.Context) error { return nil },
+					}
+				},
+				arrange.Tags().Group(HealthChecksGroup).ResultTags(),
+			),
+			fx.Annotate(
+				func(h *Health) Option[http.Server] {
+					return ServerMiddleware(h.Middleware())
+				},
+				arrange.Tags().Group("server.options").ResultTags(),
+			),
+		),
+		ProvideHealth(),
+		ProvideServer("server"),
+		fx.Populate(
+			&h,
+			fx.Annotate(
+				&server,
+				arrange.Tags().Name("server").ParamTags(),
+			),
+		),
+	)
+
+	suite.Require().NotNil(h)
+	suite.False(h.Ready())
+	server.RegisterOnShutdown(func() {
+		readyAtShutdown <- h.Ready()
+	})
+
+	app.RequireStart()
+	suite.True(h.Ready())
+
+	response := suite.serve(server.Handler, DefaultReadinessPath, "")
+	suite.Equal(http.StatusOK, response.Code)
+	suite.Contains(response.Body.String(), `"injected"`)
+
+	app.RequireStop()
+	suite.False(h.Ready())
+	select {
+	case ready := <-readyAtShutdown:
+		suite.False(ready)
+	case <-time.After(5 * time.Second):
+		suite.Fail("The server was not shut down")
+	}
+}
+
+func (suite *HealthSuite) TestProvideInvalid() {
+	app := arrangetest.NewErrApp(
+		suite,
+		fx.Provide(
+			fx.Annotate(
+				func() HealthCheck {
+					return HealthCheck{Name: "nocheck"}
+				},
+				arrange.Tags().Group(HealthChecksGroup).ResultTags(),
+			),
+		),
+		ProvideHealth(),
+		fx.Invoke(func(*Health) {}),
+	)
+
+	suite.Error(app.Err())
+}
+
+func TestHealth(t *testing.T) {
+	suite.Run(t, new(HealthSuite))
+}
@@ -0,0 +1,359 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xmidt-org/arrange/internal/arrangereflect"
+	"github.com/xmidt-org/httpaux/roundtrip"
+)
+
+const (
+	// DefaultHedgeDelay is the hedge delay used when HedgeConfig.Delay is unset.
+	DefaultHedgeDelay = 100 * time.Millisecond
+
+	// hedgeWindow is the number of latency samples used to compute a percentile delay.
+	hedgeWindow = 128
+
+	// hedgeMinSamples is the number of samples required before a percentile delay is used.
+	hedgeMinSamples = 16
+)
+
+var (
+	// DefaultHedgeMethods are the request methods eligible for hedging when
+	// HedgeConfig.Methods is unset.
+	DefaultHedgeMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+)
+
+// HedgeConfig is the unmarshaled configuration for hedged requests.  A hedged request is a
+// duplicate of a request that is sent when the original hasn't received a response within
+// the hedge delay.  The first response wins, and all other attempts are canceled.
+//
+// Hedging should only be used for idempotent requests.  Requests to upgrade the connection,
+// such as websocket handshakes, are never hedged.
+type HedgeConfig struct {
+	// MaxHedges is the maximum number of hedged requests sent in addition to the original.
+	// If unset, hedging is disabled.
+	MaxHedges int `json:"maxHedges" yaml:"maxHedges"`
+
+	// Delay is the time to wait for a response before sending a hedged request.  If Percentile
+	// is set, this delay is only used until enough latencies have been observed.  If unset,
+	// DefaultHedgeDelay is used.
+	Delay time.Duration `json:"delay" yaml:"delay"`
+
+	// Percentile, if set, computes the hedge delay as this percentile of recently observed
+	// response latencies, e.g. 0.95.  It must be in the range (0.0, 1.0].
+	Percentile float64 `json:"percentile" yaml:"percentile"`
+
+	// Methods are the request methods eligible for hedging.  If unset, DefaultHedgeMethods is used.
+	Methods []string `json:"methods" yaml:"methods"`
+
+	// ExpvarName, if set, publishes the hedging counters as an expvar with this name.
+	ExpvarName string `json:"expvarName" yaml:"expvarName"`
+}
+
+// HedgeCounters holds the counters for hedged requests.  A HedgeCounters may be
+// shared by any number of Hedgers.
+type HedgeCounters struct {
+	fired atomic.Uint64
+	won   atomic.Uint64
+}
+
+// Fired returns the number of hedged requests that have been sent.
+func (hc *HedgeCounters) Fired() uint64 {
+	return hc.fired.Load()
+}
+
+// Won returns the number of hedged requests whose response was used instead
+// of the original request's response.
+func (hc *HedgeCounters) Won() uint64 {
+	return hc.won.Load()
+}
+
+// Middleware returns a client middleware that decorates a transport with a Hedger.
+// Every Hedger created by the returned middleware updates the given counters, which
+// may be nil.  If ExpvarName is set, the counters are published with that name.  Building
+// a configuration with the same ExpvarName again publishes the most recent counters.
+//
+// If MaxHedges is not positive, this method returns a nil middleware.
+func (hc HedgeConfig) Middleware(counters *HedgeCounters) (func(http.RoundTripper) http.RoundTripper, error) {
+	switch {
+	case hc.MaxHedges <= 0:
+		return nil, nil
+
+	case hc.Percentile < 0.0 || hc.Percentile > 1.0:
+		return nil, fmt.Errorf("Invalid hedge percentile: %f", hc.Percentile)
+	}
+
+	if counters == nil {
+		counters = new(HedgeCounters)
+	}
+
+	if len(hc.ExpvarName) > 0 {
+		err := publishExpvar(hc.ExpvarName, func() any {
+			return map[string]uint64{
+				"fired": counters.Fired(),
+				"won":   counters.Won(),
+			}
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	delay := hc.Delay
+	if delay <= 0 {
+		delay = DefaultHedgeDelay
+	}
+
+	eligible := hc.Methods
+	if len(eligible) == 0 {
+		eligible = DefaultHedgeMethods
+	}
+
+	methods := make(map[string]bool, len(eligible))
+	for _, m := range eligible {
+		methods[m] = true
+	}
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &Hedger{
+			next:       arrangereflect.Safe(next, http.DefaultTransport),
+			counters:   counters,
+			maxHedges:  hc.MaxHedges,
+			delay:      delay,
+			percentile: hc.Percentile,
+			methods:    methods,
+			samples:    make([]time.Duration, 0, hedgeWindow),
+		}
+	}, nil
+}
+
+// Apply allows a HedgeConfig to be used as an Option[http.Client].  If MaxHedges
+// is not positive, this method does nothing.
+func (hc HedgeConfig) Apply(c *http.Client) error {
+	m, err := hc.Middleware(nil)
+	if m != nil {
+		c.Transport = m(c.Transport)
+	}
+
+	return err
+}
+
+// Hedger is an http.RoundTripper that sends hedged requests.  Requests with a method that
+// isn't eligible, or with a body that cannot be replayed via http.Request.GetBody, are
+// never hedged.  Use HedgeConfig.Middleware to create Hedgers.
+type Hedger struct {
+	next       http.RoundTripper
+	counters   *HedgeCounters
+	maxHedges  int
+	delay      time.Duration
+	percentile float64
+	methods    map[string]bool
+
+	lock    sync.Mutex
+	samples []time.Duration
+	head    int
+}
+
+var _ http.RoundTripper = (*Hedger)(nil)
+var _ roundtrip.CloseIdler = (*Hedger)(nil)
+
+// Counters returns the counters this Hedger updates.
+func (h *Hedger) Counters() *HedgeCounters {
+	return h.counters
+}
+
+// CloseIdleConnections delegates to the decorated transport, if supported.
+func (h *Hedger) CloseIdleConnections() {
+	roundtrip.CloseIdleConnections(h.next)
+}
+
+// record adds a latency sample to the window.
+func (h *Hedger) record(latency time.Duration) {
+	if h.percentile <= 0.0 {
+		return
+	}
+
+	h.lock.Lock()
+	if len(h.samples) < hedgeWindow {
+		h.samples = append(h.samples, latency)
+	} else {
+		h.samples[h.head] = latency
+		h.head = (h.head + 1) % hedgeWindow
+	}
+
+	h.lock.Unlock()
+}
+
+// hedgeDelay computes the current hedge delay.
+func (h *Hedger) hedgeDelay() time.Duration {
+	if h.percentile <= 0.0 {
+		return h.delay
+	}
+
+	h.lock.Lock()
+	if len(h.samples) < hedgeMinSamples {
+		h.lock.Unlock()
+		return h.delay
+	}
+
+	sorted := slices.Clone(h.samples)
+	h.lock.Unlock()
+
+	slices.Sort(sorted)
+	i := int(h.percentile*float64(len(sorted))+0.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+// hedgeAttempt is the outcome of a single attempt of a hedged request.
+type hedgeAttempt struct {
+	index    int
+	response *http.Response
+	err      error
+	latency  time.Duration
+}
+
+// cancelOnClose is a response body that cancels its attempt's context once closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (coc cancelOnClose) Close() error {
+	defer coc.cancel()
+	return coc.ReadCloser.Close()
+}
+
+// upgrading tests if a request asks to upgrade its connection to another protocol.
+func upgrading(request *http.Request) bool {
+	for _, v := range request.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// send starts an attempt in its own goroutine, returning the function that cancels it.
+func (h *Hedger) send(request *http.Request, index int, results chan<- hedgeAttempt) context.CancelFunc {
+	ctx, cancel := context.WithCancel(request.Context())
+	attempt := request.Clone(ctx)
+	if index > 0 && request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			results <- hedgeAttempt{index: index, err: err}
+			return cancel
+		}
+
+		attempt.Body = body
+	}
+
+	go func() {
+		start := time.Now()
+		response, err := h.next.RoundTrip(attempt)
+		results <- hedgeAttempt{
+			index:    index,
+			response: response,
+			err:      err,
+			latency:  time.Since(start),
+		}
+	}()
+
+	return cancel
+}
+
+// discard cleans up the given number of outstanding attempts, which must already be canceled.
+func discard(results <-chan hedgeAttempt, outstanding int) {
+	for ; outstanding > 0; outstanding-- {
+		if ha := <-results; ha.response != nil {
+			ha.response.Body.Close()
+		}
+	}
+}
+
+// RoundTrip sends the request, hedging it if the request is eligible.  An attempt that fails
+// with an error does not end the request while other attempts are possible.  If every attempt
+// fails, the error from the last attempt is returned.
+func (h *Hedger) RoundTrip(request *http.Request) (*http.Response, error) {
+	if !h.methods[request.Method] || !replayable(request) || upgrading(request) {
+		return h.next.RoundTrip(request)
+	}
+
+	var (
+		delay       = h.hedgeDelay()
+		timer       = time.NewTimer(delay)
+		results     = make(chan hedgeAttempt, h.maxHedges+1)
+		cancels     = make([]context.CancelFunc, 0, h.maxHedges+1)
+		outstanding = 0
+	)
+
+	defer timer.Stop()
+	cancelAll := func(except int) {
+		for i, c := range cancels {
+			if i != except {
+				c()
+			}
+		}
+	}
+
+	cancels = append(cancels, h.send(request, 0, results))
+	outstanding++
+	for {
+		select {
+		case <-timer.C:
+			if len(cancels) <= h.maxHedges {
+				h.counters.fired.Add(1)
+				cancels = append(cancels, h.send(request, len(cancels), results))
+				outstanding++
+				if len(cancels) <= h.maxHedges {
+					timer.Reset(delay)
+				}
+			}
+
+		case ha := <-results:
+			outstanding--
+			if ha.err != nil {
+				if request.Context().Err() == nil && (outstanding > 0 || len(cancels) <= h.maxHedges) {
+					if outstanding == 0 {
+						// nothing is in flight, so don't wait for the timer
+						timer.Reset(0)
+					}
+
+					continue
+				}
+
+				cancelAll(-1)
+				go discard(results, outstanding)
+				return nil, ha.err
+			}
+
+			if ha.index > 0 {
+				h.counters.won.Add(1)
+			}
+
+			h.record(ha.latency)
+			cancelAll(ha.index)
+			go discard(results, outstanding)
+			ha.response.Body = keepWritable(
+				cancelOnClose{ReadCloser: ha.response.Body, cancel: cancels[ha.index]},
+				ha.response.Body,
+			)
+			return ha.response, nil
+		}
+	}
+}
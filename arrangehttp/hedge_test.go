@@ -0,0 +1,310 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"errors"
+	"expvar"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/httpaux/roundtrip"
+)
+
+type HedgeSuite struct {
+	suite.Suite
+}
+
+func (suite *HedgeSuite) newHedger(hc HedgeConfig, next http.RoundTripper) *Hedger {
+	m, err := hc.Middleware(nil)
+	suite.Require().NoError(err)
+	suite.Require().NotNil(m)
+
+	h, ok := m(next).(*Hedger)
+	suite.Require().True(ok)
+	return h
+}
+
+func (suite *HedgeSuite) newResponse(request *http.Request, body string) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    request,
+	}
+}
+
+func (suite *HedgeSuite) TestDisabled() {
+	m, err := HedgeConfig{}.Middleware(nil)
+	suite.NoError(err)
+	suite.Nil(m)
+
+	c := new(http.Client)
+	suite.NoError(HedgeConfig{}.Apply(c))
+	suite.Nil(c.Transport)
+}
+
+func (suite *HedgeSuite) TestInvalidPercentile() {
+	m, err := HedgeConfig{MaxHedges: 1, Percentile: 1.5}.Middleware(nil)
+	suite.Error(err)
+	suite.Nil(m)
+
+	suite.Error(HedgeConfig{MaxHedges: 1, Percentile: -0.1}.Apply(new(http.Client)))
+}
+
+func (suite *HedgeSuite) TestExpvar() {
+	hc := HedgeConfig{
+		MaxHedges:  1,
+		ExpvarName: "arrangehttp.TestHedgeExpvar",
+	}
+
+	var counters HedgeCounters
+	m, err := hc.Middleware(&counters)
+	suite.Require().NoError(err)
+	suite.Require().NotNil(m)
+	suite.Same(&counters, m(nil).(*Hedger).Counters())
+
+	counters.fired.Add(2)
+	counters.won.Add(1)
+	suite.JSONEq(`{"fired": 2, "won": 1}`, expvar.Get(hc.ExpvarName).String())
+
+	// building the same configuration again publishes the new counters
+	var rebuilt HedgeCounters
+	m, err = hc.Middleware(&rebuilt)
+	suite.Require().NoError(err)
+	suite.Require().NotNil(m)
+	suite.JSONEq(`{"fired": 0, "won": 0}`, expvar.Get(hc.ExpvarName).String())
+
+	// a name published by other means is an error
+	const other = "arrangehttp.TestHedgeExpvar.other"
+	if expvar.Get(other) == nil {
+		expvar.NewInt(other)
+	}
+
+	m, err = HedgeConfig{MaxHedges: 1, ExpvarName: other}.Middleware(nil)
+	suite.Error(err)
+	suite.Nil(m)
+}
+
+func (suite *HedgeSuite) TestFastResponse() {
+	var calls atomic.Int32
+	h := suite.newHedger(
+		HedgeConfig{MaxHedges: 2, Delay: time.Hour},
+		roundtrip.Func(func(request *http.Request) (*http.Response, error) {
+			calls.Add(1)
+			return suite.newResponse(request, "fast"), nil
+		}),
+	)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	response, err := h.RoundTrip(request)
+	suite.Require().NoError(err)
+
+	body, _ := io.ReadAll(response.Body)
+	suite.NoError(response.Body.Close())
+	suite.Equal("fast", string(body))
+	suite.Equal(int32(1), calls.Load())
+	suite.Zero(h.Counters().Fired())
+	suite.Zero(h.Counters().Won())
+}
+
+func (suite *HedgeSuite) TestHedgeWins() {
+	var (
+		calls    atomic.Int32
+		canceled = make(chan struct{})
+	)
+
+	h := suite.newHedger(
+		HedgeConfig{MaxHedges: 1, Delay: time.Millisecond},
+		roundtrip.Func(func(request *http.Request) (*http.Response, error) {
+			if calls.Add(1) == 1 {
+				// the original request hangs until the hedge wins
+				<-request.Context().Done()
+				close(canceled)
+				return nil, request.Context().Err()
+			}
+
+			return suite.newResponse(request, "hedge"), nil
+		}),
+	)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	response, err := h.RoundTrip(request)
+	suite.Require().NoError(err)
+
+	body, _ := io.ReadAll(response.Body)
+	suite.NoError(response.Body.Close())
+	suite.Equal("hedge", string(body))
+
+	select {
+	case <-canceled:
+		// passing
+	case <-time.After(5 * time.Second):
+		suite.Fail("The losing attempt was not canceled")
+	}
+
+	suite.Equal(uint64(1), h.Counters().Fired())
+	suite.Equal(uint64(1), h.Counters().Won())
+}
+
+func (suite *HedgeSuite) TestErrorFallsBackToHedge() {
+	var calls atomic.Int32
+	h := suite.newHedger(
+		HedgeConfig{MaxHedges: 1, Delay: time.Hour},
+		roundtrip.Func(func(request *http.Request) (*http.Response, error) {
+			if calls.Add(1) == 1 {
+				return nil, errors.New("expected")
+			}
+
+			return suite.newResponse(request, "hedge"), nil
+		}),
+	)
+
+	response, err := h.RoundTrip(httptest.NewRequest("GET", "/", nil))
+	suite.Require().NoError(err)
+	response.Body.Close()
+	suite.Equal(int32(2), calls.Load())
+	suite.Equal(uint64(1), h.Counters().Fired())
+	suite.Equal(uint64(1), h.Counters().Won())
+}
+
+func (suite *HedgeSuite) TestAllAttemptsFail() {
+	expectedErr := errors.New("expected")
+	h := suite.newHedger(
+		HedgeConfig{MaxHedges: 2, Delay: time.Millisecond},
+		roundtrip.Func(func(*http.Request) (*http.Response, error) {
+			return nil, expectedErr
+		}),
+	)
+
+	response, err := h.RoundTrip(httptest.NewRequest("GET", "/", nil))
+	suite.ErrorIs(err, expectedErr)
+	suite.Nil(response)
+	suite.Equal(uint64(2), h.Counters().Fired())
+	suite.Zero(h.Counters().Won())
+}
+
+func (suite *HedgeSuite) TestIneligible() {
+	var calls atomic.Int32
+	h := suite.newHedger(
+		HedgeConfig{MaxHedges: 1, Delay: time.Millisecond},
+		roundtrip.Func(func(request *http.Request) (*http.Response, error) {
+			calls.Add(1)
+			time.Sleep(20 * time.Millisecond)
+			return suite.newResponse(request, ""), nil
+		}),
+	)
+
+	response, err := h.RoundTrip(httptest.NewRequest("POST", "/", strings.NewReader("body")))
+	suite.Require().NoError(err)
+	response.Body.Close()
+	suite.Equal(int32(1), calls.Load())
+	suite.Zero(h.Counters().Fired())
+}
+
+func (suite *HedgeSuite) TestUpgrade() {
+	var (
+		calls      atomic.Int32
+		conn, peer = net.Pipe()
+	)
+
+	defer peer.Close()
+	h := suite.newHedger(
+		HedgeConfig{MaxHedges: 1, Delay: time.Millisecond},
+		roundtrip.Func(func(request *http.Request) (*http.Response, error) {
+			calls.Add(1)
+			time.Sleep(20 * time.Millisecond)
+			return &http.Response{StatusCode: http.StatusSwitchingProtocols, Body: conn, Request: request}, nil
+		}),
+	)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Connection", "keep-alive, Upgrade")
+	request.Header.Set("Upgrade", "websocket")
+
+	response, err := h.RoundTrip(request)
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+
+	_, ok := response.Body.(io.ReadWriteCloser)
+	suite.True(ok, "the body of an upgraded response must be writable")
+	suite.Equal(int32(1), calls.Load())
+	suite.Zero(h.Counters().Fired())
+}
+
+func (suite *HedgeSuite) TestReplaysBody() {
+	var (
+		lock   sync.Mutex
+		bodies []string
+		calls  atomic.Int32
+	)
+
+	h := suite.newHedger(
+		HedgeConfig{MaxHedges: 1, Delay: time.Millisecond, Methods: []string{"PUT"}},
+		roundtrip.Func(func(request *http.Request) (*http.Response, error) {
+			b, _ := io.ReadAll(request.Body)
+			lock.Lock()
+			bodies = append(bodies, string(b))
+			lock.Unlock()
+
+			if calls.Add(1) == 1 {
+				<-request.Context().Done()
+				return nil, request.Context().Err()
+			}
+
+			return suite.newResponse(request, ""), nil
+		}),
+	)
+
+	request, err := http.NewRequest("PUT", "http://localhost/", strings.NewReader("payload"))
+	suite.Require().NoError(err)
+	response, err := h.RoundTrip(request)
+	suite.Require().NoError(err)
+	response.Body.Close()
+
+	lock.Lock()
+	defer lock.Unlock()
+	suite.Equal([]string{"payload", "payload"}, bodies)
+}
+
+func (suite *HedgeSuite) TestPercentileDelay() {
+	h := suite.newHedger(
+		HedgeConfig{MaxHedges: 1, Delay: time.Second, Percentile: 0.9},
+		nil,
+	)
+
+	suite.Equal(time.Second, h.hedgeDelay())
+	for i := 1; i <= 100; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+
+	suite.Equal(90*time.Millisecond, h.hedgeDelay())
+
+	// the window only holds the most recent samples
+	for i := 0; i < hedgeWindow; i++ {
+		h.record(5 * time.Millisecond)
+	}
+
+	suite.Equal(5*time.Millisecond, h.hedgeDelay())
+}
+
+func (suite *HedgeSuite) TestClientConfig() {
+	c, err := NewClient(ClientConfig{
+		Hedge: HedgeConfig{MaxHedges: 1},
+	})
+
+	suite.Require().NoError(err)
+	suite.IsType((*Hedger)(nil), c.Transport)
+}
+
+func TestHedge(t *testing.T) {
+	suite.Run(t, new(HedgeSuite))
+}
@@ -0,0 +1,134 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrHTTPSRedirectRequiresTLS indicates that an HTTPS redirect server was configured
+	// for a server that doesn't use TLS.
+	ErrHTTPSRedirectRequiresTLS = errors.New("An HTTPS redirect requires the server to use TLS")
+)
+
+// HTTPSRedirectConfig is the unmarshaled configuration for a companion plain HTTP server that
+// redirects clients to a TLS server.  The companion server shares the TLS server's lifecycle.
+type HTTPSRedirectConfig struct {
+	// Address is the bind address of the redirect server, e.g. ":80".  If unset, no
+	// redirect server is started.
+	Address string `json:"address" yaml:"address"`
+
+	// Host is the host, optionally with a port, that clients are redirected to.  If unset,
+	// clients are redirected to the host they requested and the port the TLS server listens
+	// on.  The port is omitted if it is 443.
+	Host string `json:"host" yaml:"host"`
+
+	// StatusCode is the status of redirect responses, either http.StatusMovedPermanently or
+	// http.StatusPermanentRedirect.  If unset, GET and HEAD requests receive a 301 and all
+	// other requests receive a 308, so that their method and body are preserved.
+	StatusCode int `json:"statusCode" yaml:"statusCode"`
+
+	// Passthrough are path prefixes that are served by the TLS server's handler instead of
+	// being redirected, such as health checks.  A prefix matches whole path segments.
+	Passthrough []string `json:"passthrough" yaml:"passthrough"`
+}
+
+// httpsRedirectFactory is implemented by server factories that configure an HTTPS
+// redirect server, such as ServerConfig.
+type httpsRedirectFactory interface {
+	httpsRedirectConfig() HTTPSRedirectConfig
+}
+
+// enabled tests if a redirect server is configured.
+func (hrc HTTPSRedirectConfig) enabled() bool {
+	return len(hrc.Address) > 0
+}
+
+// validate checks this configuration for a TLS server.
+func (hrc HTTPSRedirectConfig) validate(s *http.Server) error {
+	switch {
+	case s.TLSConfig == nil:
+		return ErrHTTPSRedirectRequiresTLS
+
+	case hrc.StatusCode != 0 && hrc.StatusCode != http.StatusMovedPermanently && hrc.StatusCode != http.StatusPermanentRedirect:
+		return fmt.Errorf("Invalid HTTPS redirect status code: %d", hrc.StatusCode)
+	}
+
+	for _, prefix := range hrc.Passthrough {
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("Invalid HTTPS redirect passthrough prefix: [%s]", prefix)
+		}
+	}
+
+	return nil
+}
+
+// Handler returns the redirect server's handler.  The port is the TLS server's port, and
+// passthrough is the handler for requests under a Passthrough prefix.
+func (hrc HTTPSRedirectConfig) Handler(port string, passthrough http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		for _, prefix := range hrc.Passthrough {
+			if matchPathPrefix(prefix, request.URL.Path) {
+				passthrough.ServeHTTP(response, request)
+				return
+			}
+		}
+
+		host := hrc.Host
+		if len(host) == 0 {
+			host = request.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			} else {
+				host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+			}
+
+			if len(host) == 0 {
+				http.Error(response, "The request has no host", http.StatusBadRequest)
+				return
+			}
+
+			if port != "443" {
+				host = net.JoinHostPort(host, port)
+			} else if strings.Contains(host, ":") {
+				host = "[" + host + "]"
+			}
+		}
+
+		code := hrc.StatusCode
+		if code == 0 {
+			code = http.StatusPermanentRedirect
+			if request.Method == http.MethodGet || request.Method == http.MethodHead {
+				code = http.StatusMovedPermanently
+			}
+		}
+
+		http.Redirect(response, request, "https://"+host+request.URL.RequestURI(), code)
+	})
+}
+
+// newServer creates the redirect server for a TLS server that is listening on the given address.
+// The redirect server uses the same timeouts and error log as the TLS server.
+func (hrc HTTPSRedirectConfig) newServer(s *http.Server, tlsAddr net.Addr) (*http.Server, error) {
+	_, port, err := net.SplitHostPort(tlsAddr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Server{
+		Addr:              hrc.Address,
+		Handler:           hrc.Handler(port, s.Handler),
+		ReadTimeout:       s.ReadTimeout,
+		ReadHeaderTimeout: s.ReadHeaderTimeout,
+		WriteTimeout:      s.WriteTimeout,
+		IdleTimeout:       s.IdleTimeout,
+		MaxHeaderBytes:    s.MaxHeaderBytes,
+		ErrorLog:          s.ErrorLog,
+	}, nil
+}
@@ -0,0 +1,191 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange"
+	"github.com/xmidt-org/arrange/arrangetest"
+	"github.com/xmidt-org/arrange/arrangetls"
+	"go.uber.org/fx"
+)
+
+type HTTPSRedirectSuite struct {
+	arrangetls.Suite
+}
+
+func (suite *HTTPSRedirectSuite) serve(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, httptest.NewRequest(method, target, strings.NewReader("")))
+	return response
+}
+
+func (suite *HTTPSRedirectSuite) passthrough() http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		response.WriteHeader(299)
+	})
+}
+
+func (suite *HTTPSRedirectSuite) TestHandler() {
+	h := HTTPSRedirectConfig{Passthrough: []string{"/healthz"}}.Handler("8443", suite.passthrough())
+
+	response := suite.serve(h, "GET", "http://example.com/path?q=1")
+	suite.Equal(http.StatusMovedPermanently, response.Code)
+	suite.Equal("https://example.com:8443/path?q=1", response.Header().Get("Location"))
+
+	response = suite.serve(h, "POST", "http://example.com:80/path")
+	suite.Equal(http.StatusPermanentRedirect, response.Code)
+	suite.Equal("https://example.com:8443/path", response.Header().Get("Location"))
+
+	response = suite.serve(h, "GET", "http://[::1]:80/")
+	suite.Equal("https://[::1]:8443/", response.Header().Get("Location"))
+
+	suite.Equal(299, suite.serve(h, "GET", "http://example.com/healthz").Code)
+	suite.Equal(299, suite.serve(h, "GET", "http://example.com/healthz/deep").Code)
+	suite.Equal(http.StatusMovedPermanently, suite.serve(h, "GET", "http://example.com/healthzz").Code)
+}
+
+func (suite *HTTPSRedirectSuite) TestHandlerDefaultPort() {
+	h := HTTPSRedirectConfig{}.Handler("443", suite.passthrough())
+	suite.Equal("https://example.com/", suite.serve(h, "GET", "http://example.com/").Header().Get("Location"))
+	suite.Equal("https://[::1]/", suite.serve(h, "GET", "http://[::1]/").Header().Get("Location"))
+}
+
+func (suite *HTTPSRedirectSuite) TestHandlerCustom() {
+	h := HTTPSRedirectConfig{
+		Host:       "secure.example.com:9443",
+		StatusCode: http.StatusPermanentRedirect,
+	}.Handler("8443", suite.passthrough())
+
+	response := suite.serve(h, "GET", "http://example.com/path")
+	suite.Equal(http.StatusPermanentRedirect, response.Code)
+	suite.Equal("https://secure.example.com:9443/path", response.Header().Get("Location"))
+}
+
+func (suite *HTTPSRedirectSuite) TestInvalid() {
+	for _, sc := range []ServerConfig{
+		{Address: ":0", HTTPSRedirect: HTTPSRedirectConfig{Address: ":0"}},
+		{Address: ":0", TLS: suite.Config(), HTTPSRedirect: HTTPSRedirectConfig{Address: ":0", StatusCode: http.StatusFound}},
+		{Address: ":0", TLS: suite.Config(), HTTPSRedirect: HTTPSRedirectConfig{Address: ":0", Passthrough: []string{"healthz"}}},
+	} {
+		app := arrangetest.NewErrApp(
+			suite,
+			fx.Supply(
+				fx.Annotated{
+					Name:   "server.config",
+					Target: sc,
+				},
+			),
+			ProvideServer("server"),
+		)
+
+		suite.Error(app.Err())
+	}
+
+	app := arrangetest.NewErrApp(
+		suite,
+		fx.Supply(
+			fx.Annotated{
+				Name:   "server.config",
+				Target: ServerConfig{Address: ":0", HTTPSRedirect: HTTPSRedirectConfig{Address: ":0"}},
+			},
+		),
+		ProvideServer("server"),
+	)
+
+	suite.ErrorIs(app.Err(), ErrHTTPSRedirectRequiresTLS)
+}
+
+// redirectServerConfig is a custom ServerFactory that reports each listener it creates.
+type redirectServerConfig struct {
+	ServerConfig
+	listened chan<- net.Addr
+}
+
+func (rsc redirectServerConfig) Listen(ctx context.Context, s *http.Server) (net.Listener, error) {
+	l, err := rsc.ServerConfig.Listen(ctx, s)
+	if err == nil {
+		rsc.listened <- l.Addr()
+	}
+
+	return l, err
+}
+
+func (suite *HTTPSRedirectSuite) TestProvide() {
+	var (
+		capture  = make(chan net.Addr, 2)
+		listened = make(chan net.Addr, 2)
+	)
+
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			fx.Annotated{
+				Name: "server.config",
+				Target: redirectServerConfig{
+					ServerConfig: ServerConfig{
+						Network: "tcp4",
+						Address: "127.0.0.1:0",
+						TLS:     suite.Config(),
+						HTTPSRedirect: HTTPSRedirectConfig{
+							Address:     "127.0.0.1:0",
+							Passthrough: []string{"/healthz"},
+						},
+					},
+					listened: listened,
+				},
+			},
+		),
+		fx.Provide(
+			fx.Annotate(
+				suite.passthrough,
+				arrange.Tags().Name("server.handler").ResultTags(),
+			),
+		),
+		ProvideServerCustom[http.Handler, redirectServerConfig]("server", arrangetest.ListenCapture(capture)),
+	)
+
+	app.RequireStart()
+	tlsAddr := arrangetest.ListenReceive(suite, capture, 2*time.Second)
+	suite.Equal(tlsAddr, arrangetest.ListenReceive(suite, listened, 2*time.Second))
+
+	// the redirect listener comes from the same factory, but without the listener middleware
+	redirectAddr := arrangetest.ListenReceive(suite, listened, 2*time.Second)
+	suite.Empty(capture)
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	response, err := client.Get("http://" + redirectAddr.String() + "/path")
+	suite.Require().NoError(err)
+	response.Body.Close()
+	suite.Equal(http.StatusMovedPermanently, response.StatusCode)
+	suite.Equal("https://"+tlsAddr.String()+"/path", response.Header.Get("Location"))
+
+	response, err = client.Get("http://" + redirectAddr.String() + "/healthz")
+	suite.Require().NoError(err)
+	response.Body.Close()
+	suite.Equal(299, response.StatusCode)
+
+	app.RequireStop()
+
+	// the redirect server stops along with the TLS server
+	_, err = client.Get("http://" + redirectAddr.String() + "/path")
+	suite.Error(err)
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	suite.Run(t, new(HTTPSRedirectSuite))
+}
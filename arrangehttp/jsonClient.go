@@ -0,0 +1,219 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/xmidt-org/arrange/internal/arrangereflect"
+)
+
+const (
+	// JSONAccept is the Accept header sent with each request from a JSONClient.
+	JSONAccept = "application/json, application/problem+json;q=0.9"
+
+	// MaxErrorBodySnippet is the maximum number of bytes of a response body that
+	// are retained in a StatusError.
+	MaxErrorBodySnippet = 512
+
+	// maxDrain is the maximum number of unread bytes discarded from a response body so
+	// that its connection can be reused.
+	maxDrain = 64 << 10
+)
+
+// ProblemDetails is an RFC 7807 problem details object.
+type ProblemDetails struct {
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// StatusError is returned by a JSONClient when a server responds with a non-2xx status.
+type StatusError struct {
+	// StatusCode is the response's status code.
+	StatusCode int
+
+	// Body is a snippet of the response body, at most MaxErrorBodySnippet bytes.
+	Body string
+
+	// Problem holds the parsed problem details, if the server responded with
+	// application/problem+json.
+	Problem *ProblemDetails
+}
+
+// Error satisfies the error interface.
+func (se *StatusError) Error() string {
+	if se.Problem != nil && len(se.Problem.Title) > 0 {
+		return fmt.Sprintf("Request failed with status %d: %s", se.StatusCode, se.Problem.Title)
+	}
+
+	return fmt.Sprintf("Request failed with status %d: %s", se.StatusCode, se.Body)
+}
+
+// ContentTypeError is returned by a JSONClient when a successful response
+// does not have a JSON content type.
+type ContentTypeError struct {
+	ContentType string
+}
+
+// Error satisfies the error interface.
+func (cte *ContentTypeError) Error() string {
+	return fmt.Sprintf("Unexpected response content type: %q", cte.ContentType)
+}
+
+// isJSON tests if a media type is JSON, including structured syntax suffixes like +json.
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// baseURLFactory is implemented by client factories that configure a base URL,
+// such as ClientConfig.
+type baseURLFactory interface {
+	clientBaseURL() string
+}
+
+// JSONClient sends JSON requests relative to a base URL.  Use Do and Get to send requests.
+type JSONClient struct {
+	client  *http.Client
+	baseURL *url.URL
+}
+
+// NewJSONClient creates a JSONClient that sends requests with the given client.  If c is
+// nil, http.DefaultClient is used.  The baseURL may be empty, in which case each request
+// path must be an absolute URL.
+func NewJSONClient(c *http.Client, baseURL string) (jc *JSONClient, err error) {
+	jc = &JSONClient{
+		client: arrangereflect.Safe(c, http.DefaultClient),
+	}
+
+	jc.baseURL, err = url.Parse(baseURL)
+	if err != nil {
+		jc = nil
+	}
+
+	return
+}
+
+// Client returns the underlying *http.Client.
+func (jc *JSONClient) Client() *http.Client {
+	return jc.client
+}
+
+// URL resolves a path, which may also be an absolute URL, against this client's base URL.
+// Unlike RFC 3986 reference resolution, the path is always appended to the base URL's path,
+// even if it begins with a slash.  With a base URL of "https://host/api/v1", both "users"
+// and "/users" resolve to "https://host/api/v1/users".  Any query and fragment come from
+// the path.  Absolute URLs, including those without a scheme such as "//host/path", are
+// returned unchanged, as is every path when there is no base URL.
+func (jc *JSONClient) URL(path string) (string, error) {
+	ref, err := url.Parse(path)
+	if err != nil {
+		return "", err
+	}
+
+	if ref.IsAbs() || len(ref.Host) > 0 || len(jc.baseURL.String()) == 0 {
+		return jc.baseURL.ResolveReference(ref).String(), nil
+	}
+
+	u := jc.baseURL.JoinPath(ref.EscapedPath())
+	u.RawQuery = ref.RawQuery
+	u.Fragment = ref.Fragment
+	u.RawFragment = ref.RawFragment
+	return u.String(), nil
+}
+
+// send is the common implementation for Do and Get.
+func send[Resp any](ctx context.Context, jc *JSONClient, method, path string, body io.Reader) (result Resp, err error) {
+	var u string
+	if u, err = jc.URL(path); err != nil {
+		return
+	}
+
+	var request *http.Request
+	if request, err = http.NewRequestWithContext(ctx, method, u, body); err != nil {
+		return
+	}
+
+	request.Header.Set("Accept", JSONAccept)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	var response *http.Response
+	if response, err = jc.client.Do(request); err != nil {
+		return
+	}
+
+	defer drain(response.Body)
+	contentType := response.Header.Get("Content-Type")
+	switch {
+	case response.StatusCode < 200 || response.StatusCode > 299:
+		err = newStatusError(response, contentType)
+
+	case response.StatusCode == http.StatusNoContent:
+		// leave the result as the zero value
+
+	case !isJSON(contentType):
+		err = &ContentTypeError{ContentType: contentType}
+
+	default:
+		err = json.NewDecoder(response.Body).Decode(&result)
+	}
+
+	return
+}
+
+// drain discards what remains of a response body, up to maxDrain bytes, and closes it.
+// A body that is closed before it is fully read prevents its connection from being reused.
+func drain(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, maxDrain))
+	body.Close()
+}
+
+// newStatusError reads a snippet of the response body to produce a StatusError.
+func newStatusError(response *http.Response, contentType string) *StatusError {
+	snippet, _ := io.ReadAll(io.LimitReader(response.Body, MaxErrorBodySnippet))
+	se := &StatusError{
+		StatusCode: response.StatusCode,
+		Body:       string(snippet),
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/problem+json" {
+		var pd ProblemDetails
+		if json.Unmarshal(snippet, &pd) == nil {
+			se.Problem = &pd
+		}
+	}
+
+	return se
+}
+
+// Do sends a request with a JSON body and decodes the JSON response.  The path is resolved
+// against the client's base URL.  Any non-2xx response results in a *StatusError.  A 2xx
+// response without a JSON content type results in a *ContentTypeError, except for
+// 204 No Content, which produces the zero value of Resp.
+func Do[Req, Resp any](ctx context.Context, jc *JSONClient, method, path string, req Req) (result Resp, err error) {
+	var body []byte
+	if body, err = json.Marshal(req); err == nil {
+		result, err = send[Resp](ctx, jc, method, path, bytes.NewReader(body))
+	}
+
+	return
+}
+
+// Get sends a GET request without a body and decodes the JSON response.  See Do.
+func Get[Resp any](ctx context.Context, jc *JSONClient, path string) (Resp, error) {
+	return send[Resp](ctx, jc, http.MethodGet, path, nil)
+}
@@ -0,0 +1,274 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange"
+	"github.com/xmidt-org/arrange/arrangetest"
+	"github.com/xmidt-org/httpaux/roundtrip"
+	"go.uber.org/fx"
+)
+
+type jsonTestRequest struct {
+	Name string `json:"name"`
+}
+
+type jsonTestResponse struct {
+	Greeting string `json:"greeting"`
+}
+
+type JSONClientSuite struct {
+	suite.Suite
+	server *httptest.Server
+}
+
+func (suite *JSONClientSuite) SetupSuite() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/greet", func(response http.ResponseWriter, request *http.Request) {
+		suite.Equal("application/json", request.Header.Get("Content-Type"))
+		suite.Equal(JSONAccept, request.Header.Get("Accept"))
+
+		var jtr jsonTestRequest
+		suite.NoError(json.NewDecoder(request.Body).Decode(&jtr))
+		response.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(response).Encode(jsonTestResponse{Greeting: "hello, " + jtr.Name})
+	})
+
+	mux.HandleFunc("GET /api/greet", func(response http.ResponseWriter, request *http.Request) {
+		suite.Empty(request.Header.Get("Content-Type"))
+		response.Header().Set("Content-Type", "application/vnd.greeting+json")
+		io.WriteString(response, `{"greeting": "hello"}`)
+	})
+
+	mux.HandleFunc("DELETE /api/greet", func(response http.ResponseWriter, _ *http.Request) {
+		response.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /api/text", func(response http.ResponseWriter, _ *http.Request) {
+		response.Header().Set("Content-Type", "text/plain")
+		io.WriteString(response, "hello")
+	})
+
+	mux.HandleFunc("GET /api/problem", func(response http.ResponseWriter, _ *http.Request) {
+		response.Header().Set("Content-Type", "application/problem+json")
+		response.WriteHeader(http.StatusConflict)
+		io.WriteString(response, `{"title": "conflict", "status": 409, "detail": "already exists"}`)
+	})
+
+	mux.HandleFunc("GET /api/large", func(response http.ResponseWriter, _ *http.Request) {
+		response.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(response, strings.Repeat("x", 2*MaxErrorBodySnippet))
+	})
+
+	suite.server = httptest.NewServer(mux)
+}
+
+func (suite *JSONClientSuite) TearDownSuite() {
+	suite.server.Close()
+}
+
+func (suite *JSONClientSuite) newJSONClient() *JSONClient {
+	jc, err := NewJSONClient(nil, suite.server.URL+"/api/")
+	suite.Require().NoError(err)
+	suite.Require().NotNil(jc)
+	suite.Same(http.DefaultClient, jc.Client())
+	return jc
+}
+
+func (suite *JSONClientSuite) TestNewJSONClientInvalidBaseURL() {
+	jc, err := NewJSONClient(nil, "http://[::1")
+	suite.Error(err)
+	suite.Nil(jc)
+}
+
+func (suite *JSONClientSuite) TestURL() {
+	jc := suite.newJSONClient()
+
+	u, err := jc.URL("greet?x=1")
+	suite.Require().NoError(err)
+	suite.Equal(suite.server.URL+"/api/greet?x=1", u)
+
+	u, err = jc.URL("http://elsewhere.net/foo")
+	suite.Require().NoError(err)
+	suite.Equal("http://elsewhere.net/foo", u)
+
+	_, err = jc.URL("http://[::1")
+	suite.Error(err)
+}
+
+func (suite *JSONClientSuite) TestURLJoinsBasePath() {
+	testData := []struct {
+		baseURL  string
+		path     string
+		expected string
+	}{
+		{"https://host/api/v1", "users", "https://host/api/v1/users"},
+		{"https://host/api/v1", "/users", "https://host/api/v1/users"},
+		{"https://host/api/v1/", "/users", "https://host/api/v1/users"},
+		{"https://host/api/v1", "/users/?active=true#top", "https://host/api/v1/users/?active=true#top"},
+		{"https://host/api/v1", "a%2Fb", "https://host/api/v1/a%2Fb"},
+		{"https://host/api/v1", "?q=1", "https://host/api/v1?q=1"},
+		{"https://host", "/users", "https://host/users"},
+		{"", "/users", "/users"},
+		{"https://host/api/v1", "//other/path", "https://other/path"},
+	}
+
+	for _, record := range testData {
+		jc, err := NewJSONClient(nil, record.baseURL)
+		suite.Require().NoError(err)
+
+		u, err := jc.URL(record.path)
+		suite.Require().NoError(err)
+		suite.Equal(record.expected, u, "%s + %s", record.baseURL, record.path)
+	}
+}
+
+func (suite *JSONClientSuite) TestDo() {
+	jc := suite.newJSONClient()
+
+	resp, err := Do[jsonTestRequest, jsonTestResponse](context.Background(), jc, "POST", "greet", jsonTestRequest{Name: "world"})
+	suite.Require().NoError(err)
+	suite.Equal("hello, world", resp.Greeting)
+
+	resp, err = Do[any, jsonTestResponse](context.Background(), jc, "DELETE", "greet", nil)
+	suite.Require().NoError(err)
+	suite.Zero(resp)
+}
+
+func (suite *JSONClientSuite) TestGet() {
+	jc := suite.newJSONClient()
+
+	resp, err := Get[jsonTestResponse](context.Background(), jc, "greet")
+	suite.Require().NoError(err)
+	suite.Equal("hello", resp.Greeting)
+}
+
+func (suite *JSONClientSuite) TestContentTypeError() {
+	_, err := Get[jsonTestResponse](context.Background(), suite.newJSONClient(), "text")
+
+	var cte *ContentTypeError
+	suite.Require().ErrorAs(err, &cte)
+	suite.Equal("text/plain", cte.ContentType)
+	suite.Contains(cte.Error(), "text/plain")
+}
+
+func (suite *JSONClientSuite) TestStatusErrorProblem() {
+	_, err := Get[jsonTestResponse](context.Background(), suite.newJSONClient(), "problem")
+
+	var se *StatusError
+	suite.Require().ErrorAs(err, &se)
+	suite.Equal(http.StatusConflict, se.StatusCode)
+	suite.Require().NotNil(se.Problem)
+	suite.Equal("conflict", se.Problem.Title)
+	suite.Equal("already exists", se.Problem.Detail)
+	suite.Contains(se.Error(), "conflict")
+}
+
+func (suite *JSONClientSuite) TestStatusErrorSnippet() {
+	_, err := Get[jsonTestResponse](context.Background(), suite.newJSONClient(), "large")
+
+	var se *StatusError
+	suite.Require().ErrorAs(err, &se)
+	suite.Equal(http.StatusInternalServerError, se.StatusCode)
+	suite.Len(se.Body, MaxErrorBodySnippet)
+	suite.Nil(se.Problem)
+	suite.Contains(se.Error(), "500")
+}
+
+// drainCheckBody is a response body that records whether it was fully read when closed.
+type drainCheckBody struct {
+	*strings.Reader
+	drained *bool
+}
+
+func (dcb drainCheckBody) Close() error {
+	*dcb.drained = dcb.Len() == 0
+	return nil
+}
+
+func (suite *JSONClientSuite) TestDrainsBody() {
+	testData := []struct {
+		name        string
+		statusCode  int
+		contentType string
+		body        string
+	}{
+		{"StatusError", http.StatusInternalServerError, "text/plain", strings.Repeat("x", 2*MaxErrorBodySnippet)},
+		{"TrailingData", http.StatusOK, "application/json", "{\"greeting\": \"hello\"}\n"},
+		{"ContentTypeError", http.StatusOK, "text/plain", "hello"},
+	}
+
+	for _, record := range testData {
+		suite.Run(record.name, func() {
+			var drained bool
+			client := &http.Client{
+				Transport: roundtrip.Func(func(request *http.Request) (*http.Response, error) {
+					return &http.Response{
+						StatusCode: record.statusCode,
+						Header:     http.Header{"Content-Type": {record.contentType}},
+						Body:       drainCheckBody{Reader: strings.NewReader(record.body), drained: &drained},
+						Request:    request,
+					}, nil
+				}),
+			}
+
+			jc, err := NewJSONClient(client, "http://localhost/")
+			suite.Require().NoError(err)
+
+			Get[jsonTestResponse](context.Background(), jc, "greet")
+			suite.True(drained, "the response body should be drained so the connection can be reused")
+		})
+	}
+}
+
+func (suite *JSONClientSuite) TestProvideClient() {
+	var (
+		client *http.Client
+		jc     *JSONClient
+	)
+
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			fx.Annotated{
+				Name: "client.config",
+				Target: ClientConfig{
+					BaseURL: suite.server.URL + "/api/",
+				},
+			},
+		),
+		ProvideClient("client"),
+		fx.Populate(
+			fx.Annotate(
+				&client,
+				arrange.Tags().Name("client").ParamTags(),
+			),
+			fx.Annotate(
+				&jc,
+				arrange.Tags().Name("client.json").ParamTags(),
+			),
+		),
+	)
+
+	app.RequireStart()
+	defer app.RequireStop()
+
+	suite.Same(client, jc.Client())
+	resp, err := Get[jsonTestResponse](context.Background(), jc, "greet")
+	suite.Require().NoError(err)
+	suite.Equal("hello", resp.Greeting)
+}
+
+func TestJSONClient(t *testing.T) {
+	suite.Run(t, new(JSONClientSuite))
+}
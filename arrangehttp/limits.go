@@ -0,0 +1,280 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xmidt-org/arrange/internal/arrangereflect"
+)
+
+const (
+	// TimeoutWriteGrace is how far past a route's Timeout the write deadline is set, so that
+	// a handler that stops at its timeout can still write a response.
+	TimeoutWriteGrace = time.Second
+)
+
+// RouteLimit is the unmarshaled configuration for the limits applied to requests
+// under a path prefix.
+type RouteLimit struct {
+	// PathPrefix is the path prefix this limit applies to.  A prefix matches whole path
+	// segments, so "/upload" matches "/upload" and "/upload/file" but not "/uploads".
+	// This field is required and must begin with a '/'.
+	PathPrefix string `json:"pathPrefix" yaml:"pathPrefix"`
+
+	// Timeout is the maximum time a handler has to service a request.  The request's context
+	// is canceled, and the connection's read deadline is set, when this timeout elapses.  A
+	// handler that has not written a response by then results in a 503.  If unset, no timeout
+	// is applied and the server's own timeouts are used.
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+
+	// MaxBodySize is the maximum size of a request body.  A request that declares a larger
+	// Content-Length, or whose body turns out to be larger, results in a 413.  If unset,
+	// request bodies are not limited.
+	MaxBodySize int64 `json:"maxBodySize" yaml:"maxBodySize"`
+}
+
+// matchPathPrefix tests if a path prefix matches a request path.  Prefixes match whole
+// path segments, so "/upload" matches "/upload" and "/upload/file" but not "/uploads".
+func matchPathPrefix(prefix, path string) bool {
+	switch {
+	case !strings.HasPrefix(path, prefix):
+		return false
+
+	case len(path) == len(prefix) || strings.HasSuffix(prefix, "/"):
+		return true
+
+	default:
+		return path[len(prefix)] == '/'
+	}
+}
+
+// RouteLimits is the unmarshaled configuration for per-route timeouts and request body sizes.
+// When several entries match a request, the one with the longest PathPrefix applies.
+type RouteLimits []RouteLimit
+
+// find returns the limit for a request path, which will be the zero value if no limit applies.
+func (rl RouteLimits) find(path string) (found RouteLimit) {
+	for _, l := range rl {
+		if len(l.PathPrefix) > len(found.PathPrefix) && matchPathPrefix(l.PathPrefix, path) {
+			found = l
+		}
+	}
+
+	return
+}
+
+// limitWriter is the http.ResponseWriter that replaces a handler's response with
+// an error once a request has exceeded its limits.
+type limitWriter struct {
+	http.ResponseWriter
+	// deadline is the time by which the handler must respond, if a timeout applies
+	deadline time.Time
+
+	// bodyErr is set by the request body when it exceeds its maximum size
+	bodyErr *http.MaxBytesError
+
+	wrote      bool
+	overridden bool
+	hijacked   bool
+}
+
+// override writes the error response for a request that has exceeded its limits.
+// This method returns false if the request is within its limits.
+func (lw *limitWriter) override() bool {
+	switch {
+	case lw.bodyErr != nil:
+		http.Error(lw.ResponseWriter, fmt.Sprintf("The request body exceeds the maximum size of %d bytes", lw.bodyErr.Limit), http.StatusRequestEntityTooLarge)
+
+	case !lw.deadline.IsZero() && !time.Now().Before(lw.deadline):
+		http.Error(lw.ResponseWriter, "The request timed out", http.StatusServiceUnavailable)
+
+	default:
+		return false
+	}
+
+	lw.overridden = true
+	return true
+}
+
+// begin is called before anything is written.  It returns true if the handler's
+// output should be passed through.
+func (lw *limitWriter) begin() bool {
+	if !lw.wrote {
+		lw.wrote = true
+		if lw.override() {
+			return false
+		}
+	}
+
+	return !lw.overridden
+}
+
+// WriteHeader writes the handler's status code, unless the request has exceeded its limits.
+// Informational responses are always written.
+func (lw *limitWriter) WriteHeader(code int) {
+	if (code >= 100 && code < 200) || lw.begin() {
+		lw.ResponseWriter.WriteHeader(code)
+	}
+}
+
+// Write writes the handler's output, unless the request has exceeded its limits.
+// Output that is discarded is reported as written, so handlers finish normally.
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	if lw.begin() {
+		return lw.ResponseWriter.Write(p)
+	}
+
+	return len(p), nil
+}
+
+// Flush passes through to the decorated writer, if it supports flushing.
+func (lw *limitWriter) Flush() {
+	if !lw.hijacked && lw.begin() {
+		if f, ok := lw.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+// Hijack passes through to the decorated writer, if it supports hijacking.
+func (lw *limitWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := lw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	conn, rw, err := h.Hijack()
+	lw.hijacked = err == nil
+	return conn, rw, err
+}
+
+// Unwrap returns the decorated writer, for use by http.ResponseController.
+func (lw *limitWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+// close writes the error response, if necessary, once the handler returns
+// without having written anything.
+func (lw *limitWriter) close() {
+	if !lw.hijacked && !lw.wrote {
+		lw.wrote = true
+		lw.override()
+	}
+}
+
+// limitedRequestBody records the error from an http.MaxBytesReader so that
+// the response can be replaced with a 413.
+type limitedRequestBody struct {
+	io.ReadCloser
+	lw *limitWriter
+}
+
+func (lrb limitedRequestBody) Read(p []byte) (n int, err error) {
+	n, err = lrb.ReadCloser.Read(p)
+
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		lrb.lw.bodyErr = mbe
+	}
+
+	return
+}
+
+// Middleware returns a server middleware that applies the limit for each request's path.
+// Timeouts are enforced with http.ResponseController deadlines, so responses are not buffered.
+// For writers that do not support deadlines, http.TimeoutHandler is used instead.
+//
+// Requests that exceed their limits consistently receive a 413 for an oversized body and
+// a 503 for a timeout, as long as the handler has not already begun its response.
+//
+// If there are no limits, this method returns a nil middleware.
+func (rl RouteLimits) Middleware() (func(http.Handler) http.Handler, error) {
+	if len(rl) == 0 {
+		return nil, nil
+	}
+
+	for i, l := range rl {
+		switch {
+		case !strings.HasPrefix(l.PathPrefix, "/"):
+			return nil, fmt.Errorf("Invalid route limit path prefix: [%s]", l.PathPrefix)
+
+		case slices.ContainsFunc(rl[:i], func(other RouteLimit) bool { return other.PathPrefix == l.PathPrefix }):
+			return nil, fmt.Errorf("Duplicate route limit path prefix: [%s]", l.PathPrefix)
+
+		case l.Timeout < 0:
+			return nil, fmt.Errorf("Invalid route limit timeout for [%s]: %s", l.PathPrefix, l.Timeout)
+
+		case l.MaxBodySize < 0:
+			return nil, fmt.Errorf("Invalid route limit max body size for [%s]: %d", l.PathPrefix, l.MaxBodySize)
+		}
+	}
+
+	limits := slices.Clone(rl)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			l := limits.find(request.URL.Path)
+			if l.Timeout <= 0 && l.MaxBodySize <= 0 {
+				next.ServeHTTP(response, request)
+				return
+			}
+
+			if l.MaxBodySize > 0 && request.ContentLength > l.MaxBodySize {
+				http.Error(response, fmt.Sprintf("The request body exceeds the maximum size of %d bytes", l.MaxBodySize), http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			var (
+				handler = next
+				lw      = &limitWriter{
+					ResponseWriter: response,
+				}
+			)
+
+			if l.Timeout > 0 {
+				rc := http.NewResponseController(response)
+				deadline := time.Now().Add(l.Timeout)
+				if err := rc.SetWriteDeadline(deadline.Add(TimeoutWriteGrace)); errors.Is(err, http.ErrNotSupported) {
+					handler = http.TimeoutHandler(next, l.Timeout, "The request timed out")
+				} else {
+					rc.SetReadDeadline(deadline)
+					ctx, cancel := context.WithDeadline(request.Context(), deadline)
+					defer cancel()
+					request = request.WithContext(ctx)
+					lw.deadline = deadline
+				}
+			}
+
+			if l.MaxBodySize > 0 && request.Body != nil && request.Body != http.NoBody {
+				request.Body = limitedRequestBody{
+					ReadCloser: http.MaxBytesReader(response, request.Body, l.MaxBodySize),
+					lw:         lw,
+				}
+			}
+
+			defer lw.close()
+			handler.ServeHTTP(lw, request)
+			recordRoute(request)
+		})
+	}, nil
+}
+
+// Apply allows these limits to be used as an Option[http.Server].
+func (rl RouteLimits) Apply(s *http.Server) error {
+	m, err := rl.Middleware()
+	if m != nil {
+		s.Handler = m(arrangereflect.Safe[http.Handler](s.Handler, http.DefaultServeMux))
+	}
+
+	return err
+}
@@ -0,0 +1,251 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange"
+	"github.com/xmidt-org/arrange/arrangetest"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type LimitsSuite struct {
+	suite.Suite
+}
+
+// readAll returns a handler that reads the entire request body, responding with
+// a 400 if the body couldn't be read.
+func (suite *LimitsSuite) readAll() http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if _, err := io.ReadAll(request.Body); err != nil {
+			response.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response.WriteHeader(299)
+	})
+}
+
+// waitForTimeout returns a handler that blocks until its request is canceled.
+func (suite *LimitsSuite) waitForTimeout() http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		select {
+		case <-request.Context().Done():
+			// simulate a handler that reports its own error
+			response.WriteHeader(http.StatusInternalServerError)
+
+		case <-time.After(5 * time.Second):
+			response.WriteHeader(299)
+		}
+	})
+}
+
+func (suite *LimitsSuite) newServer(rl RouteLimits, h http.Handler) *httptest.Server {
+	m, err := rl.Middleware()
+	suite.Require().NoError(err)
+	suite.Require().NotNil(m)
+
+	s := httptest.NewServer(m(h))
+	suite.T().Cleanup(s.Close)
+	return s
+}
+
+func (suite *LimitsSuite) do(method, url string, body io.Reader) *http.Response {
+	request, err := http.NewRequest(method, url, body)
+	suite.Require().NoError(err)
+
+	response, err := http.DefaultClient.Do(request)
+	suite.Require().NoError(err)
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+	return response
+}
+
+func (suite *LimitsSuite) TestMatch() {
+	rl := RouteLimits{
+		{PathPrefix: "/", Timeout: time.Second},
+		{PathPrefix: "/upload", MaxBodySize: 10},
+		{PathPrefix: "/upload/large", MaxBodySize: 100},
+		{PathPrefix: "/api/"},
+	}
+
+	suite.Equal(rl[0], rl.find("/"))
+	suite.Equal(rl[0], rl.find("/uploads"))
+	suite.Equal(rl[1], rl.find("/upload"))
+	suite.Equal(rl[1], rl.find("/upload/file"))
+	suite.Equal(rl[2], rl.find("/upload/large/file"))
+	suite.Equal(rl[3], rl.find("/api/v1"))
+	suite.Equal(rl[0], rl.find("/api"))
+	suite.Equal(RouteLimit{}, RouteLimits{{PathPrefix: "/upload"}}.find("/other"))
+}
+
+func (suite *LimitsSuite) TestNoLimits() {
+	m, err := RouteLimits{}.Middleware()
+	suite.NoError(err)
+	suite.Nil(m)
+
+	s := new(http.Server)
+	suite.NoError(RouteLimits{}.Apply(s))
+	suite.Nil(s.Handler)
+}
+
+func (suite *LimitsSuite) TestInvalid() {
+	for _, invalid := range []RouteLimits{
+		{{PathPrefix: ""}},
+		{{PathPrefix: "upload"}},
+		{{PathPrefix: "/upload"}, {PathPrefix: "/upload"}},
+		{{PathPrefix: "/upload", Timeout: -1}},
+		{{PathPrefix: "/upload", MaxBodySize: -1}},
+	} {
+		m, err := invalid.Middleware()
+		suite.Error(err)
+		suite.Nil(m)
+
+		suite.Error(ServerConfig{Limits: invalid}.Apply(new(http.Server)))
+	}
+}
+
+func (suite *LimitsSuite) TestMaxBodySize() {
+	s := suite.newServer(
+		RouteLimits{{PathPrefix: "/upload", MaxBodySize: 10}},
+		suite.readAll(),
+	)
+
+	suite.Equal(299, suite.do("POST", s.URL+"/upload", strings.NewReader("small")).StatusCode)
+	suite.Equal(http.StatusRequestEntityTooLarge, suite.do("POST", s.URL+"/upload", strings.NewReader("this body is too large")).StatusCode)
+	suite.Equal(299, suite.do("POST", s.URL+"/other", strings.NewReader("this body is not limited")).StatusCode)
+
+	// no Content-Length, so the limit is detected as the body is read
+	suite.Equal(
+		http.StatusRequestEntityTooLarge,
+		suite.do("POST", s.URL+"/upload", io.MultiReader(strings.NewReader("this body"), strings.NewReader(" is too large"))).StatusCode,
+	)
+}
+
+func (suite *LimitsSuite) TestTimeout() {
+	s := suite.newServer(
+		RouteLimits{{PathPrefix: "/slow", Timeout: 50 * time.Millisecond}},
+		suite.waitForTimeout(),
+	)
+
+	start := time.Now()
+	suite.Equal(http.StatusServiceUnavailable, suite.do("GET", s.URL+"/slow", nil).StatusCode)
+	suite.Less(time.Since(start), 5*time.Second)
+}
+
+func (suite *LimitsSuite) TestTimeoutAfterResponse() {
+	s := suite.newServer(
+		RouteLimits{{PathPrefix: "/", Timeout: 50 * time.Millisecond}},
+		http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			response.WriteHeader(299)
+			<-request.Context().Done()
+		}),
+	)
+
+	// once the response has begun, the handler's status stands
+	suite.Equal(299, suite.do("GET", s.URL+"/", nil).StatusCode)
+}
+
+func (suite *LimitsSuite) TestTimeoutWithoutDeadlines() {
+	m, err := RouteLimits{{PathPrefix: "/", Timeout: 50 * time.Millisecond}}.Middleware()
+	suite.Require().NoError(err)
+
+	// httptest.ResponseRecorder doesn't support deadlines, so http.TimeoutHandler is used
+	canceled := make(chan error, 1)
+	h := m(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		<-request.Context().Done()
+		canceled <- request.Context().Err()
+	}))
+
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	suite.Equal(http.StatusServiceUnavailable, response.Code)
+	select {
+	case err := <-canceled:
+		suite.Error(err)
+	case <-time.After(5 * time.Second):
+		suite.Fail("The handler's context was not canceled")
+	}
+}
+
+func (suite *LimitsSuite) TestServerConfig() {
+	s := &http.Server{
+		Handler: suite.readAll(),
+	}
+
+	sc := ServerConfig{
+		Header: http.Header{"X-Server": {"true"}},
+		Limits: RouteLimits{{PathPrefix: "/upload", MaxBodySize: 10}},
+	}
+
+	suite.Require().NoError(sc.Apply(s))
+
+	response := httptest.NewRecorder()
+	s.Handler.ServeHTTP(response, httptest.NewRequest("POST", "/upload", strings.NewReader("this body is too large")))
+	suite.Equal(http.StatusRequestEntityTooLarge, response.Code)
+	suite.Equal("true", response.Header().Get("X-Server"))
+}
+
+func (suite *LimitsSuite) TestProvideServer() {
+	var (
+		capture = make(chan net.Addr, 1)
+		e       testSpanExporter
+	)
+
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			zap.NewNop(),
+			fx.Annotate(NewMetricsRegistry(), fx.As(new(Registry))),
+			fx.Annotate(&e, fx.As(new(SpanExporter))),
+			fx.Annotated{
+				Name: "server.config",
+				Target: ServerConfig{
+					Address:   "127.0.0.1:0",
+					Limits:    RouteLimits{{PathPrefix: "/", Timeout: time.Minute}},
+					AccessLog: AccessLogConfig{Enabled: true},
+					Metrics:   MetricsConfig{Enabled: true},
+					Recovery:  RecoveryConfig{Enabled: true},
+					RequestID: RequestIDConfig{Enabled: true},
+					Trace:     TraceConfig{Enabled: true},
+				},
+			},
+		),
+		fx.Provide(
+			fx.Annotate(
+				func() http.Handler {
+					return http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+						// http.TimeoutHandler's writer does not support deadlines
+						err := http.NewResponseController(response).SetWriteDeadline(time.Now().Add(time.Minute))
+						if err != nil {
+							response.WriteHeader(http.StatusInternalServerError)
+						}
+					})
+				},
+				arrange.Tags().Name("server.handler").ResultTags(),
+			),
+		),
+		ProvideServer("server", arrangetest.ListenCapture(capture)),
+	)
+
+	app.RequireStart()
+	defer app.RequireStop()
+
+	serverAddr := arrangetest.ListenReceive(suite, capture, 2*time.Second)
+	response := suite.do("GET", "http://"+serverAddr.String()+"/", nil)
+	suite.Equal(http.StatusOK, response.StatusCode)
+}
+
+func TestLimits(t *testing.T) {
+	suite.Run(t, new(LimitsSuite))
+}
@@ -0,0 +1,476 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xmidt-org/arrange/internal/arrangereflect"
+)
+
+const (
+	// LoadSheddingStatic is the LoadSheddingConfig.Algorithm that never changes the limit.
+	// This is the default algorithm.
+	LoadSheddingStatic = "static"
+
+	// LoadSheddingAIMD is the LoadSheddingConfig.Algorithm that increases the limit by one for
+	// each request that completes within the latency threshold, and decreases the limit by the
+	// backoff ratio for each request that doesn't.
+	LoadSheddingAIMD = "aimd"
+
+	// LoadSheddingGradient is the LoadSheddingConfig.Algorithm that adjusts the limit by the
+	// ratio of the lowest observed latency to each request's latency.  The limit grows while
+	// latency stays near its minimum and shrinks as latency rises.
+	LoadSheddingGradient = "gradient"
+
+	// DefaultLoadSheddingLatencyThreshold is the latency threshold used by the AIMD algorithm
+	// when LoadSheddingConfig.LatencyThreshold is unset.
+	DefaultLoadSheddingLatencyThreshold = 500 * time.Millisecond
+
+	// DefaultLoadSheddingBackoff is the ratio by which the AIMD algorithm decreases the limit
+	// when LoadSheddingConfig.Backoff is unset.
+	DefaultLoadSheddingBackoff = 0.9
+
+	// DefaultLoadSheddingRetryAfter is the Retry-After sent with rejected requests when
+	// LoadSheddingConfig.RetryAfter is unset.
+	DefaultLoadSheddingRetryAfter = time.Second
+
+	// gradientSmoothing is the weight given to each new limit computed by the gradient algorithm.
+	gradientSmoothing = 0.2
+
+	// gradientResetSamples is the number of samples after which the gradient algorithm
+	// forgets its lowest observed latency, so that it can adapt to a slower baseline.
+	gradientResetSamples = 1000
+)
+
+// Priority is the class of a request for load shedding.  When the wait queue is full,
+// a request with a higher priority displaces the lowest priority request in the queue.
+// Among waiting requests, higher priorities are admitted first.
+type Priority int
+
+const (
+	// PriorityLow is for requests that should be shed first, such as batch or background work.
+	PriorityLow Priority = -1
+
+	// PriorityNormal is the priority of requests when there is no RequestClassifier.
+	PriorityNormal Priority = 0
+
+	// PriorityHigh is for requests that should be shed last, such as interactive traffic.
+	PriorityHigh Priority = 1
+)
+
+// RequestClassifier assigns a Priority to a request.
+type RequestClassifier func(*http.Request) Priority
+
+// LoadSheddingConfig is the unmarshaled configuration for limiting the number of requests a
+// server handles concurrently.  Requests over the limit wait in a bounded queue, and requests
+// that cannot be queued or that wait too long are rejected with a 503 and a Retry-After.
+type LoadSheddingConfig struct {
+	// Limit is the maximum number of concurrent requests.  For the adaptive algorithms, this is
+	// the initial limit.  If unset, load shedding is disabled.
+	Limit int `json:"limit" yaml:"limit"`
+
+	// Algorithm is how the limit changes over time:  LoadSheddingStatic, LoadSheddingAIMD, or
+	// LoadSheddingGradient.  If unset, LoadSheddingStatic is used.
+	Algorithm string `json:"algorithm" yaml:"algorithm"`
+
+	// MinLimit is the lowest an adaptive limit can go.  If unset, 1 is used.
+	MinLimit int `json:"minLimit" yaml:"minLimit"`
+
+	// MaxLimit is the highest an adaptive limit can go.  If unset, 10 times Limit is used.
+	MaxLimit int `json:"maxLimit" yaml:"maxLimit"`
+
+	// LatencyThreshold is the latency above which the AIMD algorithm decreases the limit.
+	// If unset, DefaultLoadSheddingLatencyThreshold is used.
+	LatencyThreshold time.Duration `json:"latencyThreshold" yaml:"latencyThreshold"`
+
+	// Backoff is the ratio, in the range (0.0, 1.0), by which the AIMD algorithm decreases
+	// the limit.  If unset, DefaultLoadSheddingBackoff is used.
+	Backoff float64 `json:"backoff" yaml:"backoff"`
+
+	// QueueSize is the maximum number of requests that wait for the limit to allow them.
+	// If unset, requests over the limit are rejected immediately.
+	QueueSize int `json:"queueSize" yaml:"queueSize"`
+
+	// QueueTimeout is the maximum time a request waits in the queue.  If unset, a request
+	// waits until it is admitted, displaced, or canceled.
+	QueueTimeout time.Duration `json:"queueTimeout" yaml:"queueTimeout"`
+
+	// RetryAfter is the Retry-After sent with rejected requests, rounded up to whole seconds.
+	// If unset, DefaultLoadSheddingRetryAfter is used.
+	RetryAfter time.Duration `json:"retryAfter" yaml:"retryAfter"`
+
+	// ExpvarName, if set, publishes the limiter's state as an expvar with this name.
+	ExpvarName string `json:"expvarName" yaml:"expvarName"`
+
+	// Classifier assigns priorities to requests.  If unset, every request has PriorityNormal.
+	Classifier RequestClassifier `json:"-" yaml:"-"`
+}
+
+// limitAlgorithm is the strategy for adapting a limit.
+type limitAlgorithm interface {
+	// update computes a new limit from the latency of a request and the number of
+	// requests in flight when that request was admitted.
+	update(limit float64, latency time.Duration, inFlight int) float64
+}
+
+// aimd is the additive increase, multiplicative decrease limitAlgorithm.
+type aimd struct {
+	threshold time.Duration
+	backoff   float64
+}
+
+func (a *aimd) update(limit float64, latency time.Duration, inFlight int) float64 {
+	switch {
+	case latency > a.threshold:
+		return limit * a.backoff
+
+	case float64(inFlight*2) >= limit:
+		// only grow the limit when it is actually being used
+		return limit + 1.0
+
+	default:
+		return limit
+	}
+}
+
+// gradient is the limitAlgorithm that tracks the ratio of the lowest
+// observed latency to current latencies.
+type gradient struct {
+	minLatency time.Duration
+	samples    int
+}
+
+func (g *gradient) update(limit float64, latency time.Duration, inFlight int) float64 {
+	latency = max(latency, time.Nanosecond)
+	if g.minLatency == 0 || latency < g.minLatency || g.samples >= gradientResetSamples {
+		g.minLatency = latency
+		g.samples = 0
+	}
+
+	g.samples++
+	ratio := min(max(float64(g.minLatency)/float64(latency), 0.5), 1.0)
+	next := limit*ratio + math.Sqrt(limit)
+	if next > limit && float64(inFlight*2) < limit {
+		// only grow the limit when it is actually being used
+		return limit
+	}
+
+	return limit*(1.0-gradientSmoothing) + next*gradientSmoothing
+}
+
+// limitWaiter is a request waiting in a Limiter's queue.
+type limitWaiter struct {
+	priority Priority
+	ready    chan struct{}
+
+	// these fields are guarded by the Limiter's lock
+	granted  bool
+	evicted  bool
+	inFlight int
+}
+
+// Limiter limits the number of requests handled concurrently.  Use LoadSheddingConfig.NewLimiter
+// to create a Limiter.  The methods that report state are safe for concurrent use and are
+// intended for monitoring.
+type Limiter struct {
+	minLimit     float64
+	maxLimit     float64
+	algorithm    limitAlgorithm
+	queueSize    int
+	queueTimeout time.Duration
+	retryAfter   string
+	classifier   RequestClassifier
+	rejected     atomic.Uint64
+
+	lock     sync.Mutex
+	limit    float64
+	inFlight int
+	queue    []*limitWaiter
+}
+
+// Limit returns the current concurrency limit.
+func (l *Limiter) Limit() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return int(l.limit)
+}
+
+// InFlight returns the number of requests currently being handled.
+func (l *Limiter) InFlight() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.inFlight
+}
+
+// Queued returns the number of requests currently waiting.
+func (l *Limiter) Queued() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return len(l.queue)
+}
+
+// Rejected returns the number of requests that have been rejected.
+func (l *Limiter) Rejected() uint64 {
+	return l.rejected.Load()
+}
+
+// grant admits waiting requests, highest priority first, while the limit allows.
+// The lock must be held when calling this method.
+func (l *Limiter) grant() {
+	for len(l.queue) > 0 && l.inFlight < int(l.limit) {
+		w := l.queue[0]
+		l.queue = l.queue[1:]
+		l.inFlight++
+		w.granted = true
+		w.inFlight = l.inFlight
+		close(w.ready)
+	}
+}
+
+// enqueue adds a waiter to the queue, displacing the lowest priority waiter if the queue is
+// full.  This method returns false if the waiter cannot be queued.  The lock must be held
+// when calling this method.
+func (l *Limiter) enqueue(w *limitWaiter) bool {
+	if len(l.queue) >= l.queueSize {
+		// the queue is ordered by priority, so the last waiter has the lowest priority
+		last := len(l.queue) - 1
+		if last < 0 || l.queue[last].priority >= w.priority {
+			return false
+		}
+
+		l.queue[last].evicted = true
+		close(l.queue[last].ready)
+		l.queue = l.queue[:last]
+	}
+
+	// waiters with the same priority are admitted in the order they arrived
+	i := len(l.queue)
+	for i > 0 && l.queue[i-1].priority < w.priority {
+		i--
+	}
+
+	l.queue = append(l.queue, nil)
+	copy(l.queue[i+1:], l.queue[i:])
+	l.queue[i] = w
+	return true
+}
+
+// remove takes a waiter that gave up out of the queue.  The lock must be held
+// when calling this method.
+func (l *Limiter) remove(w *limitWaiter) {
+	for i, candidate := range l.queue {
+		if candidate == w {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// acquire admits a request, waiting in the queue if necessary.  This method returns the
+// number of requests in flight upon admission, or false if the request was rejected.
+func (l *Limiter) acquire(ctx context.Context, p Priority) (int, bool) {
+	l.lock.Lock()
+	if l.inFlight < int(l.limit) {
+		l.inFlight++
+		inFlight := l.inFlight
+		l.lock.Unlock()
+		return inFlight, true
+	}
+
+	w := &limitWaiter{
+		priority: p,
+		ready:    make(chan struct{}),
+	}
+
+	queued := l.queueSize > 0 && l.enqueue(w)
+	l.lock.Unlock()
+	if !queued {
+		return 0, false
+	}
+
+	var timeout <-chan time.Time
+	if l.queueTimeout > 0 {
+		t := time.NewTimer(l.queueTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case <-w.ready:
+	case <-timeout:
+	case <-ctx.Done():
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	switch {
+	case w.granted:
+		return w.inFlight, true
+
+	case !w.evicted:
+		l.remove(w)
+	}
+
+	return 0, false
+}
+
+// release frees the slot held by a request and adapts the limit.
+func (l *Limiter) release(latency time.Duration, inFlight int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.inFlight--
+	if l.algorithm != nil {
+		l.limit = min(max(l.algorithm.update(l.limit, latency, inFlight), l.minLimit), l.maxLimit)
+	}
+
+	l.grant()
+}
+
+// Middleware is a server middleware that applies this Limiter to each request.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		p := PriorityNormal
+		if l.classifier != nil {
+			p = l.classifier(request)
+		}
+
+		inFlight, ok := l.acquire(request.Context(), p)
+		if !ok {
+			l.rejected.Add(1)
+			response.Header().Set("Retry-After", l.retryAfter)
+			http.Error(response, "The server is overloaded", http.StatusServiceUnavailable)
+			return
+		}
+
+		start := time.Now()
+		defer func() {
+			l.release(time.Since(start), inFlight)
+		}()
+
+		next.ServeHTTP(response, request)
+	})
+}
+
+// NewLimiter creates a Limiter from this configuration.  If ExpvarName is set, the
+// limiter's state is published with that name.  Creating another Limiter with the same
+// ExpvarName publishes the most recent Limiter's state.
+//
+// If Limit is not positive, this method returns a nil Limiter.
+func (lsc LoadSheddingConfig) NewLimiter() (*Limiter, error) {
+	minLimit := max(lsc.MinLimit, 1)
+	maxLimit := lsc.MaxLimit
+	if maxLimit <= 0 {
+		maxLimit = lsc.Limit * 10
+	}
+
+	switch {
+	case lsc.Limit <= 0:
+		return nil, nil
+
+	case minLimit > maxLimit:
+		return nil, fmt.Errorf("Invalid load shedding limits: min %d, max %d", minLimit, maxLimit)
+
+	case lsc.Backoff < 0.0 || lsc.Backoff >= 1.0:
+		return nil, fmt.Errorf("Invalid load shedding backoff: %f", lsc.Backoff)
+
+	case lsc.QueueSize < 0:
+		return nil, fmt.Errorf("Invalid load shedding queue size: %d", lsc.QueueSize)
+	}
+
+	l := &Limiter{
+		minLimit:     float64(minLimit),
+		maxLimit:     float64(maxLimit),
+		queueSize:    lsc.QueueSize,
+		queueTimeout: lsc.QueueTimeout,
+		classifier:   lsc.Classifier,
+		limit:        float64(lsc.Limit),
+	}
+
+	switch lsc.Algorithm {
+	case "", LoadSheddingStatic:
+		// the limit never changes
+
+	case LoadSheddingAIMD:
+		a := &aimd{
+			threshold: lsc.LatencyThreshold,
+			backoff:   lsc.Backoff,
+		}
+
+		if a.threshold <= 0 {
+			a.threshold = DefaultLoadSheddingLatencyThreshold
+		}
+
+		if a.backoff == 0.0 {
+			a.backoff = DefaultLoadSheddingBackoff
+		}
+
+		l.algorithm = a
+
+	case LoadSheddingGradient:
+		l.algorithm = new(gradient)
+
+	default:
+		return nil, fmt.Errorf("Invalid load shedding algorithm: %s", lsc.Algorithm)
+	}
+
+	if l.algorithm != nil {
+		l.limit = min(max(l.limit, l.minLimit), l.maxLimit)
+	}
+
+	retryAfter := lsc.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = DefaultLoadSheddingRetryAfter
+	}
+
+	l.retryAfter = strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10)
+
+	if len(lsc.ExpvarName) > 0 {
+		err := publishExpvar(lsc.ExpvarName, func() any {
+			return map[string]any{
+				"limit":    l.Limit(),
+				"inFlight": l.InFlight(),
+				"queued":   l.Queued(),
+				"rejected": l.Rejected(),
+			}
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// Middleware returns a server middleware that sheds load according to this configuration.
+// Use NewLimiter instead to have access to the Limiter's state.
+//
+// If Limit is not positive, this method returns a nil middleware.
+func (lsc LoadSheddingConfig) Middleware() (func(http.Handler) http.Handler, error) {
+	l, err := lsc.NewLimiter()
+	if l == nil {
+		return nil, err
+	}
+
+	return l.Middleware, nil
+}
+
+// Apply allows a LoadSheddingConfig to be used as an Option[http.Server].
+func (lsc LoadSheddingConfig) Apply(s *http.Server) error {
+	m, err := lsc.Middleware()
+	if m != nil {
+		s.Handler = m(arrangereflect.Safe[http.Handler](s.Handler, http.DefaultServeMux))
+	}
+
+	return err
+}
@@ -0,0 +1,375 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type LoadSheddingSuite struct {
+	suite.Suite
+}
+
+func (suite *LoadSheddingSuite) newLimiter(lsc LoadSheddingConfig) *Limiter {
+	l, err := lsc.NewLimiter()
+	suite.Require().NoError(err)
+	suite.Require().NotNil(l)
+	return l
+}
+
+// blocking returns a handler that doesn't return until release is closed.
+func (suite *LoadSheddingSuite) blocking(release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		<-release
+		response.WriteHeader(299)
+	})
+}
+
+// serveAsync serves a request in a goroutine, returning a channel that receives the response.
+func (suite *LoadSheddingSuite) serveAsync(h http.Handler, request *http.Request) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, request)
+		done <- response
+	}()
+
+	return done
+}
+
+func (suite *LoadSheddingSuite) receive(done <-chan *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+	select {
+	case response := <-done:
+		return response
+	case <-time.After(5 * time.Second):
+		suite.FailNow("No response was received")
+		return nil
+	}
+}
+
+func (suite *LoadSheddingSuite) serve(h http.Handler) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	return response
+}
+
+func (suite *LoadSheddingSuite) TestDisabled() {
+	l, err := LoadSheddingConfig{}.NewLimiter()
+	suite.NoError(err)
+	suite.Nil(l)
+
+	m, err := LoadSheddingConfig{}.Middleware()
+	suite.NoError(err)
+	suite.Nil(m)
+
+	s := new(http.Server)
+	suite.NoError(LoadSheddingConfig{}.Apply(s))
+	suite.Nil(s.Handler)
+}
+
+func (suite *LoadSheddingSuite) TestInvalid() {
+	for _, invalid := range []LoadSheddingConfig{
+		{Limit: 1, Algorithm: "unknown"},
+		{Limit: 1, MinLimit: 20, MaxLimit: 10},
+		{Limit: 1, Backoff: -0.5},
+		{Limit: 1, Backoff: 1.0},
+		{Limit: 1, QueueSize: -1},
+	} {
+		l, err := invalid.NewLimiter()
+		suite.Error(err)
+		suite.Nil(l)
+
+		suite.Error(ServerConfig{LoadShedding: invalid}.Apply(new(http.Server)))
+	}
+}
+
+func (suite *LoadSheddingSuite) TestReject() {
+	var (
+		release = make(chan struct{})
+		l       = suite.newLimiter(LoadSheddingConfig{Limit: 1, RetryAfter: 1500 * time.Millisecond})
+		h       = l.Middleware(suite.blocking(release))
+	)
+
+	first := suite.serveAsync(h, httptest.NewRequest("GET", "/", nil))
+	suite.Eventually(func() bool { return l.InFlight() == 1 }, time.Second, time.Millisecond)
+
+	response := suite.serve(h)
+	suite.Equal(http.StatusServiceUnavailable, response.Code)
+	suite.Equal("2", response.Header().Get("Retry-After"))
+	suite.Equal(uint64(1), l.Rejected())
+
+	close(release)
+	suite.Equal(299, suite.receive(first).Code)
+	suite.Zero(l.InFlight())
+	suite.Equal(1, l.Limit())
+	suite.Equal(299, suite.serve(h).Code)
+}
+
+func (suite *LoadSheddingSuite) TestQueue() {
+	var (
+		release = make(chan struct{})
+		l       = suite.newLimiter(LoadSheddingConfig{Limit: 1, QueueSize: 1})
+		h       = l.Middleware(suite.blocking(release))
+	)
+
+	first := suite.serveAsync(h, httptest.NewRequest("GET", "/", nil))
+	suite.Eventually(func() bool { return l.InFlight() == 1 }, time.Second, time.Millisecond)
+
+	second := suite.serveAsync(h, httptest.NewRequest("GET", "/", nil))
+	suite.Eventually(func() bool { return l.Queued() == 1 }, time.Second, time.Millisecond)
+
+	// the queue is full
+	suite.Equal(http.StatusServiceUnavailable, suite.serve(h).Code)
+
+	close(release)
+	suite.Equal(299, suite.receive(first).Code)
+	suite.Equal(299, suite.receive(second).Code)
+	suite.Zero(l.Queued())
+	suite.Zero(l.InFlight())
+}
+
+func (suite *LoadSheddingSuite) TestQueueTimeout() {
+	var (
+		release = make(chan struct{})
+		l       = suite.newLimiter(LoadSheddingConfig{Limit: 1, QueueSize: 1, QueueTimeout: 10 * time.Millisecond})
+		h       = l.Middleware(suite.blocking(release))
+	)
+
+	defer close(release)
+	suite.serveAsync(h, httptest.NewRequest("GET", "/", nil))
+	suite.Eventually(func() bool { return l.InFlight() == 1 }, time.Second, time.Millisecond)
+
+	suite.Equal(http.StatusServiceUnavailable, suite.serve(h).Code)
+	suite.Zero(l.Queued())
+}
+
+func (suite *LoadSheddingSuite) TestQueueCanceled() {
+	var (
+		release = make(chan struct{})
+		l       = suite.newLimiter(LoadSheddingConfig{Limit: 1, QueueSize: 1})
+		h       = l.Middleware(suite.blocking(release))
+	)
+
+	defer close(release)
+	suite.serveAsync(h, httptest.NewRequest("GET", "/", nil))
+	suite.Eventually(func() bool { return l.InFlight() == 1 }, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	queued := suite.serveAsync(h, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	suite.Eventually(func() bool { return l.Queued() == 1 }, time.Second, time.Millisecond)
+
+	cancel()
+	suite.Equal(http.StatusServiceUnavailable, suite.receive(queued).Code)
+	suite.Zero(l.Queued())
+}
+
+func (suite *LoadSheddingSuite) TestPriority() {
+	var (
+		release = make(chan struct{})
+		order   = make(chan string, 3)
+		l       = suite.newLimiter(LoadSheddingConfig{
+			Limit:     1,
+			QueueSize: 2,
+			Classifier: func(request *http.Request) Priority {
+				switch request.URL.Path {
+				case "/low":
+					return PriorityLow
+				case "/high":
+					return PriorityHigh
+				default:
+					return PriorityNormal
+				}
+			},
+		})
+
+		h = l.Middleware(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			<-release
+			order <- request.URL.Path
+		}))
+	)
+
+	first := suite.serveAsync(h, httptest.NewRequest("GET", "/first", nil))
+	suite.Eventually(func() bool { return l.InFlight() == 1 }, time.Second, time.Millisecond)
+
+	low := suite.serveAsync(h, httptest.NewRequest("GET", "/low", nil))
+	suite.Eventually(func() bool { return l.Queued() == 1 }, time.Second, time.Millisecond)
+
+	normal := suite.serveAsync(h, httptest.NewRequest("GET", "/normal", nil))
+	suite.Eventually(func() bool { return l.Queued() == 2 }, time.Second, time.Millisecond)
+
+	// the queue is full, so the high priority request displaces the low priority request
+	high := suite.serveAsync(h, httptest.NewRequest("GET", "/high", nil))
+	suite.Equal(http.StatusServiceUnavailable, suite.receive(low).Code)
+	suite.Eventually(func() bool { return l.Queued() == 2 }, time.Second, time.Millisecond)
+
+	// a request with no higher priority than anything queued is rejected
+	suite.Equal(http.StatusServiceUnavailable, suite.serve(h).Code)
+
+	close(release)
+	suite.receive(first)
+	suite.receive(high)
+	suite.receive(normal)
+	suite.Equal("/first", <-order)
+	suite.Equal("/high", <-order)
+	suite.Equal("/normal", <-order)
+}
+
+func (suite *LoadSheddingSuite) TestAIMD() {
+	a := &aimd{threshold: 100 * time.Millisecond, backoff: 0.5}
+	suite.Equal(11.0, a.update(10.0, time.Millisecond, 5))
+	suite.Equal(10.0, a.update(10.0, time.Millisecond, 1)) // limit isn't being used
+	suite.Equal(5.0, a.update(10.0, time.Second, 10))
+
+	l := suite.newLimiter(LoadSheddingConfig{
+		Limit:            10,
+		Algorithm:        LoadSheddingAIMD,
+		MinLimit:         5,
+		MaxLimit:         11,
+		LatencyThreshold: 100 * time.Millisecond,
+		Backoff:          0.5,
+	})
+
+	l.release(time.Millisecond, 10)
+	l.release(time.Millisecond, 10)
+	suite.Equal(11, l.Limit())
+
+	l.release(time.Second, 10)
+	l.release(time.Second, 10)
+	suite.Equal(5, l.Limit())
+}
+
+func (suite *LoadSheddingSuite) TestGradient() {
+	var (
+		g     = new(gradient)
+		limit = 10.0
+	)
+
+	// steady latency grows the limit
+	for range 10 {
+		limit = g.update(limit, 10*time.Millisecond, int(limit))
+	}
+
+	suite.Greater(limit, 10.0)
+
+	// rising latency shrinks it
+	grown := limit
+	for range 20 {
+		limit = g.update(limit, 100*time.Millisecond, int(limit))
+	}
+
+	suite.Less(limit, grown)
+
+	// an idle server doesn't grow the limit
+	suite.Equal(limit, g.update(limit, 10*time.Millisecond, 0))
+
+	l := suite.newLimiter(LoadSheddingConfig{Limit: 100, Algorithm: LoadSheddingGradient, MaxLimit: 50})
+	suite.Equal(50, l.Limit())
+}
+
+func (suite *LoadSheddingSuite) TestExpvar() {
+	lsc := LoadSheddingConfig{
+		Limit:      5,
+		ExpvarName: "arrangehttp.TestLoadSheddingExpvar",
+	}
+
+	l := suite.newLimiter(lsc)
+	suite.Equal(299, suite.serve(l.Middleware(suite.blocking(closedChannel()))).Code)
+	suite.JSONEq(
+		`{"limit": 5, "inFlight": 0, "queued": 0, "rejected": 0}`,
+		expvar.Get(lsc.ExpvarName).String(),
+	)
+
+	// building the same configuration again publishes the new limiter
+	lsc.Limit = 7
+	suite.newLimiter(lsc)
+	suite.JSONEq(
+		`{"limit": 7, "inFlight": 0, "queued": 0, "rejected": 0}`,
+		expvar.Get(lsc.ExpvarName).String(),
+	)
+}
+
+func (suite *LoadSheddingSuite) TestServerConfig() {
+	var (
+		entered = make(chan struct{})
+		release = make(chan struct{})
+		s       = &http.Server{
+			Handler: http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+				close(entered)
+				<-release
+				response.WriteHeader(299)
+			}),
+		}
+	)
+
+	sc := ServerConfig{
+		Header:       http.Header{"X-Server": {"true"}},
+		LoadShedding: LoadSheddingConfig{Limit: 1},
+	}
+
+	suite.Require().NoError(sc.Apply(s))
+
+	first := suite.serveAsync(s.Handler, httptest.NewRequest("GET", "/", nil))
+	<-entered
+
+	response := suite.serve(s.Handler)
+	suite.Equal(http.StatusServiceUnavailable, response.Code)
+	suite.Equal("true", response.Header().Get("X-Server"))
+
+	close(release)
+	suite.Equal(299, suite.receive(first).Code)
+}
+
+func (suite *LoadSheddingSuite) TestServerConfigWithAuth() {
+	var (
+		entered = make(chan struct{})
+		release = make(chan struct{})
+		s       = &http.Server{
+			Handler: http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+				close(entered)
+				<-release
+				response.WriteHeader(299)
+			}),
+		}
+	)
+
+	sc := ServerConfig{
+		LoadShedding: LoadSheddingConfig{Limit: 1},
+		Auth: ServerAuthConfig{
+			BearerTokens: map[string]string{"joe": "token"},
+		},
+	}
+
+	suite.Require().NoError(sc.Apply(s))
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Authorization", "Bearer token")
+	first := suite.serveAsync(s.Handler, request)
+	<-entered
+
+	// requests are shed before their credentials are verified
+	response := suite.serve(s.Handler)
+	suite.Equal(http.StatusServiceUnavailable, response.Code)
+
+	close(release)
+	suite.Equal(299, suite.receive(first).Code)
+	suite.Equal(http.StatusUnauthorized, suite.serve(s.Handler).Code)
+}
+
+// closedChannel returns a channel that is already closed.
+func closedChannel() <-chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}
+
+func TestLoadShedding(t *testing.T) {
+	suite.Run(t, new(LoadSheddingSuite))
+}
@@ -0,0 +1,335 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultMaintenanceBody is the body of responses during maintenance when
+	// MaintenanceConfig.Body is unset.
+	DefaultMaintenanceBody = "The server is down for maintenance\n"
+
+	// DefaultMaintenanceContentType is the content type of responses during maintenance when
+	// MaintenanceConfig.ContentType is unset.
+	DefaultMaintenanceContentType = "text/plain; charset=utf-8"
+
+	// DefaultMaintenanceRetryAfter is the Retry-After sent during maintenance when
+	// MaintenanceConfig.RetryAfter is unset.
+	DefaultMaintenanceRetryAfter = time.Minute
+
+	// DefaultMaintenanceFileInterval is how often the maintenance file is checked when
+	// MaintenanceConfig.FileInterval is unset.
+	DefaultMaintenanceFileInterval = 5 * time.Second
+)
+
+var (
+	// ErrMaintenance is the readiness check error while a server is in maintenance.
+	ErrMaintenance = errors.New("The server is in maintenance")
+)
+
+// MaintenanceConfig is the unmarshaled configuration for a server's maintenance switch.
+// While the switch is on, the server answers every request with a 503, except for
+// requests under an Allow prefix.
+type MaintenanceConfig struct {
+	// Enabled is the initial state of the switch.
+	Enabled bool `json:"enabled" yaml:"enabled"`
+
+	// File is the path of a file whose existence turns the switch on.  Creating the file
+	// turns the switch on, and removing it turns the switch off.  Changes made in other ways
+	// are kept until the file is next created or removed.  If unset, no file is watched.
+	File string `json:"file" yaml:"file"`
+
+	// FileInterval is how often File is checked.  If unset, DefaultMaintenanceFileInterval is used.
+	FileInterval time.Duration `json:"fileInterval" yaml:"fileInterval"`
+
+	// Signals are the names of signals that toggle the switch, e.g. "SIGUSR1".  On Unix
+	// platforms, SIGHUP, SIGUSR1, and SIGUSR2 are supported.  Other platforms support no
+	// signals.  ProvideServer receives these signals while the enclosing fx.App is running.
+	Signals []string `json:"signals" yaml:"signals"`
+
+	// Body is the body of responses during maintenance.  If unset, DefaultMaintenanceBody is used.
+	Body string `json:"body" yaml:"body"`
+
+	// ContentType is the content type of Body.  If unset, DefaultMaintenanceContentType is used.
+	ContentType string `json:"contentType" yaml:"contentType"`
+
+	// RetryAfter is the Retry-After sent during maintenance, rounded up to whole seconds.
+	// If unset, DefaultMaintenanceRetryAfter is used.
+	RetryAfter time.Duration `json:"retryAfter" yaml:"retryAfter"`
+
+	// Allow are path prefixes that are served normally during maintenance, such as health
+	// checks.  A prefix matches whole path segments.
+	Allow []string `json:"allow" yaml:"allow"`
+}
+
+// maintenanceFactory is implemented by server factories that configure a maintenance
+// switch, such as ServerConfig.  A factory whose servers have no maintenance switch, even
+// though it embeds ServerConfig, returns false.
+type maintenanceFactory interface {
+	maintenanceConfig() (MaintenanceConfig, bool)
+}
+
+// Maintenance is a runtime switch that puts a server into maintenance.  It can be flipped
+// directly, through the admin endpoint returned by Handler, by the existence of a file, or
+// by signals.  All methods are safe for concurrent use.
+//
+// ProvideServer provides a Maintenance for each server whose ServerFactory configures one,
+// such as ServerConfig.
+type Maintenance struct {
+	enabled atomic.Bool
+
+	body        string
+	contentType string
+	retryAfter  string
+	allow       []string
+}
+
+// signals returns the signals named by this configuration.
+func (mc MaintenanceConfig) signals() ([]os.Signal, error) {
+	var signals []os.Signal
+	for _, name := range mc.Signals {
+		s, ok := maintenanceSignals[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("Unsupported maintenance signal: [%s]", name)
+		}
+
+		signals = append(signals, s)
+	}
+
+	return signals, nil
+}
+
+// NewMaintenance creates a Maintenance from its configuration.  MaintenanceConfig.File is
+// not watched and MaintenanceConfig.Signals are not received by this function.  See WatchFile
+// and NotifySignals.
+func NewMaintenance(mc MaintenanceConfig) (*Maintenance, error) {
+	for _, prefix := range mc.Allow {
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("Invalid maintenance allow prefix: [%s]", prefix)
+		}
+	}
+
+	if _, err := mc.signals(); err != nil {
+		return nil, err
+	}
+
+	if mc.FileInterval < 0 {
+		return nil, fmt.Errorf("Invalid maintenance file interval: %s", mc.FileInterval)
+	}
+
+	m := &Maintenance{
+		body:        mc.Body,
+		contentType: mc.ContentType,
+		allow:       append([]string(nil), mc.Allow...),
+	}
+
+	if len(m.body) == 0 {
+		m.body = DefaultMaintenanceBody
+	}
+
+	if len(m.contentType) == 0 {
+		m.contentType = DefaultMaintenanceContentType
+	}
+
+	retryAfter := mc.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = DefaultMaintenanceRetryAfter
+	}
+
+	m.retryAfter = strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10)
+	m.enabled.Store(mc.Enabled)
+	return m, nil
+}
+
+// Enabled tests if the server is in maintenance.
+func (m *Maintenance) Enabled() bool {
+	return m.enabled.Load()
+}
+
+// Set turns the switch on or off.
+func (m *Maintenance) Set(enabled bool) {
+	m.enabled.Store(enabled)
+}
+
+// Toggle flips the switch, returning the new state.
+func (m *Maintenance) Toggle() bool {
+	for {
+		current := m.enabled.Load()
+		if m.enabled.CompareAndSwap(current, !current) {
+			return !current
+		}
+	}
+}
+
+// Check is a HealthCheck function that fails with ErrMaintenance while the server is in
+// maintenance.  ProvideServer contributes this check to the HealthChecksGroup, so that a
+// server in maintenance isn't ready.
+func (m *Maintenance) Check(context.Context) error {
+	if m.Enabled() {
+		return ErrMaintenance
+	}
+
+	return nil
+}
+
+// Middleware returns a server middleware that answers requests with a 503 while the server
+// is in maintenance.  Requests under an allowed prefix are always passed to the decorated handler.
+func (m *Maintenance) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			if !m.Enabled() || m.allowed(request.URL.Path) {
+				next.ServeHTTP(response, request)
+				return
+			}
+
+			response.Header().Set("Content-Type", m.contentType)
+			response.Header().Set("Cache-Control", "no-store")
+			response.Header().Set("Retry-After", m.retryAfter)
+			response.WriteHeader(http.StatusServiceUnavailable)
+			response.Write([]byte(m.body))
+		})
+	}
+}
+
+func (m *Maintenance) allowed(path string) bool {
+	for _, prefix := range m.allow {
+		if matchPathPrefix(prefix, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maintenanceState is the body written by Maintenance.Handler.
+type maintenanceState struct {
+	Enabled bool `json:"enabled"`
+}
+
+// Handler returns an admin endpoint for this switch.  A PUT turns the switch on, a DELETE
+// turns it off, and a GET reports its state.  All three respond with the state as JSON.
+// For example, to mount the switch for the "main" server on an admin server:
+//
+//	fx.Provide(
+//	  fx.Annotate(
+//	    func(m *arrangehttp.Maintenance) arrangehttp.Route {
+//	      return arrangehttp.Route{Pattern: "/maintenance", Handler: m.Handler()}
+//	    },
+//	    arrange.Tags().Name("main.maintenance").ParamTags(),
+//	    arrange.Tags().Group("admin.routes").ResultTags(),
+//	  ),
+//	)
+func (m *Maintenance) Handler() http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet, http.MethodHead:
+
+		case http.MethodPut:
+			m.Set(true)
+
+		case http.MethodDelete:
+			m.Set(false)
+
+		default:
+			response.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+			http.Error(response, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		response.Header().Set("Content-Type", "application/json")
+		response.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(response).Encode(maintenanceState{Enabled: m.Enabled()})
+	})
+}
+
+// WatchFile polls for the existence of a file, turning the switch on when the file is
+// created and off when it is removed.  The file is checked once before this method returns.
+// If interval is nonpositive, DefaultMaintenanceFileInterval is used.
+//
+// The returned closure stops watching the file.
+func (m *Maintenance) WatchFile(path string, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = DefaultMaintenanceFileInterval
+	}
+
+	exists := func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	last := exists()
+	if last {
+		m.Set(true)
+	}
+
+	var (
+		ticker = time.NewTicker(interval)
+		done   = make(chan struct{})
+	)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+
+			case <-ticker.C:
+				if current := exists(); current != last {
+					last = current
+					m.Set(current)
+				}
+			}
+		}
+	}()
+
+	return sync.OnceFunc(func() { close(done) })
+}
+
+// NotifySignals toggles the switch each time the process receives one of the given signals,
+// e.g. syscall.SIGUSR1.  The returned closure stops the notifications.  ProvideServer calls
+// this method for MaintenanceConfig.Signals.
+func (m *Maintenance) NotifySignals(signals ...os.Signal) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	stopSignals := m.toggleOn(ch)
+
+	return func() {
+		signal.Stop(ch)
+		stopSignals()
+	}
+}
+
+// toggleOn toggles the switch for each value received on a channel, until the returned
+// closure is called.
+func (m *Maintenance) toggleOn(ch <-chan os.Signal) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+
+			case <-ch:
+				m.Toggle()
+			}
+		}
+	}()
+
+	return sync.OnceFunc(func() { close(done) })
+}
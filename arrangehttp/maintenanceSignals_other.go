@@ -0,0 +1,12 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !unix
+
+package arrangehttp
+
+import "os"
+
+// maintenanceSignals are the signals that MaintenanceConfig.Signals may name.  No
+// signals are supported on this platform.
+var maintenanceSignals = map[string]os.Signal{}
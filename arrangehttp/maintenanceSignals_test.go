@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build unix
+
+package arrangehttp
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange"
+	"github.com/xmidt-org/arrange/arrangetest"
+	"go.uber.org/fx"
+)
+
+type MaintenanceSignalsSuite struct {
+	suite.Suite
+}
+
+func (suite *MaintenanceSignalsSuite) TestSignals() {
+	signals, err := MaintenanceConfig{Signals: []string{"SIGHUP", " sigusr1 ", "SIGUSR2"}}.signals()
+	suite.Require().NoError(err)
+	suite.Equal([]os.Signal{syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2}, signals)
+}
+
+func (suite *MaintenanceSignalsSuite) TestProvide() {
+	// keeps SIGUSR1 from terminating the test process outside of the app's lifetime
+	ignore := make(chan os.Signal, 1)
+	signal.Notify(ignore, syscall.SIGUSR1)
+	defer signal.Stop(ignore)
+
+	var m *Maintenance
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			fx.Annotated{
+				Name: "server.config",
+				Target: ServerConfig{
+					Address:     ":0",
+					Maintenance: MaintenanceConfig{Signals: []string{"SIGUSR1"}},
+				},
+			},
+		),
+		ProvideServer("server"),
+		fx.Populate(
+			fx.Annotate(
+				&m,
+				arrange.Tags().Name("server.maintenance").ParamTags(),
+			),
+		),
+	)
+
+	suite.Require().NotNil(m)
+	suite.Require().NoError(syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	<-ignore
+	suite.False(m.Enabled(), "signals should not be received before the app starts")
+
+	app.RequireStart()
+	suite.Require().NoError(syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	suite.Eventually(m.Enabled, 2*time.Second, 10*time.Millisecond)
+	<-ignore
+
+	app.RequireStop()
+	suite.Require().NoError(syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	<-ignore
+	suite.True(m.Enabled(), "signals should not be received after the app stops")
+}
+
+func TestMaintenanceSignals(t *testing.T) {
+	suite.Run(t, new(MaintenanceSignalsSuite))
+}
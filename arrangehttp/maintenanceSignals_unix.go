@@ -0,0 +1,18 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build unix
+
+package arrangehttp
+
+import (
+	"os"
+	"syscall"
+)
+
+// maintenanceSignals are the signals that MaintenanceConfig.Signals may name.
+var maintenanceSignals = map[string]os.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+}
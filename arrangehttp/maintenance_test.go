@@ -0,0 +1,314 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange"
+	"github.com/xmidt-org/arrange/arrangetest"
+	"go.uber.org/fx"
+)
+
+type MaintenanceSuite struct {
+	suite.Suite
+}
+
+func (suite *MaintenanceSuite) newMaintenance(mc MaintenanceConfig) *Maintenance {
+	m, err := NewMaintenance(mc)
+	suite.Require().NoError(err)
+	suite.Require().NotNil(m)
+	return m
+}
+
+func (suite *MaintenanceSuite) serve(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, httptest.NewRequest(method, target, nil))
+	return response
+}
+
+func (suite *MaintenanceSuite) ok() http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		response.WriteHeader(http.StatusOK)
+	})
+}
+
+func (suite *MaintenanceSuite) TestInvalid() {
+	for _, invalid := range []MaintenanceConfig{
+		{Allow: []string{"healthz"}},
+		{FileInterval: -time.Second},
+		{Signals: []string{"SIGTERM"}},
+		{Signals: []string{"nosuchsignal"}},
+	} {
+		m, err := NewMaintenance(invalid)
+		suite.Error(err)
+		suite.Nil(m)
+	}
+}
+
+func (suite *MaintenanceSuite) TestSwitch() {
+	m := suite.newMaintenance(MaintenanceConfig{})
+	suite.False(m.Enabled())
+	suite.NoError(m.Check(context.Background()))
+
+	m.Set(true)
+	suite.True(m.Enabled())
+	suite.ErrorIs(m.Check(context.Background()), ErrMaintenance)
+
+	suite.False(m.Toggle())
+	suite.False(m.Enabled())
+	suite.True(m.Toggle())
+	suite.True(m.Enabled())
+
+	suite.True(suite.newMaintenance(MaintenanceConfig{Enabled: true}).Enabled())
+}
+
+func (suite *MaintenanceSuite) TestMiddleware() {
+	m := suite.newMaintenance(MaintenanceConfig{Allow: []string{"/healthz"}})
+	h := m.Middleware()(suite.ok())
+
+	suite.Equal(http.StatusOK, suite.serve(h, "GET", "/").Code)
+
+	m.Set(true)
+	response := suite.serve(h, "GET", "/")
+	suite.Equal(http.StatusServiceUnavailable, response.Code)
+	suite.Equal(DefaultMaintenanceBody, response.Body.String())
+	suite.Equal(DefaultMaintenanceContentType, response.Header().Get("Content-Type"))
+	suite.Equal("60", response.Header().Get("Retry-After"))
+
+	suite.Equal(http.StatusOK, suite.serve(h, "GET", "/healthz").Code)
+	suite.Equal(http.StatusOK, suite.serve(h, "GET", "/healthz/deep").Code)
+	suite.Equal(http.StatusServiceUnavailable, suite.serve(h, "GET", "/healthzz").Code)
+}
+
+func (suite *MaintenanceSuite) TestMiddlewareCustom() {
+	m := suite.newMaintenance(MaintenanceConfig{
+		Enabled:     true,
+		Body:        `{"message": "maintenance"}`,
+		ContentType: "application/json",
+		RetryAfter:  1500 * time.Millisecond,
+	})
+
+	response := suite.serve(m.Middleware()(suite.ok()), "POST", "/")
+	suite.Equal(http.StatusServiceUnavailable, response.Code)
+	suite.Equal(`{"message": "maintenance"}`, response.Body.String())
+	suite.Equal("application/json", response.Header().Get("Content-Type"))
+	suite.Equal("2", response.Header().Get("Retry-After"))
+}
+
+func (suite *MaintenanceSuite) TestHandler() {
+	m := suite.newMaintenance(MaintenanceConfig{})
+	h := m.Handler()
+
+	testData := []struct {
+		method       string
+		expectedCode int
+		expectedBody string
+	}{
+		{"GET", http.StatusOK, `{"enabled":false}`},
+		{"PUT", http.StatusOK, `{"enabled":true}`},
+		{"GET", http.StatusOK, `{"enabled":true}`},
+		{"POST", http.StatusMethodNotAllowed, ""},
+		{"DELETE", http.StatusOK, `{"enabled":false}`},
+	}
+
+	for _, record := range testData {
+		response := suite.serve(h, record.method, "/maintenance")
+		suite.Equal(record.expectedCode, response.Code, record.method)
+		if len(record.expectedBody) > 0 {
+			suite.JSONEq(record.expectedBody, response.Body.String())
+		}
+	}
+
+	suite.False(m.Enabled())
+}
+
+func (suite *MaintenanceSuite) TestWatchFile() {
+	var (
+		path = filepath.Join(suite.T().TempDir(), "maintenance")
+		m    = suite.newMaintenance(MaintenanceConfig{})
+	)
+
+	suite.Require().NoError(os.WriteFile(path, nil, 0600))
+	stop := m.WatchFile(path, 10*time.Millisecond)
+	defer stop()
+
+	// the file is checked before WatchFile returns
+	suite.True(m.Enabled())
+
+	// changes made in other ways are kept until the file changes
+	m.Set(false)
+	time.Sleep(50 * time.Millisecond)
+	suite.False(m.Enabled())
+
+	suite.Require().NoError(os.Remove(path))
+	m.Set(true)
+	suite.Eventually(func() bool { return !m.Enabled() }, 2*time.Second, 10*time.Millisecond)
+
+	suite.Require().NoError(os.WriteFile(path, nil, 0600))
+	suite.Eventually(m.Enabled, 2*time.Second, 10*time.Millisecond)
+
+	stop()
+	stop() // idempotent
+}
+
+func (suite *MaintenanceSuite) TestSignals() {
+	var (
+		m       = suite.newMaintenance(MaintenanceConfig{})
+		signals = make(chan os.Signal)
+		stop    = m.toggleOn(signals)
+	)
+
+	signals <- os.Interrupt
+	suite.Eventually(m.Enabled, 2*time.Second, 10*time.Millisecond)
+
+	signals <- os.Interrupt
+	suite.Eventually(func() bool { return !m.Enabled() }, 2*time.Second, 10*time.Millisecond)
+
+	stop()
+	stop() // idempotent
+
+	// stopping signal notifications is safe even if no signal ever arrived
+	m.NotifySignals(os.Interrupt)()
+}
+
+func (suite *MaintenanceSuite) TestProvide() {
+	var (
+		path = filepath.Join(suite.T().TempDir(), "maintenance")
+
+		m      *Maintenance
+		server *http.Server
+	)
+
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			fx.Annotated{
+				Name: "server.config",
+				Target: ServerConfig{
+					Address: ":0",
+					Maintenance: MaintenanceConfig{
+						File:         path,
+						FileInterval: 10 * time.Millisecond,
+						Allow:        []string{DefaultReadinessPath},
+					},
+				},
+			},
+		),
+		fx.Provide(
+			fx.Annotate(
+				func(h *Health) Option[http.Server] {
+					return ServerMiddleware(h.Middleware())
+				},
+				arrange.Tags().Group("server.options").ResultTags(),
+			),
+		),
+		ProvideHealth(),
+		ProvideServer("server"),
+		fx.Populate(
+			fx.Annotate(
+				&m,
+				arrange.Tags().Name("server.maintenance").ParamTags(),
+			),
+			fx.Annotate(
+				&server,
+				arrange.Tags().Name("server").ParamTags(),
+			),
+		),
+	)
+
+	suite.Require().NotNil(m)
+	app.RequireStart()
+
+	suite.Equal(http.StatusOK, suite.serve(server.Handler, "GET", DefaultReadinessPath).Code)
+	suite.NotEqual(http.StatusServiceUnavailable, suite.serve(server.Handler, "GET", "/").Code)
+
+	m.Set(true)
+	suite.Equal(http.StatusServiceUnavailable, suite.serve(server.Handler, "GET", "/").Code)
+
+	response := suite.serve(server.Handler, "GET", DefaultReadinessPath)
+	suite.Equal(http.StatusServiceUnavailable, response.Code)
+	suite.Contains(response.Body.String(), `"server.maintenance"`)
+
+	m.Set(false)
+	suite.Equal(http.StatusOK, suite.serve(server.Handler, "GET", DefaultReadinessPath).Code)
+
+	suite.Require().NoError(os.WriteFile(path, nil, 0600))
+	suite.Eventually(m.Enabled, 2*time.Second, 10*time.Millisecond)
+
+	app.RequireStop()
+}
+
+func (suite *MaintenanceSuite) TestProvideInvalid() {
+	app := arrangetest.NewErrApp(
+		suite,
+		fx.Supply(
+			fx.Annotated{
+				Name: "server.config",
+				Target: ServerConfig{
+					Address:     ":0",
+					Maintenance: MaintenanceConfig{Allow: []string{"relative"}},
+				},
+			},
+		),
+		ProvideServer("server"),
+	)
+
+	suite.Error(app.Err())
+}
+
+func (suite *MaintenanceSuite) TestProvideAdminServer() {
+	var (
+		m     *Maintenance
+		admin *http.Server
+	)
+
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			fx.Annotated{
+				Name: "admin.config",
+				Target: AdminConfig{
+					ServerConfig: ServerConfig{
+						Maintenance: MaintenanceConfig{Enabled: true},
+					},
+				},
+			},
+		),
+		ProvideHealth(),
+		ProvideAdminServer("admin"),
+		fx.Populate(
+			fx.Annotate(
+				&m,
+				arrange.Tags().Name("admin.maintenance").ParamTags(),
+			),
+			fx.Annotate(
+				&admin,
+				arrange.Tags().Name("admin").ParamTags(),
+			),
+		),
+	)
+
+	// admin servers have no maintenance switch, even if one is configured
+	suite.Nil(m)
+	app.RequireStart()
+
+	response := suite.serve(admin.Handler, "GET", DefaultReadinessPath)
+	suite.Equal(http.StatusOK, response.Code)
+	suite.NotContains(response.Body.String(), "admin.maintenance")
+	suite.Equal(http.StatusOK, suite.serve(admin.Handler, "GET", DefaultBuildInfoPath).Code)
+
+	app.RequireStop()
+}
+
+func TestMaintenance(t *testing.T) {
+	suite.Run(t, new(MaintenanceSuite))
+}
@@ -0,0 +1,455 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// DefaultExpvarName is the expvar name under which DefaultRegistry is published.
+	DefaultExpvarName = "arrangehttp"
+
+	// DefaultMetricsPath is the conventional path for a metrics endpoint.
+	DefaultMetricsPath = "/metrics"
+
+	// PrometheusContentType is the content type of the Prometheus text exposition format.
+	PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var (
+	// DefaultBuckets are the histogram buckets, in seconds, used when none are configured.
+	DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+)
+
+// Labels is a set of metric label names and values.
+type Labels map[string]string
+
+// Counter is a metric that only increases.
+type Counter interface {
+	Add(delta float64)
+}
+
+// Gauge is a metric that can increase, decrease, or be set to an arbitrary value.
+type Gauge interface {
+	Add(delta float64)
+	Set(value float64)
+}
+
+// Histogram is a metric that samples observations into buckets.
+type Histogram interface {
+	Observe(value float64)
+}
+
+// Registry is the minimal metrics abstraction used by arrangehttp instrumentation.  Each method
+// returns the metric with the given name and label values, creating it if necessary.  Adapters
+// for other metrics libraries only need to implement this interface.
+type Registry interface {
+	Counter(name, help string, labels Labels) Counter
+	Gauge(name, help string, labels Labels) Gauge
+	Histogram(name, help string, buckets []float64, labels Labels) Histogram
+}
+
+// MetricsConfig is the unmarshaled configuration for request metrics.
+type MetricsConfig struct {
+	// Enabled turns on metrics.
+	Enabled bool `json:"enabled" yaml:"enabled"`
+
+	// Buckets are the latency histogram buckets, in seconds.  If unset, DefaultBuckets is used.
+	Buckets []float64 `json:"buckets" yaml:"buckets"`
+
+	// Path is the request path on which a server exposes its Registry in the Prometheus text
+	// format, e.g. DefaultMetricsPath.  If unset, no metrics endpoint is exposed.  This field
+	// only applies to servers whose Registry is an http.Handler, such as MetricsRegistry.
+	// The endpoint is subject to the server's authentication, limits, and CORS configuration.
+	Path string `json:"path" yaml:"path"`
+}
+
+// buckets returns the configured histogram buckets.
+func (mc MetricsConfig) buckets() []float64 {
+	if len(mc.Buckets) > 0 {
+		return mc.Buckets
+	}
+
+	return DefaultBuckets
+}
+
+// metricsFactory is implemented by client and server factories that configure
+// request metrics, such as ClientConfig.
+type metricsFactory interface {
+	metricsConfig() MetricsConfig
+}
+
+// labeledRegistry is a Registry that adds constant labels to every metric.
+type labeledRegistry struct {
+	next        Registry
+	constLabels Labels
+}
+
+func (lr labeledRegistry) merge(labels Labels) Labels {
+	merged := make(Labels, len(lr.constLabels)+len(labels))
+	for k, v := range labels {
+		merged[k] = v
+	}
+
+	for k, v := range lr.constLabels {
+		merged[k] = v
+	}
+
+	return merged
+}
+
+func (lr labeledRegistry) Counter(name, help string, labels Labels) Counter {
+	return lr.next.Counter(name, help, lr.merge(labels))
+}
+
+func (lr labeledRegistry) Gauge(name, help string, labels Labels) Gauge {
+	return lr.next.Gauge(name, help, lr.merge(labels))
+}
+
+func (lr labeledRegistry) Histogram(name, help string, buckets []float64, labels Labels) Histogram {
+	return lr.next.Histogram(name, help, buckets, lr.merge(labels))
+}
+
+// WithLabels returns a Registry that adds the given constant labels to every metric
+// obtained through it.  Constant labels take precedence over per-metric labels.
+func WithLabels(r Registry, constLabels Labels) Registry {
+	if len(constLabels) == 0 {
+		return r
+	}
+
+	return labeledRegistry{
+		next:        r,
+		constLabels: constLabels,
+	}
+}
+
+// atomicFloat is a float64 that can be updated concurrently.
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (af *atomicFloat) Add(delta float64) {
+	for {
+		old := af.bits.Load()
+		if af.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (af *atomicFloat) Set(value float64) {
+	af.bits.Store(math.Float64bits(value))
+}
+
+func (af *atomicFloat) Load() float64 {
+	return math.Float64frombits(af.bits.Load())
+}
+
+// histogram is the in-project Histogram implementation.
+type histogram struct {
+	buckets []float64
+	counts  []atomic.Uint64
+	count   atomic.Uint64
+	sum     atomicFloat
+}
+
+func (h *histogram) Observe(value float64) {
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		h.counts[i].Add(1)
+	}
+
+	h.count.Add(1)
+	h.sum.Add(value)
+}
+
+// metricKind is the type of a metric family.
+type metricKind string
+
+const (
+	counterKind   metricKind = "counter"
+	gaugeKind     metricKind = "gauge"
+	histogramKind metricKind = "histogram"
+)
+
+// metricSeries is a single labeled metric within a family.
+type metricSeries struct {
+	labels string
+	value  atomicFloat
+	hist   *histogram
+}
+
+// metricFamily holds all the series for a single metric name.
+type metricFamily struct {
+	name    string
+	help    string
+	kind    metricKind
+	buckets []float64
+	series  map[string]*metricSeries
+}
+
+// MetricsRegistry is an in-project Registry.  A MetricsRegistry is an http.Handler that
+// emits its metrics in the Prometheus text exposition format, and it can also be
+// published as an expvar.
+//
+// Requesting an existing metric name with a different type panics, since that is
+// always a programming error.
+type MetricsRegistry struct {
+	lock     sync.RWMutex
+	families map[string]*metricFamily
+}
+
+var _ Registry = (*MetricsRegistry)(nil)
+var _ http.Handler = (*MetricsRegistry)(nil)
+
+// NewMetricsRegistry creates an empty MetricsRegistry.
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		families: make(map[string]*metricFamily),
+	}
+}
+
+var defaultRegistry = sync.OnceValue(func() *MetricsRegistry {
+	r := NewMetricsRegistry()
+	r.Publish(DefaultExpvarName)
+	return r
+})
+
+// DefaultRegistry returns the process-wide MetricsRegistry, which is published as an expvar
+// named DefaultExpvarName.  ProvideClient and ProvideServer use this registry when no
+// Registry component is available.
+func DefaultRegistry() *MetricsRegistry {
+	return defaultRegistry()
+}
+
+// escapeLabelValue escapes a label value for the text exposition format.
+var escapeLabelValue = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace
+
+// formatLabels produces the canonical text form of a set of labels, e.g. {a="1",b="2"}.
+func formatLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		fmt.Fprintf(&b, `%s="%s"`, name, escapeLabelValue(labels[name]))
+	}
+
+	b.WriteByte('}')
+	return b.String()
+}
+
+// series obtains or creates a metric series.
+func (r *MetricsRegistry) series(name, help string, kind metricKind, buckets []float64, labels Labels) *metricSeries {
+	key := formatLabels(labels)
+	r.lock.RLock()
+	mf := r.families[name]
+	var ms *metricSeries
+	if mf != nil && mf.kind == kind {
+		ms = mf.series[key]
+	}
+
+	r.lock.RUnlock()
+	if ms != nil {
+		return ms
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	mf = r.families[name]
+	if mf == nil {
+		mf = &metricFamily{
+			name:    name,
+			help:    help,
+			kind:    kind,
+			buckets: slices.Sorted(slices.Values(buckets)),
+			series:  make(map[string]*metricSeries),
+		}
+
+		r.families[name] = mf
+	} else if mf.kind != kind {
+		panic(fmt.Errorf("Metric %s is a %s, not a %s", name, mf.kind, kind))
+	}
+
+	if ms = mf.series[key]; ms == nil {
+		ms = &metricSeries{labels: key}
+		if kind == histogramKind {
+			ms.hist = &histogram{
+				buckets: mf.buckets,
+				counts:  make([]atomic.Uint64, len(mf.buckets)),
+			}
+		}
+
+		mf.series[key] = ms
+	}
+
+	return ms
+}
+
+// Counter returns the named counter with the given labels.
+func (r *MetricsRegistry) Counter(name, help string, labels Labels) Counter {
+	return &r.series(name, help, counterKind, nil, labels).value
+}
+
+// Gauge returns the named gauge with the given labels.
+func (r *MetricsRegistry) Gauge(name, help string, labels Labels) Gauge {
+	return &r.series(name, help, gaugeKind, nil, labels).value
+}
+
+// Histogram returns the named histogram with the given labels.  The buckets are only
+// used when the metric name is first created.  If buckets is empty, DefaultBuckets is used.
+func (r *MetricsRegistry) Histogram(name, help string, buckets []float64, labels Labels) Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	return r.series(name, help, histogramKind, buckets, labels).hist
+}
+
+// snapshot returns the families and their series in a stable order.
+func (r *MetricsRegistry) snapshot() (families []*metricFamily, series [][]*metricSeries) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, mf := range r.families {
+		families = append(families, mf)
+	}
+
+	slices.SortFunc(families, func(a, b *metricFamily) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	series = make([][]*metricSeries, len(families))
+	for i, mf := range families {
+		for _, ms := range mf.series {
+			series[i] = append(series[i], ms)
+		}
+
+		slices.SortFunc(series[i], func(a, b *metricSeries) int {
+			return strings.Compare(a.labels, b.labels)
+		})
+	}
+
+	return
+}
+
+// formatValue formats a sample value for the text exposition format.
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// withLabel appends a label to an already formatted set of labels.
+func withLabel(labels, name, value string) string {
+	if len(labels) == 0 {
+		return fmt.Sprintf(`{%s="%s"}`, name, value)
+	}
+
+	return fmt.Sprintf(`%s,%s="%s"}`, labels[:len(labels)-1], name, value)
+}
+
+// WriteText writes all metrics to the given writer in the Prometheus text exposition format.
+func (r *MetricsRegistry) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	families, series := r.snapshot()
+	for i, mf := range families {
+		if len(mf.help) > 0 {
+			fmt.Fprintf(bw, "# HELP %s %s\n", mf.name, strings.ReplaceAll(mf.help, "\n", `\n`))
+		}
+
+		fmt.Fprintf(bw, "# TYPE %s %s\n", mf.name, mf.kind)
+		for _, ms := range series[i] {
+			if ms.hist == nil {
+				fmt.Fprintf(bw, "%s%s %s\n", mf.name, ms.labels, formatValue(ms.value.Load()))
+				continue
+			}
+
+			var cumulative uint64
+			for j, upper := range ms.hist.buckets {
+				cumulative += ms.hist.counts[j].Load()
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", mf.name, withLabel(ms.labels, "le", formatValue(upper)), cumulative)
+			}
+
+			count := ms.hist.count.Load()
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", mf.name, withLabel(ms.labels, "le", "+Inf"), count)
+			fmt.Fprintf(bw, "%s_sum%s %s\n", mf.name, ms.labels, formatValue(ms.hist.sum.Load()))
+			fmt.Fprintf(bw, "%s_count%s %d\n", mf.name, ms.labels, count)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// ServeHTTP emits all metrics in the Prometheus text exposition format.
+func (r *MetricsRegistry) ServeHTTP(response http.ResponseWriter, _ *http.Request) {
+	response.Header().Set("Content-Type", PrometheusContentType)
+	r.WriteText(response)
+}
+
+// expvarValue produces the JSON-friendly representation of this registry.
+func (r *MetricsRegistry) expvarValue() any {
+	families, series := r.snapshot()
+	value := make(map[string]map[string]any, len(families))
+	for i, mf := range families {
+		values := make(map[string]any, len(series[i]))
+		for _, ms := range series[i] {
+			if ms.hist == nil {
+				values[ms.labels] = ms.value.Load()
+				continue
+			}
+
+			var cumulative uint64
+			buckets := make(map[string]uint64, len(ms.hist.buckets))
+			for j, upper := range ms.hist.buckets {
+				cumulative += ms.hist.counts[j].Load()
+				buckets[formatValue(upper)] = cumulative
+			}
+
+			values[ms.labels] = map[string]any{
+				"buckets": buckets,
+				"count":   ms.hist.count.Load(),
+				"sum":     ms.hist.sum.Load(),
+			}
+		}
+
+		value[mf.name] = values
+	}
+
+	return value
+}
+
+// Publish publishes this registry as an expvar with the given name.  As with expvar.Publish,
+// this method panics if the name is already in use.
+func (r *MetricsRegistry) Publish(name string) {
+	expvar.Publish(name, expvar.Func(r.expvarValue))
+}
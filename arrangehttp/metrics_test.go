@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type MetricsSuite struct {
+	suite.Suite
+}
+
+func (suite *MetricsSuite) TestMetricsConfigBuckets() {
+	suite.Equal(DefaultBuckets, MetricsConfig{}.buckets())
+	suite.Equal([]float64{1, 2}, MetricsConfig{Buckets: []float64{1, 2}}.buckets())
+}
+
+func (suite *MetricsSuite) TestCounterAndGauge() {
+	r := NewMetricsRegistry()
+	r.Counter("requests_total", "Total requests", Labels{"code": "200"}).Add(1)
+	r.Counter("requests_total", "Total requests", Labels{"code": "200"}).Add(2)
+	r.Counter("requests_total", "Total requests", Labels{"code": "500"}).Add(1)
+
+	g := r.Gauge("in_flight", "", nil)
+	g.Add(3)
+	g.Add(-1)
+	r.Gauge("temperature", "A \"quoted\"\nvalue", Labels{"where": "a\\b"}).Set(1.5)
+
+	var b strings.Builder
+	suite.Require().NoError(r.WriteText(&b))
+	suite.Equal(
+		`# TYPE in_flight gauge
+in_flight 2
+# HELP requests_total Total requests
+# TYPE requests_total counter
+requests_total{code="200"} 3
+requests_total{code="500"} 1
+# HELP temperature A "quoted"\nvalue
+# TYPE temperature gauge
+temperature{where="a\\b"} 1.5
+`,
+		b.String(),
+	)
+}
+
+func (suite *MetricsSuite) TestHistogram() {
+	r := NewMetricsRegistry()
+	h := r.Histogram("latency_seconds", "Latency", []float64{1, 0.5}, Labels{"a": "1", "b": "2"})
+	h.Observe(0.25)
+	h.Observe(0.5)
+	h.Observe(0.75)
+	h.Observe(5)
+
+	var b strings.Builder
+	suite.Require().NoError(r.WriteText(&b))
+	suite.Equal(
+		`# HELP latency_seconds Latency
+# TYPE latency_seconds histogram
+latency_seconds_bucket{a="1",b="2",le="0.5"} 2
+latency_seconds_bucket{a="1",b="2",le="1"} 3
+latency_seconds_bucket{a="1",b="2",le="+Inf"} 4
+latency_seconds_sum{a="1",b="2"} 6.5
+latency_seconds_count{a="1",b="2"} 4
+`,
+		b.String(),
+	)
+
+	// default buckets
+	r.Histogram("unlabeled", "", nil, nil).Observe(0.001)
+	b.Reset()
+	suite.Require().NoError(r.WriteText(&b))
+	suite.Contains(b.String(), `unlabeled_bucket{le="0.005"} 1`)
+	suite.Contains(b.String(), `unlabeled_bucket{le="+Inf"} 1`)
+}
+
+func (suite *MetricsSuite) TestKindMismatch() {
+	r := NewMetricsRegistry()
+	r.Counter("metric", "", nil)
+	suite.Panics(func() {
+		r.Gauge("metric", "", nil)
+	})
+}
+
+func (suite *MetricsSuite) TestWithLabels() {
+	r := NewMetricsRegistry()
+	suite.Same(r, WithLabels(r, nil))
+
+	lr := WithLabels(r, Labels{"server": "main"})
+	lr.Counter("c", "", Labels{"server": "overridden", "x": "1"}).Add(1)
+	lr.Gauge("g", "", nil).Set(2)
+	lr.Histogram("h", "", []float64{1}, nil).Observe(0.5)
+
+	var b strings.Builder
+	suite.Require().NoError(r.WriteText(&b))
+	suite.Contains(b.String(), `c{server="main",x="1"} 1`)
+	suite.Contains(b.String(), `g{server="main"} 2`)
+	suite.Contains(b.String(), `h_bucket{server="main",le="1"} 1`)
+}
+
+func (suite *MetricsSuite) TestServeHTTP() {
+	r := NewMetricsRegistry()
+	r.Counter("c", "", nil).Add(1)
+
+	response := httptest.NewRecorder()
+	r.ServeHTTP(response, httptest.NewRequest("GET", "/metrics", nil))
+	suite.Equal(http.StatusOK, response.Code)
+	suite.Equal(PrometheusContentType, response.Header().Get("Content-Type"))
+	suite.Equal("# TYPE c counter\nc 1\n", response.Body.String())
+}
+
+func (suite *MetricsSuite) TestPublish() {
+	r := NewMetricsRegistry()
+	r.Counter("c", "", Labels{"x": "1"}).Add(1)
+	r.Histogram("h", "", []float64{1}, nil).Observe(0.5)
+	r.Publish("arrangehttp.TestPublish")
+
+	var value map[string]map[string]any
+	suite.Require().NoError(json.Unmarshal([]byte(expvar.Get("arrangehttp.TestPublish").String()), &value))
+	suite.Equal(1.0, value["c"][`{x="1"}`])
+	suite.Equal(
+		map[string]any{
+			"buckets": map[string]any{"1": 1.0},
+			"count":   1.0,
+			"sum":     0.5,
+		},
+		value["h"][""],
+	)
+}
+
+func (suite *MetricsSuite) TestDefaultRegistry() {
+	r := DefaultRegistry()
+	suite.Require().NotNil(r)
+	suite.Same(r, DefaultRegistry())
+	suite.NotNil(expvar.Get(DefaultExpvarName))
+}
+
+func TestMetrics(t *testing.T) {
+	suite.Run(t, new(MetricsSuite))
+}
@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+
+	"github.com/xmidt-org/httpaux/observe"
+)
+
+// observedWriter is the observe.Writer used by this package's server middleware.  Unlike
+// the writers created by observe.New, an observedWriter exposes the writer it decorates
+// via Unwrap, so that http.ResponseController can reach features such as write deadlines.
+type observedWriter struct {
+	observe.Writer
+	next http.ResponseWriter
+}
+
+// newObservedWriter decorates a response writer.  If the writer is already an
+// observedWriter, it is returned as is.
+func newObservedWriter(response http.ResponseWriter) observedWriter {
+	if ow, ok := response.(observedWriter); ok {
+		return ow
+	}
+
+	return observedWriter{
+		Writer: observe.New(response),
+		next:   response,
+	}
+}
+
+// Flush passes through to the decorated writer, if it supports flushing.
+func (ow observedWriter) Flush() {
+	if f, ok := ow.Writer.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack passes through to the decorated writer, if it supports hijacking.
+func (ow observedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := ow.Writer.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	return h.Hijack()
+}
+
+// Unwrap returns the decorated writer, for use by http.ResponseController.
+func (ow observedWriter) Unwrap() http.ResponseWriter {
+	return ow.next
+}
@@ -0,0 +1,144 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// PeerIdentity is the identity parsed from a client's verified certificate.
+type PeerIdentity struct {
+	// Subject is the certificate's subject.
+	Subject pkix.Name
+
+	// DNSNames are the certificate's DNS subject alternative names.
+	DNSNames []string
+
+	// EmailAddresses are the certificate's email subject alternative names.
+	EmailAddresses []string
+
+	// IPAddresses are the certificate's IP subject alternative names.
+	IPAddresses []net.IP
+
+	// URIs are the certificate's URI subject alternative names.
+	URIs []*url.URL
+
+	// SPIFFEID is the certificate's first URI subject alternative name with the spiffe scheme,
+	// or the empty string if there isn't one.
+	SPIFFEID string
+
+	// SerialNumber is the certificate's serial number.
+	SerialNumber *big.Int
+
+	// Fingerprint is the lowercase hexadecimal SHA-256 digest of the certificate's DER encoding.
+	Fingerprint string
+
+	// Certificate is the certificate this identity was parsed from.
+	Certificate *x509.Certificate
+}
+
+// NewPeerIdentity parses the identity from a certificate.
+func NewPeerIdentity(cert *x509.Certificate) PeerIdentity {
+	fingerprint := sha256.Sum256(cert.Raw)
+	pi := PeerIdentity{
+		Subject:        cert.Subject,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		IPAddresses:    cert.IPAddresses,
+		URIs:           cert.URIs,
+		SerialNumber:   cert.SerialNumber,
+		Fingerprint:    hex.EncodeToString(fingerprint[:]),
+		Certificate:    cert,
+	}
+
+	for _, u := range cert.URIs {
+		if u.Scheme == "spiffe" {
+			pi.SPIFFEID = u.String()
+			break
+		}
+	}
+
+	return pi
+}
+
+// peerIdentityKey is the context key for peer identities.
+type peerIdentityKey struct{}
+
+// connectionStater is implemented by TLS connections, such as *tls.Conn.
+type connectionStater interface {
+	ConnectionState() tls.ConnectionState
+}
+
+// peerIdentitySource lazily parses the identity for a connection.  The TLS handshake hasn't
+// happened yet when a connection's context is created, so the identity is parsed the first
+// time it is requested, which can only happen once the handshake is complete.
+type peerIdentitySource struct {
+	conn     connectionStater
+	once     sync.Once
+	identity PeerIdentity
+	ok       bool
+}
+
+func (pis *peerIdentitySource) get() (PeerIdentity, bool) {
+	pis.once.Do(func() {
+		cs := pis.conn.ConnectionState()
+		if len(cs.VerifiedChains) > 0 && len(cs.PeerCertificates) > 0 {
+			pis.identity, pis.ok = NewPeerIdentity(cs.PeerCertificates[0]), true
+		}
+	})
+
+	return pis.identity, pis.ok
+}
+
+// WithPeerIdentity returns a context that holds the given peer identity.
+func WithPeerIdentity(ctx context.Context, pi PeerIdentity) context.Context {
+	return context.WithValue(ctx, peerIdentityKey{}, pi)
+}
+
+// GetPeerIdentity returns the peer identity held in a context, if any.  For a context created
+// by PeerIdentityConnContext, the identity is parsed once per connection, and there is only an
+// identity if the client presented a certificate that the server verified.
+func GetPeerIdentity(ctx context.Context) (PeerIdentity, bool) {
+	switch v := ctx.Value(peerIdentityKey{}).(type) {
+	case PeerIdentity:
+		return v, true
+
+	case *peerIdentitySource:
+		return v.get()
+
+	default:
+		return PeerIdentity{}, false
+	}
+}
+
+// PeerIdentityConnContext is an http.Server.ConnContext function that makes the identity of
+// each TLS connection's peer available via GetPeerIdentity.  Connections that do not use TLS
+// are left unchanged.
+//
+// Only certificates in the connection's verified chains are used, so the server's TLS
+// configuration must verify client certificates, e.g. by setting arrangetls.Config.ClientCAs.
+// Any arrangetls.PeerVerifyConfig checks have passed by the time the identity is available.
+func PeerIdentityConnContext(ctx context.Context, c net.Conn) context.Context {
+	if cs, ok := c.(connectionStater); ok {
+		ctx = context.WithValue(ctx, peerIdentityKey{}, &peerIdentitySource{conn: cs})
+	}
+
+	return ctx
+}
+
+// PeerIdentityOption returns a server option that composes PeerIdentityConnContext with any
+// existing http.Server.ConnContext.  See ConnContext.
+func PeerIdentityOption() Option[http.Server] {
+	return ConnContext(PeerIdentityConnContext)
+}
@@ -0,0 +1,144 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange/arrangetls"
+)
+
+type PeerIdentitySuite struct {
+	suite.Suite
+
+	clientCert *tls.Certificate
+	parsed     *x509.Certificate
+}
+
+func (suite *PeerIdentitySuite) SetupSuite() {
+	spiffe, err := url.Parse("spiffe://example.com/ns/default/sa/client")
+	suite.Require().NoError(err)
+
+	suite.clientCert, err = arrangetls.CreateTestCertificate(&x509.Certificate{
+		SerialNumber:          big.NewInt(1234),
+		Subject:               pkix.Name{CommonName: "client", Organization: []string{"example"}},
+		DNSNames:              []string{"client.example.com"},
+		URIs:                  []*url.URL{spiffe},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	})
+
+	suite.Require().NoError(err)
+	suite.parsed, err = x509.ParseCertificate(suite.clientCert.Certificate[0])
+	suite.Require().NoError(err)
+}
+
+// newServer starts a TLS server that requests client certificates, verifying them if verify is set.
+// The handler writes the peer identity's subject common name and a marker set by an existing ConnContext.
+func (suite *PeerIdentitySuite) newServer(verify bool) *httptest.Server {
+	type markerKey struct{}
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		marker, _ := request.Context().Value(markerKey{}).(string)
+		io.WriteString(response, marker+":")
+		if pi, ok := GetPeerIdentity(request.Context()); ok {
+			io.WriteString(response, pi.Subject.CommonName+":"+pi.SPIFFEID)
+		}
+	}))
+
+	s.Config.ConnContext = func(ctx context.Context, _ net.Conn) context.Context {
+		return context.WithValue(ctx, markerKey{}, "existing")
+	}
+
+	suite.Require().NoError(PeerIdentityOption().Apply(s.Config))
+
+	s.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	if verify {
+		s.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+		s.TLS.ClientCAs = x509.NewCertPool()
+		s.TLS.ClientCAs.AddCert(suite.parsed)
+	}
+
+	s.StartTLS()
+	suite.T().Cleanup(s.Close)
+	return s
+}
+
+func (suite *PeerIdentitySuite) get(s *httptest.Server) string {
+	client := s.Client()
+	client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{*suite.clientCert}
+
+	response, err := client.Get(s.URL)
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	suite.Require().NoError(err)
+	return string(body)
+}
+
+func (suite *PeerIdentitySuite) TestNewPeerIdentity() {
+	pi := NewPeerIdentity(suite.parsed)
+	fingerprint := sha256.Sum256(suite.parsed.Raw)
+
+	suite.Equal("client", pi.Subject.CommonName)
+	suite.Equal([]string{"example"}, pi.Subject.Organization)
+	suite.Equal([]string{"client.example.com"}, pi.DNSNames)
+	suite.Equal("spiffe://example.com/ns/default/sa/client", pi.SPIFFEID)
+	suite.Len(pi.URIs, 1)
+	suite.Equal(big.NewInt(1234), pi.SerialNumber)
+	suite.Equal(hex.EncodeToString(fingerprint[:]), pi.Fingerprint)
+	suite.Same(suite.parsed, pi.Certificate)
+
+	suite.Empty(NewPeerIdentity(&x509.Certificate{}).SPIFFEID)
+}
+
+func (suite *PeerIdentitySuite) TestContext() {
+	_, ok := GetPeerIdentity(context.Background())
+	suite.False(ok)
+
+	expected := NewPeerIdentity(suite.parsed)
+	actual, ok := GetPeerIdentity(WithPeerIdentity(context.Background(), expected))
+	suite.True(ok)
+	suite.Equal(expected, actual)
+
+	// connections without TLS are left alone
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	ctx := context.Background()
+	suite.Equal(ctx, PeerIdentityConnContext(ctx, c1))
+}
+
+func (suite *PeerIdentitySuite) TestVerified() {
+	s := suite.newServer(true)
+	suite.Equal("existing:client:spiffe://example.com/ns/default/sa/client", suite.get(s))
+}
+
+func (suite *PeerIdentitySuite) TestUnverified() {
+	s := suite.newServer(false)
+	suite.Equal("existing:", suite.get(s))
+}
+
+func TestPeerIdentity(t *testing.T) {
+	suite.Run(t, new(PeerIdentitySuite))
+}
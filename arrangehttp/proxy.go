@@ -0,0 +1,293 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/xmidt-org/arrange"
+	"github.com/xmidt-org/arrange/internal/arrangereflect"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrProxyTargetRequired indicates that a ProxyConfig has no Target.
+	ErrProxyTargetRequired = errors.New("A proxy target is required")
+)
+
+// PathRewrite replaces a prefix of the request path before a request is proxied.  Rewrites
+// apply to the escaped form of the path, so that escaped characters such as "%2F" are
+// forwarded as sent.
+type PathRewrite struct {
+	// Prefix is the escaped path prefix to replace.  A prefix matches whole path segments.
+	Prefix string `json:"prefix" yaml:"prefix"`
+
+	// Replacement is the escaped text that replaces Prefix.  If unset, Prefix is stripped.
+	Replacement string `json:"replacement" yaml:"replacement"`
+}
+
+// ProxyConfig is the unmarshaled configuration for a reverse proxy handler.
+type ProxyConfig struct {
+	// Target is the URL of the upstream server.  Its path, if any, is prepended to the
+	// path of each proxied request.  To spread requests over several upstream servers,
+	// configure ClientConfig.Balancer on the client whose transport the proxy uses.
+	Target string `json:"target" yaml:"target"`
+
+	// Rewrites are applied to the request path before the Target's path is prepended.
+	// The first rewrite whose Prefix matches is used.
+	Rewrites []PathRewrite `json:"rewrites" yaml:"rewrites"`
+
+	// Header supplies headers to set on every proxied request.
+	Header http.Header `json:"header" yaml:"header"`
+
+	// RemoveHeader are headers deleted from every proxied request.
+	RemoveHeader []string `json:"removeHeader" yaml:"removeHeader"`
+
+	// ResponseHeader supplies headers to set on every upstream response.
+	ResponseHeader http.Header `json:"responseHeader" yaml:"responseHeader"`
+
+	// RemoveResponseHeader are headers deleted from every upstream response.
+	RemoveResponseHeader []string `json:"removeResponseHeader" yaml:"removeResponseHeader"`
+
+	// PreserveHost sends the Host of the original request upstream.  By default, proxied
+	// requests use the host of the Target.
+	PreserveHost bool `json:"preserveHost" yaml:"preserveHost"`
+
+	// Host, if set, is the Host sent upstream.  This field takes precedence over PreserveHost.
+	Host string `json:"host" yaml:"host"`
+
+	// DisableXForwarded turns off the X-Forwarded-For, X-Forwarded-Host, and X-Forwarded-Proto
+	// headers.  Any such headers sent by the client are never forwarded.
+	DisableXForwarded bool `json:"disableXForwarded" yaml:"disableXForwarded"`
+
+	// FlushInterval corresponds to httputil.ReverseProxy.FlushInterval.  A negative value
+	// flushes immediately after each write to the client.
+	FlushInterval time.Duration `json:"flushInterval" yaml:"flushInterval"`
+
+	// ErrorHandler handles errors from the upstream server, including errors from the
+	// transport.  If unset, ProxyErrorHandler is used.  ProvideProxy uses a handler from
+	// NewProxyErrorHandler when a logger is available.
+	ErrorHandler func(http.ResponseWriter, *http.Request, error) `json:"-" yaml:"-"`
+}
+
+// ProxyErrorHandler is the default ProxyConfig.ErrorHandler.  It responds with a 504 when the
+// upstream server timed out, a 503 when the client has been stopped, and a 502 otherwise.
+func ProxyErrorHandler(response http.ResponseWriter, _ *http.Request, err error) {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		response.WriteHeader(http.StatusGatewayTimeout)
+
+	case errors.Is(err, ErrClientStopped):
+		response.WriteHeader(http.StatusServiceUnavailable)
+
+	default:
+		response.WriteHeader(http.StatusBadGateway)
+	}
+}
+
+// NewProxyErrorHandler returns a ProxyConfig.ErrorHandler that logs each error to the given
+// logger, along with the method and upstream URL of the proxied request, before responding
+// as ProxyErrorHandler does.  If l is nil, ProxyErrorHandler is returned.
+func NewProxyErrorHandler(l *zap.Logger) func(http.ResponseWriter, *http.Request, error) {
+	if l == nil {
+		return ProxyErrorHandler
+	}
+
+	return func(response http.ResponseWriter, request *http.Request, err error) {
+		l.Error(
+			"proxy error",
+			zap.String("method", request.Method),
+			zap.String("url", request.URL.String()),
+			zap.Error(err),
+		)
+
+		ProxyErrorHandler(response, request, err)
+	}
+}
+
+// rewritePath applies the first matching rewrite to an escaped path.
+func (pc ProxyConfig) rewritePath(path string) string {
+	for _, r := range pc.Rewrites {
+		if matchPathPrefix(r.Prefix, path) {
+			path = r.Replacement + path[len(r.Prefix):]
+			if !strings.HasPrefix(path, "/") {
+				path = "/" + path
+			}
+
+			break
+		}
+	}
+
+	return path
+}
+
+// proxyTimeout is the http.RoundTripper that applies an http.Client's Timeout to proxied
+// requests.  As with http.Client, the timeout includes reading the response body.
+type proxyTimeout struct {
+	next    http.RoundTripper
+	timeout time.Duration
+}
+
+func (pt proxyTimeout) RoundTrip(request *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(request.Context(), pt.timeout)
+	response, err := pt.next.RoundTrip(request.WithContext(ctx))
+	if err != nil || response.Body == nil {
+		cancel()
+		return response, err
+	}
+
+	response.Body = newTrackedBody(response.Body, cancel)
+	return response, nil
+}
+
+// NewProxy creates a reverse proxy that sends requests through the given client's transport.
+// Only the client's transport and Timeout are used, so a client created by ProvideClient
+// contributes its middleware, balancing, and lifecycle.  If c is nil or has no transport,
+// http.DefaultTransport is used.
+func (pc ProxyConfig) NewProxy(c *http.Client) (*httputil.ReverseProxy, error) {
+	if len(pc.Target) == 0 {
+		return nil, ErrProxyTargetRequired
+	}
+
+	target, err := url.Parse(pc.Target)
+	if err != nil {
+		return nil, err
+	} else if len(target.Scheme) == 0 || len(target.Host) == 0 {
+		return nil, fmt.Errorf("Invalid proxy target: %s", pc.Target)
+	}
+
+	for _, r := range pc.Rewrites {
+		if _, err := url.PathUnescape(r.Replacement); !strings.HasPrefix(r.Prefix, "/") || err != nil {
+			return nil, fmt.Errorf("Invalid proxy rewrite: [%s] -> [%s]", r.Prefix, r.Replacement)
+		}
+	}
+
+	var transport http.RoundTripper
+	if c != nil {
+		transport = arrangereflect.Safe[http.RoundTripper](c.Transport, http.DefaultTransport)
+		if c.Timeout > 0 {
+			transport = proxyTimeout{next: transport, timeout: c.Timeout}
+		}
+	}
+
+	rp := &httputil.ReverseProxy{
+		Transport:     arrangereflect.Safe[http.RoundTripper](transport, http.DefaultTransport),
+		FlushInterval: pc.FlushInterval,
+		ErrorHandler:  pc.ErrorHandler,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			// rewriting the escaped path keeps escapes such as %2F intact
+			escaped := pc.rewritePath(pr.In.URL.EscapedPath())
+			if path, err := url.PathUnescape(escaped); err == nil {
+				pr.Out.URL.Path = path
+				pr.Out.URL.RawPath = escaped
+			}
+
+			pr.SetURL(target)
+
+			switch {
+			case len(pc.Host) > 0:
+				pr.Out.Host = pc.Host
+
+			case pc.PreserveHost:
+				pr.Out.Host = pr.In.Host
+			}
+
+			if !pc.DisableXForwarded {
+				pr.SetXForwarded()
+			}
+
+			for _, name := range pc.RemoveHeader {
+				pr.Out.Header.Del(name)
+			}
+
+			for name, values := range pc.Header {
+				pr.Out.Header[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+			}
+		},
+	}
+
+	if rp.ErrorHandler == nil {
+		rp.ErrorHandler = ProxyErrorHandler
+	}
+
+	if len(pc.RemoveResponseHeader) > 0 || len(pc.ResponseHeader) > 0 {
+		rp.ModifyResponse = func(response *http.Response) error {
+			for _, name := range pc.RemoveResponseHeader {
+				response.Header.Del(name)
+			}
+
+			for name, values := range pc.ResponseHeader {
+				response.Header[http.CanonicalHeaderKey(name)] = append([]string(nil), values...)
+			}
+
+			return nil
+		}
+	}
+
+	return rp, nil
+}
+
+// newProxyHandler is the fx constructor for a proxy that serves as a server's handler.
+func newProxyHandler(pc ProxyConfig, c *http.Client, logger *zap.Logger) (http.Handler, error) {
+	if pc.ErrorHandler == nil {
+		pc.ErrorHandler = NewProxyErrorHandler(logger)
+	}
+
+	rp, err := pc.NewProxy(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if logger != nil {
+		rp.ErrorLog = NewErrorLog(logger)
+	}
+
+	return rp, nil
+}
+
+// ProvideProxy provides a reverse proxy as the handler for the server created by
+// ProvideServer(serverName).  The proxy is created by ProxyConfig.NewProxy:
+//
+//   - ProxyConfig is an optional dependency with the name serverName+".proxy".  Since a
+//     ProxyConfig requires a Target, the zero value results in ErrProxyTargetRequired.
+//   - *http.Client is a required dependency with the name clientName, typically created
+//     by ProvideClient(clientName).  The proxy uses this client's transport and Timeout.
+//   - *zap.Logger is an optional, unnamed dependency used for the proxy's ErrorLog.  Unless
+//     ProxyConfig.ErrorHandler is set, errors from the upstream server are also logged.
+//
+// The proxy is provided as the http.Handler named serverName+".handler", so the server
+// cannot also have routes.
+//
+// ProxyConfig.Target names a single upstream server.  To proxy to several upstream servers,
+// configure ClientConfig.Balancer for clientName with their URLs as the endpoints.  The
+// Balancer replaces the scheme and host of the Target with those of the selected endpoint.
+func ProvideProxy(serverName, clientName string) fx.Option {
+	switch {
+	case len(serverName) == 0:
+		return fx.Error(ErrServerNameRequired)
+
+	case len(clientName) == 0:
+		return fx.Error(ErrClientNameRequired)
+	}
+
+	return fx.Provide(
+		fx.Annotate(
+			newProxyHandler,
+			arrange.Tags().
+				OptionalName(serverName+".proxy").
+				Name(clientName).
+				Optional().
+				ParamTags(),
+			arrange.Tags().Name(serverName+".handler").ResultTags(),
+		),
+	)
+}
@@ -0,0 +1,397 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange"
+	"github.com/xmidt-org/arrange/arrangetest"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type ProxySuite struct {
+	suite.Suite
+}
+
+// newBackend starts an upstream server that echoes parts of each request as response headers.
+func (suite *ProxySuite) newBackend() *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Header().Set("X-Path", request.URL.Path)
+		response.Header().Set("X-Escaped-Path", request.URL.EscapedPath())
+		response.Header().Set("X-Query", request.URL.RawQuery)
+		response.Header().Set("X-Host", request.Host)
+		response.Header().Set("X-Custom", request.Header.Get("X-Custom"))
+		response.Header().Set("X-Secret", request.Header.Get("X-Secret"))
+		response.Header().Set("X-Forwarded", request.Header.Get("X-Forwarded-For"))
+		response.Header().Set("X-Powered-By", "legacy")
+		io.WriteString(response, "backend")
+	}))
+
+	suite.T().Cleanup(backend.Close)
+	return backend
+}
+
+func (suite *ProxySuite) newProxy(pc ProxyConfig) http.Handler {
+	rp, err := pc.NewProxy(nil)
+	suite.Require().NoError(err)
+	suite.Require().NotNil(rp)
+	return rp
+}
+
+func (suite *ProxySuite) serve(h http.Handler, target string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest("GET", target, nil)
+	request.Header.Set("X-Secret", "secret")
+	request.Header.Set("X-Custom", "original")
+
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+	return response
+}
+
+func (suite *ProxySuite) TestInvalid() {
+	for _, invalid := range []ProxyConfig{
+		{},
+		{Target: "/relative"},
+		{Target: "http://[::1"},
+		{Target: "http://localhost", Rewrites: []PathRewrite{{Prefix: "relative"}}},
+		{Target: "http://localhost", Rewrites: []PathRewrite{{Prefix: "/api", Replacement: "/%zz"}}},
+	} {
+		rp, err := invalid.NewProxy(nil)
+		suite.Error(err)
+		suite.Nil(rp)
+	}
+
+	_, err := ProxyConfig{}.NewProxy(nil)
+	suite.ErrorIs(err, ErrProxyTargetRequired)
+}
+
+func (suite *ProxySuite) TestDefault() {
+	var (
+		backend = suite.newBackend()
+		h       = suite.newProxy(ProxyConfig{Target: backend.URL})
+
+		response = suite.serve(h, "http://example.com/path?q=1")
+	)
+
+	suite.Equal(http.StatusOK, response.Code)
+	suite.Equal("backend", response.Body.String())
+	suite.Equal("/path", response.Header().Get("X-Path"))
+	suite.Equal("q=1", response.Header().Get("X-Query"))
+	suite.Equal(backend.Listener.Addr().String(), response.Header().Get("X-Host"))
+	suite.Equal("original", response.Header().Get("X-Custom"))
+	suite.Equal("secret", response.Header().Get("X-Secret"))
+	suite.Equal("192.0.2.1", response.Header().Get("X-Forwarded"))
+	suite.Equal("legacy", response.Header().Get("X-Powered-By"))
+}
+
+func (suite *ProxySuite) TestRewrites() {
+	var (
+		backend = suite.newBackend()
+		h       = suite.newProxy(ProxyConfig{
+			Target: backend.URL + "/legacy",
+			Rewrites: []PathRewrite{
+				{Prefix: "/api/v1", Replacement: "/v1"},
+				{Prefix: "/api"},
+			},
+		})
+	)
+
+	testData := []struct {
+		target       string
+		expectedPath string
+	}{
+		{"/api/v1/items", "/legacy/v1/items"},
+		{"/api/items", "/legacy/items"},
+		{"/api", "/legacy/"},
+		{"/apis", "/legacy/apis"},
+		{"/other", "/legacy/other"},
+	}
+
+	for _, record := range testData {
+		response := suite.serve(h, record.target)
+		suite.Equal(record.expectedPath, response.Header().Get("X-Path"), record.target)
+	}
+}
+
+func (suite *ProxySuite) TestEscapedRewrites() {
+	var (
+		backend = suite.newBackend()
+		h       = suite.newProxy(ProxyConfig{
+			Target: backend.URL + "/legacy",
+			Rewrites: []PathRewrite{
+				{Prefix: "/api/a%2Fb", Replacement: "/ab"},
+				{Prefix: "/api", Replacement: "/v%201"},
+			},
+		})
+	)
+
+	testData := []struct {
+		target              string
+		expectedEscapedPath string
+		expectedPath        string
+	}{
+		{"/api/a%2Fb/items", "/legacy/ab/items", "/legacy/ab/items"},
+		{"/api/a/b/items", "/legacy/v%201/a/b/items", "/legacy/v 1/a/b/items"},
+		{"/api/items/x%2Fy", "/legacy/v%201/items/x%2Fy", "/legacy/v 1/items/x/y"},
+		{"/other/x%2Fy", "/legacy/other/x%2Fy", "/legacy/other/x/y"},
+	}
+
+	for _, record := range testData {
+		response := suite.serve(h, record.target)
+		suite.Equal(record.expectedEscapedPath, response.Header().Get("X-Escaped-Path"), record.target)
+		suite.Equal(record.expectedPath, response.Header().Get("X-Path"), record.target)
+	}
+}
+
+func (suite *ProxySuite) TestTimeout() {
+	var (
+		release = make(chan struct{})
+		backend = httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			select {
+			case <-release:
+			case <-request.Context().Done():
+			}
+		}))
+	)
+
+	suite.T().Cleanup(backend.Close)
+	defer close(release)
+
+	rp, err := ProxyConfig{Target: backend.URL}.NewProxy(&http.Client{Timeout: 50 * time.Millisecond})
+	suite.Require().NoError(err)
+	suite.Equal(http.StatusGatewayTimeout, suite.serve(rp, "/").Code)
+
+	// a response within the timeout is unaffected
+	fast := suite.newBackend()
+	rp, err = ProxyConfig{Target: fast.URL}.NewProxy(&http.Client{Timeout: time.Second})
+	suite.Require().NoError(err)
+
+	response := suite.serve(rp, "/")
+	suite.Equal(http.StatusOK, response.Code)
+	suite.Equal("backend", response.Body.String())
+}
+
+func (suite *ProxySuite) TestHeaders() {
+	var (
+		backend = suite.newBackend()
+		h       = suite.newProxy(ProxyConfig{
+			Target:               backend.URL,
+			Header:               http.Header{"x-custom": {"proxy"}},
+			RemoveHeader:         []string{"X-Secret"},
+			ResponseHeader:       http.Header{"X-Proxy": {"true"}},
+			RemoveResponseHeader: []string{"X-Powered-By"},
+			DisableXForwarded:    true,
+		})
+
+		response = suite.serve(h, "/")
+	)
+
+	suite.Equal("proxy", response.Header().Get("X-Custom"))
+	suite.Empty(response.Header().Get("X-Secret"))
+	suite.Empty(response.Header().Get("X-Forwarded"))
+	suite.Equal("true", response.Header().Get("X-Proxy"))
+	suite.NotContains(response.Header(), "X-Powered-By")
+}
+
+func (suite *ProxySuite) TestHost() {
+	backend := suite.newBackend()
+
+	h := suite.newProxy(ProxyConfig{Target: backend.URL, PreserveHost: true})
+	suite.Equal("example.com", suite.serve(h, "http://example.com/").Header().Get("X-Host"))
+
+	h = suite.newProxy(ProxyConfig{Target: backend.URL, PreserveHost: true, Host: "legacy.example.com"})
+	suite.Equal("legacy.example.com", suite.serve(h, "http://example.com/").Header().Get("X-Host"))
+}
+
+func (suite *ProxySuite) TestFlushInterval() {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		io.WriteString(response, "first")
+		response.(http.Flusher).Flush()
+		<-release
+		io.WriteString(response, "second")
+	}))
+
+	defer backend.Close()
+	defer close(release)
+
+	proxy := httptest.NewServer(suite.newProxy(ProxyConfig{Target: backend.URL, FlushInterval: -1}))
+	defer proxy.Close()
+
+	response, err := http.Get(proxy.URL)
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+
+	// the first write reaches the client while the backend is still blocked
+	first := make([]byte, len("first"))
+	_, err = io.ReadFull(response.Body, first)
+	suite.Require().NoError(err)
+	suite.Equal("first", string(first))
+}
+
+func (suite *ProxySuite) TestErrors() {
+	backend := suite.newBackend()
+	backend.Close()
+
+	h := suite.newProxy(ProxyConfig{Target: backend.URL})
+	suite.Equal(http.StatusBadGateway, suite.serve(h, "/").Code)
+
+	var handlerErr error
+	h = suite.newProxy(ProxyConfig{
+		Target: backend.URL,
+		ErrorHandler: func(response http.ResponseWriter, _ *http.Request, err error) {
+			handlerErr = err
+			response.WriteHeader(599)
+		},
+	})
+
+	suite.Equal(599, suite.serve(h, "/").Code)
+	suite.Error(handlerErr)
+
+	for err, expected := range map[error]int{
+		context.DeadlineExceeded:                          http.StatusGatewayTimeout,
+		fmt.Errorf("wrapped: %w", ErrClientStopped):       http.StatusServiceUnavailable,
+		&url.Error{Op: "Get", Err: fmt.Errorf("refused")}: http.StatusBadGateway,
+	} {
+		response := httptest.NewRecorder()
+		ProxyErrorHandler(response, httptest.NewRequest("GET", "/", nil), err)
+		suite.Equal(expected, response.Code, err.Error())
+	}
+}
+
+func (suite *ProxySuite) TestProvide() {
+	var (
+		backend = suite.newBackend()
+		capture = make(chan net.Addr, 1)
+	)
+
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			fx.Annotated{
+				Name:   "server.config",
+				Target: ServerConfig{Address: "127.0.0.1:0"},
+			},
+			fx.Annotated{
+				Name: "server.proxy",
+				Target: ProxyConfig{
+					Target:   backend.URL,
+					Rewrites: []PathRewrite{{Prefix: "/legacy"}},
+				},
+			},
+			fx.Annotated{
+				Name:   "backend.config",
+				Target: ClientConfig{Header: http.Header{"X-Custom": {"client"}}},
+			},
+		),
+		ProvideClient("backend"),
+		ProvideProxy("server", "backend"),
+		ProvideServer("server", arrangetest.ListenCapture(capture)),
+	)
+
+	app.RequireStart()
+	serverAddr := arrangetest.ListenReceive(suite, capture, 2*time.Second)
+
+	response, err := http.Get("http://" + serverAddr.String() + "/legacy/path")
+	suite.Require().NoError(err)
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+
+	suite.Equal(http.StatusOK, response.StatusCode)
+	suite.Equal("backend", string(body))
+	suite.Equal("/path", response.Header.Get("X-Path"))
+
+	// the client's own configuration applies to proxied requests
+	suite.Equal("client", response.Header.Get("X-Custom"))
+
+	app.RequireStop()
+}
+
+func (suite *ProxySuite) TestProvideInvalid() {
+	suite.Error(arrangetest.NewErrApp(suite, ProvideProxy("", "backend")).Err())
+	suite.Error(arrangetest.NewErrApp(suite, ProvideProxy("server", "")).Err())
+
+	app := arrangetest.NewErrApp(
+		suite,
+		fx.Supply(
+			fx.Annotated{
+				Name:   "server.proxy",
+				Target: ProxyConfig{},
+			},
+		),
+		ProvideClient("backend"),
+		ProvideProxy("server", "backend"),
+		ProvideServer("server"),
+	)
+
+	suite.ErrorIs(app.Err(), ErrProxyTargetRequired)
+}
+
+func (suite *ProxySuite) TestProvideNoConfig() {
+	// the configuration is optional, but the zero value has no target
+	app := arrangetest.NewErrApp(
+		suite,
+		ProvideClient("backend"),
+		ProvideProxy("server", "backend"),
+		ProvideServer("server"),
+	)
+
+	suite.ErrorIs(app.Err(), ErrProxyTargetRequired)
+}
+
+func (suite *ProxySuite) TestProvideLogsErrors() {
+	var (
+		backend   = suite.newBackend()
+		core, obs = observer.New(zap.ErrorLevel)
+		handler   http.Handler
+	)
+
+	backend.Close()
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			zap.New(core),
+			fx.Annotated{
+				Name:   "server.proxy",
+				Target: ProxyConfig{Target: backend.URL},
+			},
+		),
+		ProvideClient("backend"),
+		ProvideProxy("server", "backend"),
+		fx.Populate(
+			fx.Annotate(
+				&handler,
+				arrange.Tags().Name("server.handler").ParamTags(),
+			),
+		),
+	)
+
+	app.RequireStart()
+	defer app.RequireStop()
+
+	suite.Equal(http.StatusBadGateway, suite.serve(handler, "http://example.com/path").Code)
+
+	entries := obs.AllUntimed()
+	suite.Require().Len(entries, 1)
+	fields := entries[0].ContextMap()
+	suite.Equal("GET", fields["method"])
+	suite.Equal(backend.URL+"/path", fields["url"], "the upstream URL should be logged")
+	suite.Contains(fields, "error")
+}
+
+func TestProxy(t *testing.T) {
+	suite.Run(t, new(ProxySuite))
+}
@@ -0,0 +1,141 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// RecoveryProblem is the RecoveryConfig.Format that responds with RFC 7807 problem details.
+	// This is the default format.
+	RecoveryProblem = "problem"
+
+	// RecoveryText is the RecoveryConfig.Format that responds with plain text.
+	RecoveryText = "text"
+
+	// ServerPanicsTotal counts the panics recovered by a server.
+	ServerPanicsTotal = "http_server_panics_total"
+)
+
+// RecoveryConfig is the unmarshaled configuration for recovering panics in handlers.
+// Without recovery, net/http closes the connection and logs the panic to the
+// server's ErrorLog.
+type RecoveryConfig struct {
+	// Enabled turns on panic recovery.
+	Enabled bool `json:"enabled" yaml:"enabled"`
+
+	// Format is the format of the response body, either RecoveryProblem or RecoveryText.
+	// If unset, RecoveryProblem is used.
+	Format string `json:"format" yaml:"format"`
+
+	// StatusCode is the response status for a recovered panic.  If unset,
+	// http.StatusInternalServerError is used.
+	StatusCode int `json:"statusCode" yaml:"statusCode"`
+
+	// ExposePanic includes the value passed to panic in the response body.  This
+	// should only be used in development, since it can leak internal details.
+	ExposePanic bool `json:"exposePanic" yaml:"exposePanic"`
+}
+
+// recoveryFactory is implemented by server factories that configure panic
+// recovery, such as ServerConfig.
+type recoveryFactory interface {
+	recoveryConfig() RecoveryConfig
+}
+
+// writeResponse writes the response for a recovered panic.
+func (rc RecoveryConfig) writeResponse(response http.ResponseWriter, request *http.Request, p any) {
+	statusCode := rc.StatusCode
+	if statusCode < 100 {
+		statusCode = http.StatusInternalServerError
+	}
+
+	var detail string
+	if rc.ExposePanic {
+		detail = fmt.Sprint(p)
+	}
+
+	response.Header().Set("X-Content-Type-Options", "nosniff")
+	if rc.Format == RecoveryText {
+		response.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		response.WriteHeader(statusCode)
+		io.WriteString(response, http.StatusText(statusCode))
+		if len(detail) > 0 {
+			io.WriteString(response, ": "+detail)
+		}
+
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/problem+json")
+	response.WriteHeader(statusCode)
+	json.NewEncoder(response).Encode(ProblemDetails{
+		Title:    http.StatusText(statusCode),
+		Status:   statusCode,
+		Detail:   detail,
+		Instance: request.URL.Path,
+	})
+}
+
+// ServerMiddleware returns a server middleware that recovers panics from handlers.  Each
+// recovered panic is logged with its stack trace, if l is not nil, and counted, if r is not nil.
+//
+// A panic with http.ErrAbortHandler is never recovered, since that is how handlers abort a
+// response.  If the handler had already begun the response when it panicked, the response
+// cannot be replaced, so the connection is aborted with http.ErrAbortHandler after logging.
+//
+// This method does not check the Enabled flag.  ProvideServer uses that flag to decide
+// whether to apply this middleware.
+func (rc RecoveryConfig) ServerMiddleware(l *zap.Logger, r Registry) func(http.Handler) http.Handler {
+	if l == nil {
+		l = zap.NewNop()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			ow := newObservedWriter(response)
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				} else if p == http.ErrAbortHandler {
+					panic(p)
+				}
+
+				if r != nil {
+					r.Counter(ServerPanicsTotal, "Panics recovered from handlers", nil).Add(1)
+				}
+
+				fields := []zap.Field{
+					zap.String("method", request.Method),
+					zap.String("url", request.URL.String()),
+					zap.String("remoteAddr", request.RemoteAddr),
+					zap.Any("panic", p),
+					zap.ByteString("stack", debug.Stack()),
+				}
+
+				if requestID, ok := GetRequestID(request.Context()); ok {
+					fields = append(fields, zap.String("requestID", requestID))
+				}
+
+				l.Error("handler panic", fields...)
+
+				if ow.StatusCode() != 0 {
+					panic(http.ErrAbortHandler)
+				}
+
+				rc.writeResponse(ow, request, p)
+			}()
+
+			next.ServeHTTP(ow, request)
+		})
+	}
+}
@@ -0,0 +1,219 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange"
+	"github.com/xmidt-org/arrange/arrangetest"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type RecoverySuite struct {
+	suite.Suite
+	logger *zap.Logger
+	logs   *observer.ObservedLogs
+}
+
+func (suite *RecoverySuite) SetupTest() {
+	var core zapcore.Core
+	core, suite.logs = observer.New(zapcore.InfoLevel)
+	suite.logger = zap.New(core)
+}
+
+func (suite *RecoverySuite) panicHandler(p any) http.Handler {
+	return http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic(p)
+	})
+}
+
+func (suite *RecoverySuite) serve(h http.Handler) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, httptest.NewRequest("GET", "/test", nil))
+	return response
+}
+
+func (suite *RecoverySuite) TestProblem() {
+	r := NewMetricsRegistry()
+	h := RecoveryConfig{}.ServerMiddleware(suite.logger, r)(suite.panicHandler("oops"))
+	response := suite.serve(h)
+
+	suite.Equal(http.StatusInternalServerError, response.Code)
+	suite.Equal("application/problem+json", response.Header().Get("Content-Type"))
+
+	var pd ProblemDetails
+	suite.Require().NoError(json.Unmarshal(response.Body.Bytes(), &pd))
+	suite.Equal(
+		ProblemDetails{
+			Title:    "Internal Server Error",
+			Status:   http.StatusInternalServerError,
+			Instance: "/test",
+		},
+		pd,
+	)
+
+	entries := suite.logs.FilterMessage("handler panic").All()
+	suite.Require().Len(entries, 1)
+	fields := entries[0].ContextMap()
+	suite.Equal("oops", fields["panic"])
+	suite.Equal("GET", fields["method"])
+	suite.Contains(fields["stack"], "recovery_test.go")
+
+	var b strings.Builder
+	suite.Require().NoError(r.WriteText(&b))
+	suite.Contains(b.String(), "http_server_panics_total 1")
+}
+
+func (suite *RecoverySuite) TestText() {
+	rc := RecoveryConfig{
+		Format:      RecoveryText,
+		StatusCode:  http.StatusServiceUnavailable,
+		ExposePanic: true,
+	}
+
+	response := suite.serve(rc.ServerMiddleware(nil, nil)(suite.panicHandler(errors.New("expected"))))
+	suite.Equal(http.StatusServiceUnavailable, response.Code)
+	suite.Equal("text/plain; charset=utf-8", response.Header().Get("Content-Type"))
+	suite.Equal("Service Unavailable: expected", response.Body.String())
+}
+
+func (suite *RecoverySuite) TestAbortHandler() {
+	h := RecoveryConfig{}.ServerMiddleware(suite.logger, nil)(suite.panicHandler(http.ErrAbortHandler))
+	suite.PanicsWithValue(http.ErrAbortHandler, func() {
+		suite.serve(h)
+	})
+
+	suite.Zero(suite.logs.Len())
+}
+
+func (suite *RecoverySuite) TestResponseStarted() {
+	h := RecoveryConfig{}.ServerMiddleware(suite.logger, nil)(
+		http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+			response.WriteHeader(http.StatusAccepted)
+			panic("oops")
+		}),
+	)
+
+	suite.PanicsWithValue(http.ErrAbortHandler, func() {
+		suite.serve(h)
+	})
+
+	suite.Equal(1, suite.logs.FilterMessage("handler panic").Len())
+}
+
+func (suite *RecoverySuite) TestNoPanic() {
+	h := RecoveryConfig{}.ServerMiddleware(nil, nil)(
+		http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+			io.WriteString(response, "ok")
+		}),
+	)
+
+	response := suite.serve(h)
+	suite.Equal(http.StatusOK, response.Code)
+	suite.Equal("ok", response.Body.String())
+}
+
+func (suite *RecoverySuite) TestProvideServer() {
+	var (
+		r      = NewMetricsRegistry()
+		server *http.Server
+	)
+
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			suite.logger,
+			fx.Annotate(r, fx.As(new(Registry))),
+			fx.Annotated{
+				Name: "server.config",
+				Target: ServerConfig{
+					Address:  ":0",
+					Recovery: RecoveryConfig{Enabled: true},
+					Metrics:  MetricsConfig{Enabled: true},
+				},
+			},
+		),
+		fx.Provide(
+			fx.Annotate(
+				func() http.Handler { return suite.panicHandler("oops") },
+				arrange.Tags().Name("server.handler").ResultTags(),
+			),
+		),
+		ProvideServer("server"),
+		fx.Populate(
+			fx.Annotate(
+				&server,
+				arrange.Tags().Name("server").ParamTags(),
+			),
+		),
+	)
+
+	app.RequireStart()
+	defer app.RequireStop()
+
+	suite.Equal(http.StatusInternalServerError, suite.serve(server.Handler).Code)
+
+	entries := suite.logs.FilterMessage("handler panic").All()
+	suite.Require().Len(entries, 1)
+	suite.Equal("server", entries[0].ContextMap()["server"])
+
+	var b strings.Builder
+	suite.Require().NoError(r.WriteText(&b))
+	suite.Contains(b.String(), `http_server_panics_total{server="server"} 1`)
+	suite.Contains(b.String(), `http_server_requests_total{class="5xx",method="GET",route="unknown",server="server"} 1`)
+}
+
+func (suite *RecoverySuite) TestProvideServerNoRegistry() {
+	var server *http.Server
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			fx.Annotated{
+				Name: "recovery.noregistry.config",
+				Target: ServerConfig{
+					Address:  ":0",
+					Recovery: RecoveryConfig{Enabled: true},
+				},
+			},
+		),
+		fx.Provide(
+			fx.Annotate(
+				func() http.Handler { return suite.panicHandler("oops") },
+				arrange.Tags().Name("recovery.noregistry.handler").ResultTags(),
+			),
+		),
+		ProvideServer("recovery.noregistry"),
+		fx.Populate(
+			fx.Annotate(
+				&server,
+				arrange.Tags().Name("recovery.noregistry").ParamTags(),
+			),
+		),
+	)
+
+	app.RequireStart()
+	defer app.RequireStop()
+
+	suite.Equal(http.StatusInternalServerError, suite.serve(server.Handler).Code)
+
+	// without an injected Registry, panics are not counted anywhere
+	var b strings.Builder
+	suite.Require().NoError(DefaultRegistry().WriteText(&b))
+	suite.NotContains(b.String(), "recovery.noregistry")
+}
+
+func TestRecovery(t *testing.T) {
+	suite.Run(t, new(RecoverySuite))
+}
@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	// ErrCrossHostRedirect is returned when RedirectConfig.SameHostOnly is set and
+	// a server redirects a request to a different host.
+	ErrCrossHostRedirect = errors.New("Redirects to a different host are not allowed")
+)
+
+// RedirectConfig is the unmarshaled configuration for how a client follows redirects.
+// The zero value of this type uses the net/http default policy.
+type RedirectConfig struct {
+	// MaxRedirects is the maximum number of redirects followed for a single request.  If unset,
+	// 10 redirects are allowed, similar to net/http.  If negative, redirects are not followed and
+	// the redirect response is returned to the caller.
+	MaxRedirects int `json:"maxRedirects" yaml:"maxRedirects"`
+
+	// SameHostOnly restricts redirects to the host of the original request.
+	SameHostOnly bool `json:"sameHostOnly" yaml:"sameHostOnly"`
+
+	// StripHeaders are additional headers removed from a request when it is redirected to a
+	// different host.  net/http already strips sensitive headers like Authorization and Cookie
+	// when redirecting to a different domain.
+	StripHeaders []string `json:"stripHeaders" yaml:"stripHeaders"`
+}
+
+// CheckRedirect returns a closure suitable for http.Client.CheckRedirect.  If this
+// configuration is the zero value, this method returns nil.
+func (rc RedirectConfig) CheckRedirect() func(*http.Request, []*http.Request) error {
+	if rc.MaxRedirects == 0 && !rc.SameHostOnly && len(rc.StripHeaders) == 0 {
+		return nil
+	}
+
+	maxRedirects := rc.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = 10
+	}
+
+	stripHeaders := make([]string, 0, len(rc.StripHeaders))
+	for _, h := range rc.StripHeaders {
+		stripHeaders = append(stripHeaders, http.CanonicalHeaderKey(h))
+	}
+
+	return func(request *http.Request, via []*http.Request) error {
+		if maxRedirects < 0 {
+			return http.ErrUseLastResponse
+		} else if len(via) > maxRedirects {
+			return fmt.Errorf("Stopped after %d redirects", maxRedirects)
+		}
+
+		if request.URL.Host != via[0].URL.Host {
+			if rc.SameHostOnly {
+				return ErrCrossHostRedirect
+			}
+
+			for _, h := range stripHeaders {
+				request.Header.Del(h)
+			}
+		}
+
+		return nil
+	}
+}
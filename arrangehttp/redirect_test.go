@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RedirectSuite struct {
+	suite.Suite
+
+	// target is the server that is redirected to
+	target        *httptest.Server
+	targetHeaders chan http.Header
+
+	// origin is the server that issues redirects, either to itself or to target
+	origin *httptest.Server
+}
+
+func (suite *RedirectSuite) SetupTest() {
+	suite.targetHeaders = make(chan http.Header, 10)
+	suite.target = httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		suite.targetHeaders <- request.Header
+		response.WriteHeader(299)
+	}))
+
+	suite.origin = httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		// /hops/N redirects N more times on this host, then to the target
+		hops, _ := strconv.Atoi(request.URL.Query().Get("hops"))
+		switch {
+		case hops > 0:
+			http.Redirect(response, request, "/?hops="+strconv.Itoa(hops-1), http.StatusFound)
+
+		case request.URL.Query().Has("hops"):
+			response.WriteHeader(298)
+
+		default:
+			http.Redirect(response, request, suite.target.URL, http.StatusFound)
+		}
+	}))
+}
+
+func (suite *RedirectSuite) TearDownTest() {
+	suite.origin.Close()
+	suite.target.Close()
+}
+
+func (suite *RedirectSuite) newClient(rc RedirectConfig) *http.Client {
+	return &http.Client{
+		CheckRedirect: rc.CheckRedirect(),
+	}
+}
+
+func (suite *RedirectSuite) get(c *http.Client, url string, header http.Header) (*http.Response, error) {
+	request, err := http.NewRequest("GET", url, nil)
+	suite.Require().NoError(err)
+	for name, values := range header {
+		request.Header[name] = values
+	}
+
+	response, err := c.Do(request)
+	if response != nil {
+		response.Body.Close()
+	}
+
+	return response, err
+}
+
+func (suite *RedirectSuite) TestZeroValue() {
+	suite.Nil(RedirectConfig{}.CheckRedirect())
+}
+
+func (suite *RedirectSuite) TestMaxRedirects() {
+	c := suite.newClient(RedirectConfig{MaxRedirects: 3})
+
+	response, err := suite.get(c, suite.origin.URL+"/?hops=3", nil)
+	suite.Require().NoError(err)
+	suite.Equal(298, response.StatusCode)
+
+	_, err = suite.get(c, suite.origin.URL+"/?hops=4", nil)
+	suite.Error(err)
+}
+
+func (suite *RedirectSuite) TestNoRedirects() {
+	c := suite.newClient(RedirectConfig{MaxRedirects: -1})
+
+	response, err := suite.get(c, suite.origin.URL+"/?hops=1", nil)
+	suite.Require().NoError(err)
+	suite.Equal(http.StatusFound, response.StatusCode)
+}
+
+func (suite *RedirectSuite) TestSameHostOnly() {
+	c := suite.newClient(RedirectConfig{SameHostOnly: true})
+
+	response, err := suite.get(c, suite.origin.URL+"/?hops=2", nil)
+	suite.Require().NoError(err)
+	suite.Equal(298, response.StatusCode)
+
+	_, err = suite.get(c, suite.origin.URL, nil)
+	suite.ErrorIs(err, ErrCrossHostRedirect)
+	suite.Empty(suite.targetHeaders)
+}
+
+func (suite *RedirectSuite) TestStripHeaders() {
+	c := suite.newClient(RedirectConfig{StripHeaders: []string{"x-secret"}})
+
+	response, err := suite.get(c, suite.origin.URL, http.Header{
+		"X-Secret": {"shh"},
+		"X-Public": {"hello"},
+	})
+
+	suite.Require().NoError(err)
+	suite.Equal(299, response.StatusCode)
+
+	header := <-suite.targetHeaders
+	suite.Empty(header.Get("X-Secret"))
+	suite.Equal("hello", header.Get("X-Public"))
+}
+
+func TestRedirect(t *testing.T) {
+	suite.Run(t, new(RedirectSuite))
+}
@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/xmidt-org/arrange/internal/arrangereflect"
+	"github.com/xmidt-org/httpaux/roundtrip"
+)
+
+const (
+	// MaxRequestIDLength is the maximum length of a request identifier accepted from a client.
+	// Longer identifiers are replaced with a generated one.
+	MaxRequestIDLength = 128
+)
+
+// requestIDKey is the context key for request identifiers.
+type requestIDKey struct{}
+
+// WithRequestID returns a context that holds the given request identifier.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// GetRequestID returns the request identifier held in a context, if any.
+func GetRequestID(ctx context.Context) (requestID string, ok bool) {
+	requestID, ok = ctx.Value(requestIDKey{}).(string)
+	return
+}
+
+// RequestIDGenerator is a strategy for producing request identifiers.
+type RequestIDGenerator func() string
+
+// DefaultRequestIDGenerator produces a random 128-bit identifier encoded as hexadecimal.
+func DefaultRequestIDGenerator() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// validRequestID tests if a client-supplied request identifier is safe to propagate
+// and log.  Only printable ASCII without spaces is accepted.
+func validRequestID(requestID string) bool {
+	if len(requestID) == 0 || len(requestID) > MaxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] <= ' ' || requestID[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RequestIDConfig is the unmarshaled configuration for server request identifiers.
+type RequestIDConfig struct {
+	// Enabled turns on request identifiers.
+	Enabled bool `json:"enabled" yaml:"enabled"`
+
+	// Header is the header holding the request identifier.  If unset,
+	// DefaultRequestIDHeader is used.
+	Header string `json:"header" yaml:"header"`
+
+	// Generator produces request identifiers when a request doesn't have a valid one.
+	// If unset, DefaultRequestIDGenerator is used.  This field can only be set in code.
+	Generator RequestIDGenerator `json:"-" yaml:"-"`
+}
+
+// requestIDFactory is implemented by server factories that configure request
+// identifiers, such as ServerConfig.
+type requestIDFactory interface {
+	requestIDConfig() RequestIDConfig
+}
+
+// requestIDHeaderFactory is implemented by client factories that configure the
+// request identifier header, such as ClientConfig.
+type requestIDHeaderFactory interface {
+	requestIDHeader() string
+}
+
+// header returns the configured header name.
+func (ric RequestIDConfig) header() string {
+	if len(ric.Header) > 0 {
+		return http.CanonicalHeaderKey(ric.Header)
+	}
+
+	return DefaultRequestIDHeader
+}
+
+// ServerMiddleware returns a server middleware that ensures each request has an identifier.
+// The identifier is read from the request header or, if missing or invalid, generated.  It is
+// stored in the request's context, where GetRequestID can access it, set on the request header
+// for downstream code, and echoed on the response.
+//
+// This method does not check the Enabled flag.  ProvideServer uses that flag to decide
+// whether to apply this middleware.
+func (ric RequestIDConfig) ServerMiddleware() func(http.Handler) http.Handler {
+	var (
+		header    = ric.header()
+		generator = arrangereflect.Safe(ric.Generator, DefaultRequestIDGenerator)
+	)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			requestID := request.Header.Get(header)
+			if !validRequestID(requestID) {
+				requestID = generator()
+				request.Header.Set(header, requestID)
+			}
+
+			response.Header().Set(header, requestID)
+			request = request.WithContext(WithRequestID(request.Context(), requestID))
+			next.ServeHTTP(response, request)
+			recordRoute(request)
+		})
+	}
+}
+
+// requestIDTransport is the http.RoundTripper that propagates request identifiers.
+type requestIDTransport struct {
+	next   http.RoundTripper
+	header string
+}
+
+func (rit *requestIDTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	requestID, ok := GetRequestID(request.Context())
+	if ok && len(request.Header.Get(rit.header)) == 0 {
+		// RoundTrippers must not modify the original request
+		request = request.Clone(request.Context())
+		request.Header.Set(rit.header, requestID)
+	}
+
+	return rit.next.RoundTrip(request)
+}
+
+// CloseIdleConnections delegates to the decorated transport, if supported.
+func (rit *requestIDTransport) CloseIdleConnections() {
+	roundtrip.CloseIdleConnections(rit.next)
+}
+
+// RequestIDClientMiddleware returns a client middleware that copies the request identifier
+// from each outgoing request's context, if present, onto the given header.  An explicitly
+// set header is left as is.  If header is empty, DefaultRequestIDHeader is used.
+//
+// ProvideClient applies this middleware to every client, using ClientConfig.RequestIDHeader.
+func RequestIDClientMiddleware(header string) func(http.RoundTripper) http.RoundTripper {
+	header = RequestIDConfig{Header: header}.header()
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &requestIDTransport{
+			next:   arrangereflect.Safe(next, http.DefaultTransport),
+			header: header,
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange"
+	"github.com/xmidt-org/arrange/arrangetest"
+	"go.uber.org/fx"
+)
+
+type RequestIDSuite struct {
+	suite.Suite
+}
+
+// serve runs a request through the server middleware, returning the response
+// and the request identifier seen by the handler.
+func (suite *RequestIDSuite) serve(ric RequestIDConfig, header http.Header) (response *httptest.ResponseRecorder, seen string) {
+	h := ric.ServerMiddleware()(
+		http.HandlerFunc(func(_ http.ResponseWriter, request *http.Request) {
+			var ok bool
+			seen, ok = GetRequestID(request.Context())
+			suite.True(ok)
+			suite.Equal(seen, request.Header.Get(ric.header()))
+		}),
+	)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	for name, values := range header {
+		request.Header[name] = values
+	}
+
+	response = httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+	return
+}
+
+func (suite *RequestIDSuite) TestDefaultRequestIDGenerator() {
+	first, second := DefaultRequestIDGenerator(), DefaultRequestIDGenerator()
+	suite.Len(first, 32)
+	suite.NotEqual(first, second)
+	suite.True(validRequestID(first))
+}
+
+func (suite *RequestIDSuite) TestGetRequestID() {
+	_, ok := GetRequestID(context.Background())
+	suite.False(ok)
+
+	requestID, ok := GetRequestID(WithRequestID(context.Background(), "test"))
+	suite.True(ok)
+	suite.Equal("test", requestID)
+}
+
+func (suite *RequestIDSuite) TestServerMiddlewareGenerate() {
+	response, seen := suite.serve(RequestIDConfig{}, nil)
+	suite.Len(seen, 32)
+	suite.Equal(seen, response.Header().Get(DefaultRequestIDHeader))
+}
+
+func (suite *RequestIDSuite) TestServerMiddlewareExisting() {
+	response, seen := suite.serve(RequestIDConfig{}, http.Header{DefaultRequestIDHeader: {"abc-123"}})
+	suite.Equal("abc-123", seen)
+	suite.Equal("abc-123", response.Header().Get(DefaultRequestIDHeader))
+}
+
+func (suite *RequestIDSuite) TestServerMiddlewareInvalid() {
+	for _, invalid := range []string{"has space", "bad\x7f", strings.Repeat("x", MaxRequestIDLength+1)} {
+		_, seen := suite.serve(RequestIDConfig{}, http.Header{DefaultRequestIDHeader: {invalid}})
+		suite.NotEqual(invalid, seen)
+		suite.Len(seen, 32)
+	}
+}
+
+func (suite *RequestIDSuite) TestServerMiddlewareCustom() {
+	ric := RequestIDConfig{
+		Header: "x-correlation-id",
+		Generator: func() string {
+			return "generated"
+		},
+	}
+
+	response, seen := suite.serve(ric, nil)
+	suite.Equal("generated", seen)
+	suite.Equal("generated", response.Header().Get("X-Correlation-Id"))
+}
+
+func (suite *RequestIDSuite) TestClientMiddleware() {
+	var seen []string
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, request *http.Request) {
+		seen = append(seen, request.Header.Get("X-Correlation-Id"))
+	}))
+
+	defer server.Close()
+	c := &http.Client{
+		Transport: RequestIDClientMiddleware("X-Correlation-Id")(nil),
+	}
+
+	send := func(ctx context.Context, explicit string) {
+		request, err := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+		suite.Require().NoError(err)
+		if len(explicit) > 0 {
+			request.Header.Set("X-Correlation-Id", explicit)
+		}
+
+		response, err := c.Do(request)
+		suite.Require().NoError(err)
+		response.Body.Close()
+		// the original request must not be modified
+		suite.Equal(explicit, request.Header.Get("X-Correlation-Id"))
+	}
+
+	ctx := WithRequestID(context.Background(), "propagated")
+	send(context.Background(), "")
+	send(ctx, "")
+	send(ctx, "explicit")
+	suite.Equal([]string{"", "propagated", "explicit"}, seen)
+}
+
+func (suite *RequestIDSuite) TestProvide() {
+	upstream := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		io.WriteString(response, request.Header.Get(DefaultRequestIDHeader))
+	}))
+
+	defer upstream.Close()
+
+	var server *http.Server
+
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			fx.Annotated{
+				Name: "server.config",
+				Target: ServerConfig{
+					Address:   ":0",
+					RequestID: RequestIDConfig{Enabled: true},
+				},
+			},
+		),
+		fx.Provide(
+			fx.Annotate(
+				func(c *http.Client) http.Handler {
+					return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+						upstreamRequest, _ := http.NewRequestWithContext(request.Context(), "GET", upstream.URL, nil)
+						upstreamResponse, err := c.Do(upstreamRequest)
+						suite.Require().NoError(err)
+						defer upstreamResponse.Body.Close()
+						io.Copy(response, upstreamResponse.Body)
+					})
+				},
+				arrange.Tags().Name("client").ParamTags(),
+				arrange.Tags().Name("server.handler").ResultTags(),
+			),
+		),
+		ProvideServer("server"),
+		ProvideClient("client"),
+		fx.Populate(
+			fx.Annotate(
+				&server,
+				arrange.Tags().Name("server").ParamTags(),
+			),
+		),
+	)
+
+	app.RequireStart()
+	defer app.RequireStop()
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set(DefaultRequestIDHeader, "end-to-end")
+	response := httptest.NewRecorder()
+	server.Handler.ServeHTTP(response, request)
+	suite.Equal("end-to-end", response.Header().Get(DefaultRequestIDHeader))
+	suite.Equal("end-to-end", response.Body.String())
+}
+
+func TestRequestID(t *testing.T) {
+	suite.Run(t, new(RequestIDSuite))
+}
@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/xmidt-org/httpaux/roundtrip"
+)
+
+// ResponseTooLargeError indicates that a response body exceeded the configured maximum size.
+type ResponseTooLargeError struct {
+	// MaxSize is the maximum size of a response body.
+	MaxSize int64
+}
+
+// Error satisfies the error interface.
+func (rtle *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("The response body exceeds the maximum size of %d bytes", rtle.MaxSize)
+}
+
+// limitedBody is a response body that returns an error once more than
+// a maximum number of bytes are read.
+type limitedBody struct {
+	io.ReadCloser
+	maxSize   int64
+	remaining int64
+}
+
+func (lb *limitedBody) Read(p []byte) (n int, err error) {
+	if lb.remaining < 0 {
+		return 0, &ResponseTooLargeError{MaxSize: lb.maxSize}
+	}
+
+	// allow reading 1 byte past the limit, so that we can detect a body
+	// that is exactly the maximum size
+	if int64(len(p)) > lb.remaining+1 {
+		p = p[:lb.remaining+1]
+	}
+
+	n, err = lb.ReadCloser.Read(p)
+	lb.remaining -= int64(n)
+	if lb.remaining < 0 {
+		n += int(lb.remaining)
+		err = &ResponseTooLargeError{MaxSize: lb.maxSize}
+	}
+
+	return
+}
+
+// MaxResponseBodySize returns client middleware that limits the size of response bodies.
+// A response with a Content-Length larger than maxSize results in an error from the transport.
+// Otherwise, reading more than maxSize bytes from a response body returns an error.  The body
+// of a 101 Switching Protocols response is a connection rather than a message, so it is not
+// limited.
+//
+// If maxSize is not positive, the returned middleware does no limiting.
+func MaxResponseBodySize(maxSize int64) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		if maxSize <= 0 {
+			return next
+		}
+
+		return roundtrip.PreserveCloseIdler(
+			next,
+			roundtrip.Func(func(request *http.Request) (*http.Response, error) {
+				response, err := next.RoundTrip(request)
+				if err != nil || response.Body == nil || response.StatusCode == http.StatusSwitchingProtocols {
+					return response, err
+				}
+
+				if response.ContentLength > maxSize {
+					response.Body.Close()
+					return nil, &ResponseTooLargeError{MaxSize: maxSize}
+				}
+
+				response.Body = &limitedBody{
+					ReadCloser: response.Body,
+					maxSize:    maxSize,
+					remaining:  maxSize,
+				}
+
+				return response, nil
+			}),
+		)
+	}
+}
@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/httpaux/roundtrip"
+)
+
+type ResponseLimitSuite struct {
+	suite.Suite
+	server *httptest.Server
+}
+
+func (suite *ResponseLimitSuite) SetupSuite() {
+	suite.server = httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		body := strings.Repeat("x", 100)
+		if request.URL.Query().Has("chunked") {
+			// flushing before writing the body forces chunked encoding, so no Content-Length
+			response.(http.Flusher).Flush()
+		} else {
+			response.Header().Set("Content-Length", "100")
+		}
+
+		io.WriteString(response, body)
+	}))
+}
+
+func (suite *ResponseLimitSuite) TearDownSuite() {
+	suite.server.Close()
+}
+
+func (suite *ResponseLimitSuite) newClient(maxSize int64) *http.Client {
+	return &http.Client{
+		Transport: MaxResponseBodySize(maxSize)(http.DefaultTransport),
+	}
+}
+
+func (suite *ResponseLimitSuite) read(c *http.Client, query string) ([]byte, error) {
+	response, err := c.Get(suite.server.URL + query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+	return io.ReadAll(response.Body)
+}
+
+func (suite *ResponseLimitSuite) TestUnlimited() {
+	suite.Same(http.DefaultTransport, MaxResponseBodySize(0)(http.DefaultTransport))
+}
+
+func (suite *ResponseLimitSuite) TestWithinLimit() {
+	for _, query := range []string{"", "?chunked"} {
+		body, err := suite.read(suite.newClient(100), query)
+		suite.NoError(err)
+		suite.Len(body, 100)
+	}
+}
+
+func (suite *ResponseLimitSuite) TestContentLengthExceeded() {
+	_, err := suite.read(suite.newClient(99), "")
+
+	var rtle *ResponseTooLargeError
+	suite.Require().ErrorAs(err, &rtle)
+	suite.Equal(int64(99), rtle.MaxSize)
+	suite.Contains(rtle.Error(), "99")
+}
+
+func (suite *ResponseLimitSuite) TestBodyExceeded() {
+	body, err := suite.read(suite.newClient(99), "?chunked")
+
+	var rtle *ResponseTooLargeError
+	suite.Require().ErrorAs(err, &rtle)
+	suite.Len(body, 99)
+}
+
+func (suite *ResponseLimitSuite) TestUpgrade() {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	transport := MaxResponseBodySize(1)(
+		roundtrip.Func(func(*http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusSwitchingProtocols, Body: conn}, nil
+		}),
+	)
+
+	response, err := transport.RoundTrip(httptest.NewRequest("GET", "http://localhost/", nil))
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+
+	rwc, ok := response.Body.(io.ReadWriteCloser)
+	suite.Require().True(ok, "the body of an upgraded response must be writable")
+
+	go io.WriteString(peer, "more than the limit")
+	echo := make([]byte, len("more than the limit"))
+	_, err = io.ReadFull(rwc, echo)
+	suite.NoError(err)
+}
+
+func TestResponseLimit(t *testing.T) {
+	suite.Run(t, new(ResponseLimitSuite))
+}
@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"go.uber.org/multierr"
+)
+
+var (
+	// ErrHandlerAndRoutes indicates that a server was given both an explicit handler and routes.
+	ErrHandlerAndRoutes = errors.New("A server cannot have both a handler and routes")
+)
+
+// routeKey is the context key for a request's *matchedRoute.
+type routeKey struct{}
+
+// matchedRoute holds the http.ServeMux pattern that matched a request.  A ServeMux records the
+// pattern only on the *http.Request it is given, so enclosing middleware cannot see it whenever
+// the request is replaced along the way, e.g. via WithContext.  The routes of NewRouteMux, along
+// with the middleware in this package that replaces requests, copy the pattern into the
+// matchedRoute held in the request's context.
+type matchedRoute struct {
+	pattern atomic.Pointer[string]
+}
+
+// withMatchedRoute ensures that a request's context holds a matchedRoute.
+func withMatchedRoute(request *http.Request) (*http.Request, *matchedRoute) {
+	if mr, ok := request.Context().Value(routeKey{}).(*matchedRoute); ok {
+		return request, mr
+	}
+
+	mr := new(matchedRoute)
+	return request.WithContext(context.WithValue(request.Context(), routeKey{}, mr)), mr
+}
+
+// recordRoute copies the pattern that matched a request, if any, into the
+// matchedRoute held in the request's context.
+func recordRoute(request *http.Request) {
+	if mr, ok := request.Context().Value(routeKey{}).(*matchedRoute); ok && len(request.Pattern) > 0 {
+		pattern := request.Pattern
+		mr.pattern.Store(&pattern)
+	}
+}
+
+// routePattern returns the pattern that matched a request that was handled with the
+// given matchedRoute.  If no pattern matched, this function returns the empty string.
+func routePattern(request *http.Request, mr *matchedRoute) string {
+	if len(request.Pattern) > 0 {
+		return request.Pattern
+	}
+
+	if p := mr.pattern.Load(); p != nil {
+		return *p
+	}
+
+	return ""
+}
+
+// Route is a handler registered with a pattern.  Modules can contribute Route components to
+// the serverName+".routes" value group, and ProvideServer assembles them into an http.ServeMux.
+type Route struct {
+	// Pattern is the http.ServeMux pattern for this route, optionally including
+	// a method, e.g. "GET /items/{id}".
+	Pattern string
+
+	// Handler is the handler for this route.  This field is required.
+	Handler http.Handler
+
+	// Middleware decorates Handler.  Middleware executes in the order declared.
+	Middleware []func(http.Handler) http.Handler
+}
+
+// handle registers this route with a mux, returning an error instead of panicking
+// if the pattern is invalid or conflicts with another route.
+func (r Route) handle(mux *http.ServeMux) (err error) {
+	if r.Handler == nil {
+		return fmt.Errorf("Route [%s] has no handler", r.Pattern)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("Route [%s] is invalid: %v", r.Pattern, p)
+		}
+	}()
+
+	h := ApplyMiddleware(r.Handler, r.Middleware...)
+	mux.Handle(r.Pattern, http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		recordRoute(request)
+		h.ServeHTTP(response, request)
+	}))
+
+	return
+}
+
+// NewRouteMux creates an http.ServeMux from the given routes.  Every route is registered, and
+// the returned error reports each route with an invalid, duplicate, or conflicting pattern.
+//
+// The pattern that matched each request is reported to request metrics and traces even when
+// middleware between those and the returned ServeMux replaces the *http.Request.
+func NewRouteMux(routes ...Route) (mux *http.ServeMux, err error) {
+	mux = http.NewServeMux()
+	for _, r := range routes {
+		err = multierr.Append(err, r.handle(mux))
+	}
+
+	if err != nil {
+		mux = nil
+	}
+
+	return
+}
@@ -0,0 +1,177 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange"
+	"github.com/xmidt-org/arrange/arrangetest"
+	"go.uber.org/fx"
+	"go.uber.org/multierr"
+)
+
+type RouteSuite struct {
+	suite.Suite
+}
+
+// body returns a handler that writes a fixed body.
+func (suite *RouteSuite) body(v string) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+		io.WriteString(response, v)
+	})
+}
+
+func (suite *RouteSuite) get(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, httptest.NewRequest(method, target, nil))
+	return response
+}
+
+func (suite *RouteSuite) TestNewRouteMux() {
+	mux, err := NewRouteMux(
+		Route{Pattern: "GET /items/{id}", Handler: suite.body("get")},
+		Route{Pattern: "PUT /items/{id}", Handler: suite.body("put")},
+		Route{
+			Pattern: "/decorated",
+			Handler: suite.body("decorated"),
+			Middleware: []func(http.Handler) http.Handler{
+				func(next http.Handler) http.Handler {
+					return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+						response.Header().Set("X-First", "true")
+						next.ServeHTTP(response, request)
+					})
+				},
+				func(next http.Handler) http.Handler {
+					return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+						suite.Equal("true", response.Header().Get("X-First"))
+						response.Header().Set("X-Second", "true")
+						next.ServeHTTP(response, request)
+					})
+				},
+			},
+		},
+	)
+
+	suite.Require().NoError(err)
+	suite.Require().NotNil(mux)
+
+	suite.Equal("get", suite.get(mux, "GET", "/items/1").Body.String())
+	suite.Equal("put", suite.get(mux, "PUT", "/items/1").Body.String())
+	suite.Equal(http.StatusMethodNotAllowed, suite.get(mux, "DELETE", "/items/1").Code)
+
+	response := suite.get(mux, "GET", "/decorated")
+	suite.Equal("decorated", response.Body.String())
+	suite.Equal("true", response.Header().Get("X-Second"))
+}
+
+func (suite *RouteSuite) TestNewRouteMuxInvalid() {
+	mux, err := NewRouteMux(
+		Route{Pattern: "GET /items/{id}", Handler: suite.body("")},
+		Route{Pattern: "GET /items/{id}", Handler: suite.body("")},
+		Route{Pattern: "GET /items/{name}", Handler: suite.body("")},
+		Route{Pattern: "/nohandler"},
+		Route{Pattern: "GET /bad/{", Handler: suite.body("")},
+		Route{Pattern: "/ok", Handler: suite.body("")},
+	)
+
+	suite.Nil(mux)
+	suite.Require().Error(err)
+
+	// every bad route is reported
+	errs := multierr.Errors(err)
+	suite.Len(errs, 4)
+	suite.Contains(errs[0].Error(), "GET /items/{id}")
+	suite.Contains(errs[1].Error(), "GET /items/{name}")
+	suite.Contains(errs[2].Error(), "/nohandler")
+	suite.Contains(errs[3].Error(), "GET /bad/{")
+}
+
+func (suite *RouteSuite) provideRoute(group string, r Route) fx.Option {
+	return fx.Provide(
+		fx.Annotate(
+			func() Route { return r },
+			arrange.Tags().Group(group).ResultTags(),
+		),
+	)
+}
+
+func (suite *RouteSuite) TestProvide() {
+	var server *http.Server
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			fx.Annotated{
+				Name:   "server.config",
+				Target: ServerConfig{Address: ":0"},
+			},
+		),
+		suite.provideRoute("server.routes", Route{Pattern: "GET /first", Handler: suite.body("first")}),
+		suite.provideRoute("server.routes", Route{Pattern: "GET /second", Handler: suite.body("second")}),
+		suite.provideRoute("other.routes", Route{Pattern: "GET /first", Handler: suite.body("other")}),
+		ProvideServer("server"),
+		fx.Populate(
+			fx.Annotate(
+				&server,
+				arrange.Tags().Name("server").ParamTags(),
+			),
+		),
+	)
+
+	app.RequireStart()
+	defer app.RequireStop()
+
+	suite.Equal("first", suite.get(server.Handler, "GET", "/first").Body.String())
+	suite.Equal("second", suite.get(server.Handler, "GET", "/second").Body.String())
+	suite.Equal(http.StatusNotFound, suite.get(server.Handler, "GET", "/third").Code)
+}
+
+func (suite *RouteSuite) TestProvideConflict() {
+	app := arrangetest.NewErrApp(
+		suite,
+		suite.provideRoute("server.routes", Route{Pattern: "/items/{id}", Handler: suite.body("")}),
+		suite.provideRoute("server.routes", Route{Pattern: "/items/{name}", Handler: suite.body("")}),
+		ProvideServer("server"),
+		fx.Invoke(
+			fx.Annotate(
+				func(*http.Server) {},
+				arrange.Tags().Name("server").ParamTags(),
+			),
+		),
+	)
+
+	suite.Require().Error(app.Err())
+	suite.Contains(app.Err().Error(), "Invalid routes for server server")
+	suite.Contains(app.Err().Error(), "/items/{name}")
+}
+
+func (suite *RouteSuite) TestProvideWithHandler() {
+	app := arrangetest.NewErrApp(
+		suite,
+		suite.provideRoute("server.routes", Route{Pattern: "/", Handler: suite.body("")}),
+		fx.Provide(
+			fx.Annotate(
+				func() http.Handler { return suite.body("") },
+				arrange.Tags().Name("server.handler").ResultTags(),
+			),
+		),
+		ProvideServer("server"),
+		fx.Invoke(
+			fx.Annotate(
+				func(*http.Server) {},
+				arrange.Tags().Name("server").ParamTags(),
+			),
+		),
+	)
+
+	suite.ErrorIs(app.Err(), ErrHandlerAndRoutes)
+}
+
+func TestRoute(t *testing.T) {
+	suite.Run(t, new(RouteSuite))
+}
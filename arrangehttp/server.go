@@ -14,6 +14,7 @@ import (
 	"github.com/xmidt-org/arrange/internal/arrangereflect"
 	"go.uber.org/fx"
 	"go.uber.org/multierr"
+	"go.uber.org/zap"
 )
 
 const (
@@ -102,15 +103,176 @@ func newServerProvider[H http.Handler, F ServerFactory](serverName string, exter
 }
 
 // newServer is the server constructor function.
-func (sp serverProvider[H, F]) newServer(sf F, h H, injected ...Option[http.Server]) (s *http.Server, err error) {
-	s, err = NewServerCustom[H, F](sf, h, injected...)
+func (sp serverProvider[H, F]) newServer(sf F, h H, logger *zap.Logger, r Registry, e SpanExporter, m *Maintenance, routes []Route, injected ...Option[http.Server]) (s *http.Server, err error) {
+	var handler http.Handler
+	if len(routes) > 0 {
+		if arrangereflect.Safe[http.Handler](h, nil) != nil {
+			return nil, fmt.Errorf("Server %s: %w", sp.serverName, ErrHandlerAndRoutes)
+		}
+
+		mux, muxErr := NewRouteMux(routes...)
+		if muxErr != nil {
+			return nil, fmt.Errorf("Invalid routes for server %s: %w", sp.serverName, muxErr)
+		}
+
+		handler = mux
+	} else {
+		handler = arrangereflect.Safe[http.Handler](h, http.DefaultServeMux)
+	}
+
+	// the metrics exposition handler is mounted inside the factory's own middleware, so that
+	// authentication, limits, and CORS apply to it as they do to any other endpoint
+	if mf, ok := any(sf).(metricsFactory); ok {
+		if mc := mf.metricsConfig(); mc.Enabled {
+			if r == nil {
+				r = DefaultRegistry()
+			}
+
+			if rh, ok := r.(http.Handler); ok {
+				handler = MetricsHandler(mc.Path, rh)(handler)
+			}
+		}
+	}
+
+	s, err = NewServerCustom[http.Handler, F](sf, handler, injected...)
+
 	if err == nil {
 		s, err = ApplyOptions(s, sp.options...)
 	}
 
+	if err == nil && logger != nil {
+		logger = logger.With(zap.String("server", sp.serverName))
+		if s.ErrorLog == nil {
+			s.ErrorLog = NewErrorLog(logger)
+		}
+	}
+
+	// the DefaultRegistry is only used when a feature needs a registry
+	labeled := func() Registry {
+		if r == nil {
+			r = DefaultRegistry()
+		}
+
+		return WithLabels(r, Labels{"server": sp.serverName})
+	}
+
+	// middleware is applied from the inside out, so recovery comes first in order
+	// for metrics and access logs to see the response it writes
+	if rf, ok := any(sf).(recoveryFactory); ok && err == nil {
+		if rc := rf.recoveryConfig(); rc.Enabled {
+			// panics are only counted when a Registry is injected
+			var pr Registry
+			if r != nil {
+				pr = labeled()
+			}
+
+			s, err = ApplyOptions(s, ServerMiddleware(rc.ServerMiddleware(logger, pr)))
+		}
+	}
+
+	// maintenance comes before metrics and access logs, so that they see its responses
+	if err == nil && m != nil {
+		s, err = ApplyOptions(s, ServerMiddleware(m.Middleware()))
+	}
+
+	if mf, ok := any(sf).(metricsFactory); ok && err == nil {
+		if mc := mf.metricsConfig(); mc.Enabled {
+			s, err = ApplyOptions(s, ServerMiddleware(mc.ServerMiddleware(labeled())))
+		}
+	}
+
+	if alf, ok := any(sf).(accessLogFactory); ok && err == nil && logger != nil {
+		if alc := alf.accessLogConfig(); alc.Enabled {
+			s, err = ApplyOptions(s, ServerMiddleware(alc.ServerMiddleware(logger)))
+		}
+	}
+
+	if tf, ok := any(sf).(traceFactory); ok && err == nil {
+		if tc := tf.traceConfig(); tc.Enabled {
+			s, err = ApplyOptions(s, ServerMiddleware(tc.ServerMiddleware(e)))
+		}
+	}
+
+	// request identifiers are outermost, so that all the other middleware can see them
+	if rif, ok := any(sf).(requestIDFactory); ok && err == nil {
+		if ric := rif.requestIDConfig(); ric.Enabled {
+			s, err = ApplyOptions(s, ServerMiddleware(ric.ServerMiddleware()))
+		}
+	}
+
+	if hrf, ok := any(sf).(httpsRedirectFactory); ok && err == nil {
+		if hrc := hrf.httpsRedirectConfig(); hrc.enabled() {
+			if validateErr := hrc.validate(s); validateErr != nil {
+				s, err = nil, fmt.Errorf("Server %s: %w", sp.serverName, validateErr)
+			}
+		}
+	}
+
 	return
 }
 
+// newMaintenance creates the maintenance switch for a server.  If the switch is driven by
+// a file or by signals, these are watched while the enclosing fx.App is running.  If the server factory
+// does not configure a maintenance switch, this method returns a nil *Maintenance.
+func (sp serverProvider[H, F]) newMaintenance(sf F, lc fx.Lifecycle) (*Maintenance, error) {
+	mf, ok := any(sf).(maintenanceFactory)
+	if !ok {
+		return nil, nil
+	}
+
+	mc, ok := mf.maintenanceConfig()
+	if !ok {
+		return nil, nil
+	}
+
+	m, err := NewMaintenance(mc)
+	if err != nil {
+		return nil, fmt.Errorf("Server %s: %w", sp.serverName, err)
+	}
+
+	if len(mc.File) > 0 {
+		var stop func()
+		lc.Append(fx.StartStopHook(
+			func() {
+				stop = m.WatchFile(mc.File, mc.FileInterval)
+			},
+			func() {
+				stop()
+			},
+		))
+	}
+
+	// the signals were validated by NewMaintenance
+	if signals, _ := mc.signals(); len(signals) > 0 {
+		var stop func()
+		lc.Append(fx.StartStopHook(
+			func() {
+				stop = m.NotifySignals(signals...)
+			},
+			func() {
+				stop()
+			},
+		))
+	}
+
+	return m, nil
+}
+
+// newMaintenanceCheck creates the readiness check for a server's maintenance switch, if
+// the server has one.
+func (sp serverProvider[H, F]) newMaintenanceCheck(m *Maintenance) []HealthCheck {
+	if m == nil {
+		return nil
+	}
+
+	return []HealthCheck{
+		{
+			Name:  sp.serverName + ".maintenance",
+			Check: m.Check,
+		},
+	}
+}
+
 // newListener creates a net.Listener for a given *http.Server.
 func (sp serverProvider[H, F]) newListener(ctx context.Context, sf F, s *http.Server, injected ...ListenerMiddleware) (l net.Listener, err error) {
 	l, err = NewListener(ctx, sf, s, injected...)
@@ -140,19 +302,87 @@ func (sp serverProvider[H, F]) runServer(sh fx.Shutdowner, s *http.Server, l net
 	)
 }
 
-// bindServer binds a server to the lifecycle of an enclosing fx.App.
-func (sp serverProvider[H, F]) bindServer(sf F, s *http.Server, lc fx.Lifecycle, sh fx.Shutdowner, injected ...ListenerMiddleware) {
+// runRedirectServer starts an HTTPS redirect server.  The enclosing fx.App is only shutdown if
+// the redirect server terminates abnormally, since the server it redirects to controls the
+// application's lifecycle.
+func (sp serverProvider[H, F]) runRedirectServer(sh fx.Shutdowner, rs *http.Server, rl net.Listener) {
+	go func() {
+		if err := rs.Serve(rl); !errors.Is(err, http.ErrServerClosed) {
+			sh.Shutdown(fx.ExitCode(ServerAbnormalExitCode))
+		}
+	}()
+}
+
+// newRedirectServer creates and binds the HTTPS redirect server, if one is configured, for a
+// server that is listening on l.  The redirect server listens via the server's factory, so that
+// settings such as ServerConfig.Network apply to it, but the server's listener middleware does not.
+func (sp serverProvider[H, F]) newRedirectServer(ctx context.Context, sf F, s *http.Server, l net.Listener) (rs *http.Server, rl net.Listener, err error) {
+	hrf, ok := any(sf).(httpsRedirectFactory)
+	if !ok || !hrf.httpsRedirectConfig().enabled() {
+		return
+	}
+
+	rs, err = hrf.httpsRedirectConfig().newServer(s, l.Addr())
+	if err == nil {
+		// the redirect server has no TLSConfig, so the factory creates a plain listener
+		rl, err = NewListener(ctx, sf, rs)
+	}
+
+	if err != nil {
+		rs = nil
+	}
+
+	return
+}
+
+// bindServer binds a server to the lifecycle of an enclosing fx.App.  If h is not nil,
+// the application isn't ready until this server starts and stops being ready before
+// this server shuts down.
+//
+// Any HTTPS redirect server shares this server's lifecycle.
+func (sp serverProvider[H, F]) bindServer(sf F, s *http.Server, lc fx.Lifecycle, sh fx.Shutdowner, h *Health, injected ...ListenerMiddleware) {
+	var (
+		started = func() {}
+		rs      *http.Server
+	)
+
+	if h != nil {
+		started = h.addPending()
+	}
+
 	lc.Append(fx.StartStopHook(
 		func(ctx context.Context) (err error) {
-			var l net.Listener
+			var l, rl net.Listener
 			l, err = sp.newListener(ctx, sf, s, injected...)
+			if err == nil {
+				rs, rl, err = sp.newRedirectServer(ctx, sf, s, l)
+				if err != nil {
+					l.Close()
+				}
+			}
+
 			if err == nil {
 				sp.runServer(sh, s, l)
+				if rs != nil {
+					sp.runRedirectServer(sh, rs, rl)
+				}
+
+				started()
 			}
 
 			return
 		},
-		s.Shutdown,
+		func(ctx context.Context) (err error) {
+			if h != nil {
+				h.OnStop(ctx)
+			}
+
+			if rs != nil {
+				err = rs.Shutdown(ctx)
+			}
+
+			return multierr.Append(err, s.Shutdown(ctx))
+		},
 	))
 }
 
@@ -163,9 +393,35 @@ func (sp serverProvider[H, F]) bindServer(sf F, s *http.Server, lc fx.Lifecycle,
 //   - NewServer is used to create the server as a component named serverName
 //   - ServerConfig is an optional dependency with the name serverName+".config"
 //   - http.Handler is an optional dependency with the name serverName+".handler"
+//   - []Route is a value group dependency with the name serverName+".routes".  If any routes are
+//     supplied, the server's handler is an http.ServeMux assembled from them, and startup fails if
+//     any patterns are invalid, duplicated, or in conflict.  Routes cannot be used with an
+//     explicit serverName+".handler".
 //   - []Option[http.Server] is a value group dependency with the name serverName+".options"
+//   - *zap.Logger is an optional, unnamed dependency used for access logging and, unless
+//     an option sets one, the server's ErrorLog.  Entries are tagged with the server name.
+//   - Registry is an optional, unnamed dependency used for ServerConfig.Metrics and to count
+//     panics recovered due to ServerConfig.Recovery.  If not supplied, DefaultRegistry is used
+//     for ServerConfig.Metrics and panics are not counted.  Metrics are labeled with the server name.
+//   - SpanExporter is an optional, unnamed dependency that records the spans started due to
+//     ServerConfig.Trace.  See JSONLinesExporter.
+//   - *Health is an optional, unnamed dependency.  If supplied, the application isn't ready until
+//     this server has started, and it stops being ready before this server shuts down.  See ProvideHealth.
 //   - []ListenerMiddleware is a value group dependency with the name serverName+".listener.middleware"
 //
+// A *Maintenance switch, configured by ServerConfig.Maintenance, is provided as a component named
+// serverName+".maintenance".  While the switch is on, the server answers requests with a 503.  A
+// HealthCheck named serverName+".maintenance" is also placed into the HealthChecksGroup, so that
+// the application isn't ready while this server is in maintenance.  The switch's file and signals
+// are only watched while the enclosing fx.App is running.  When the ServerFactory does not
+// configure a maintenance switch, as with AdminConfig, the component is nil and neither the
+// switch nor the HealthCheck is installed.
+//
+// If ServerConfig.HTTPSRedirect is configured, a plain HTTP server that redirects to this server
+// is started and stopped along with this server.  Its listener is created by the ServerFactory, so
+// settings such as ServerConfig.Network and ServerConfig.KeepAlive apply to it, but it is not
+// decorated with this server's listener middleware.
+//
 // The external slice contains items that come from outside the enclosing fx.App that are applied to
 // the server and listener.  Each element of external must be either an Option[http.Server] or a
 // ListenerMiddleware.  Any other type short circuits application startup with an error.
@@ -183,11 +439,29 @@ func ProvideServerCustom[H http.Handler, F ServerFactory](serverName string, ext
 
 	return fx.Options(
 		fx.Provide(
+			fx.Annotate(
+				sp.newMaintenance,
+				arrange.Tags().Push(serverName).
+					OptionalName("config").
+					Skip().
+					ParamTags(),
+				arrange.Tags().Push(serverName).Name("maintenance").ResultTags(),
+			),
+			fx.Annotate(
+				sp.newMaintenanceCheck,
+				arrange.Tags().Push(serverName).Name("maintenance").ParamTags(),
+				arrange.Tags().Group(HealthChecksGroup+",flatten").ResultTags(),
+			),
 			fx.Annotate(
 				sp.newServer,
 				arrange.Tags().Push(serverName).
 					OptionalName("config").
 					OptionalName("handler").
+					Optional().
+					Optional().
+					Optional().
+					Name("maintenance").
+					Group("routes").
 					Group("options").
 					ParamTags(),
 				arrange.Tags().Name(serverName).ResultTags(),
@@ -201,6 +475,7 @@ func ProvideServerCustom[H http.Handler, F ServerFactory](serverName string, ext
 					Push("").Name(serverName).Pop().
 					Skip().
 					Skip().
+					Optional().
 					Group("listener.middleware").
 					ParamTags(),
 			),
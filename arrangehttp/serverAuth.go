@@ -0,0 +1,388 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/xmidt-org/arrange/internal/arrangereflect"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// AuthMethodBasic is the Principal.Method for clients authenticated with basic credentials.
+	AuthMethodBasic = "basic"
+
+	// AuthMethodBearer is the Principal.Method for clients authenticated with a bearer token.
+	AuthMethodBearer = "bearer"
+
+	// AuthMethodCertificate is the Principal.Method for clients identified by their certificate.
+	AuthMethodCertificate = "certificate"
+
+	// DefaultAuthRealm is the realm in WWW-Authenticate challenges when ServerAuthConfig.Realm is unset.
+	DefaultAuthRealm = "server"
+)
+
+var (
+	// ErrNoServerAuthMethods indicates that a ServerAuthConfig has rules but no way
+	// to authenticate clients.
+	ErrNoServerAuthMethods = errors.New("Server authentication rules require at least one authentication method")
+)
+
+// Principal is the authenticated identity of a client.
+type Principal struct {
+	// Name identifies the client.
+	Name string
+
+	// Method is how the client was authenticated, e.g. AuthMethodBasic.
+	Method string
+}
+
+// principalKey is the context key for principals.
+type principalKey struct{}
+
+// WithPrincipal returns a context that holds the given principal.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// GetPrincipal returns the principal held in a context, if any.
+func GetPrincipal(ctx context.Context) (p Principal, ok bool) {
+	p, ok = ctx.Value(principalKey{}).(Principal)
+	return
+}
+
+// CertificateAuthConfig is the unmarshaled configuration for identifying clients by
+// their certificates.  Only verified client certificates are used, so the server's
+// TLS configuration must verify them, e.g. by setting arrangetls.Config.ClientCAs.
+type CertificateAuthConfig struct {
+	// Enabled turns on identification of clients by their certificates.
+	Enabled bool `json:"enabled" yaml:"enabled"`
+
+	// Principals maps certificate names to principal names.  A certificate's names are its
+	// subject common name and its DNS, email, and URI subject alternative names.  If unset,
+	// the principal is the subject common name or, if there isn't one, the first DNS name.
+	// Either way, AuthRule.Principals must qualify these names, e.g. "certificate:service".
+	Principals map[string]string `json:"principals" yaml:"principals"`
+}
+
+// principal returns the principal name for a client certificate.
+func (cac CertificateAuthConfig) principal(cert *x509.Certificate) (string, bool) {
+	if len(cac.Principals) == 0 {
+		switch {
+		case len(cert.Subject.CommonName) > 0:
+			return cert.Subject.CommonName, true
+
+		case len(cert.DNSNames) > 0:
+			return cert.DNSNames[0], true
+
+		default:
+			return "", false
+		}
+	}
+
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+
+	for _, name := range names {
+		if p, ok := cac.Principals[name]; ok && len(name) > 0 {
+			return p, true
+		}
+	}
+
+	return "", false
+}
+
+// AuthRule allows principals access to requests under a path prefix.
+type AuthRule struct {
+	// PathPrefix is the path prefix this rule applies to.  A prefix matches whole path
+	// segments.  This field is required and must begin with a '/'.
+	PathPrefix string `json:"pathPrefix" yaml:"pathPrefix"`
+
+	// Methods restricts this rule to requests with these methods.  If unset, this
+	// rule applies to all methods.
+	Methods []string `json:"methods" yaml:"methods"`
+
+	// Anonymous allows requests without credentials.  Requests with invalid
+	// credentials are still rejected.
+	Anonymous bool `json:"anonymous" yaml:"anonymous"`
+
+	// Principals are the principals allowed by this rule.  An entry of the form "method:name",
+	// e.g. "certificate:client.example.com", allows the principal with that Method and Name.  A
+	// bare name only allows basic or bearer principals, since those names are configured for this
+	// server, whereas certificate names come from any trusted certificate authority.  If unset,
+	// any authenticated principal is allowed.
+	Principals []string `json:"principals" yaml:"principals"`
+}
+
+// allows tests if this rule's Principals allow an authenticated principal.
+func (ar AuthRule) allows(p Principal) bool {
+	if len(ar.Principals) == 0 {
+		return true
+	}
+
+	qualified := p.Method + ":" + p.Name
+	for _, allowed := range ar.Principals {
+		switch {
+		case allowed == qualified:
+			return true
+
+		case allowed == p.Name && p.Method != AuthMethodCertificate:
+			return true
+		}
+	}
+
+	return false
+}
+
+// match tests if this rule applies to a request.
+func (ar AuthRule) match(request *http.Request) bool {
+	return matchPathPrefix(ar.PathPrefix, request.URL.Path) &&
+		(len(ar.Methods) == 0 || slices.Contains(ar.Methods, request.Method))
+}
+
+// ServerAuthConfig is the unmarshaled configuration for authenticating and authorizing
+// requests to a server.  Any combination of authentication methods may be configured.
+//
+// Each request is authorized by the rule with the longest PathPrefix that applies to it.
+// A request that no rule applies to must come from an authenticated principal.  Requests
+// without credentials receive a 401, and requests from principals that aren't allowed
+// receive a 403.
+type ServerAuthConfig struct {
+	// Realm is the realm sent in WWW-Authenticate challenges.  If unset, DefaultAuthRealm is used.
+	Realm string `json:"realm" yaml:"realm"`
+
+	// BasicFile is a file of basic credentials, one "username:hash" per line, where each hash
+	// is a bcrypt hash as produced by "htpasswd -B".  Blank lines and lines beginning with '#'
+	// are ignored.  The file is read once, when the server is created.
+	BasicFile string `json:"basicFile" yaml:"basicFile"`
+
+	// BearerTokens maps principal names to static bearer tokens.
+	BearerTokens map[string]string `json:"bearerTokens" yaml:"bearerTokens"`
+
+	// Certificate configures identification of clients by their certificates.
+	Certificate CertificateAuthConfig `json:"certificate" yaml:"certificate"`
+
+	// Rules are the per-route rules for which principals may access which requests.
+	Rules []AuthRule `json:"rules" yaml:"rules"`
+}
+
+// enabled tests if any authentication method is configured.
+func (sac ServerAuthConfig) enabled() bool {
+	return len(sac.BasicFile) > 0 || len(sac.BearerTokens) > 0 || sac.Certificate.Enabled
+}
+
+// readCredentials reads a file of basic credentials.
+func readCredentials(path string) (map[string][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	var (
+		credentials = make(map[string][]byte)
+		scanner     = bufio.NewScanner(f)
+		line        = 0
+	)
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || text[0] == '#' {
+			continue
+		}
+
+		username, hash, ok := strings.Cut(text, ":")
+		switch {
+		case !ok || len(username) == 0:
+			return nil, fmt.Errorf("Invalid credentials in %s, line %d", path, line)
+
+		case credentials[username] != nil:
+			return nil, fmt.Errorf("Duplicate user %s in %s, line %d", username, path, line)
+		}
+
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("Invalid hash for user %s in %s, line %d: %w", username, path, line, err)
+		}
+
+		credentials[username] = []byte(hash)
+	}
+
+	return credentials, scanner.Err()
+}
+
+// serverAuth is the compiled form of a ServerAuthConfig.
+type serverAuth struct {
+	challenges  []string
+	credentials map[string][]byte
+	tokens      map[[sha256.Size]byte]string
+	certificate CertificateAuthConfig
+	rules       []AuthRule
+}
+
+// authenticate determines the principal for a request.  This method returns false if the
+// request has invalid credentials, and a zero Principal if the request has no credentials.
+func (sa *serverAuth) authenticate(request *http.Request) (Principal, bool) {
+	if username, password, ok := request.BasicAuth(); ok && sa.credentials != nil {
+		hash, exists := sa.credentials[username]
+		if !exists || bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+			return Principal{}, false
+		}
+
+		return Principal{Name: username, Method: AuthMethodBasic}, true
+	}
+
+	if scheme, token, ok := strings.Cut(request.Header.Get("Authorization"), " "); ok && sa.tokens != nil && strings.EqualFold(scheme, "Bearer") {
+		// comparing digests avoids leaking token contents through timing
+		name, exists := sa.tokens[sha256.Sum256([]byte(strings.TrimSpace(token)))]
+		if !exists {
+			return Principal{}, false
+		}
+
+		return Principal{Name: name, Method: AuthMethodBearer}, true
+	}
+
+	if len(request.Header.Get("Authorization")) > 0 {
+		// credentials for a method that isn't configured
+		return Principal{}, false
+	}
+
+	if sa.certificate.Enabled && request.TLS != nil && len(request.TLS.VerifiedChains) > 0 {
+		if name, ok := sa.certificate.principal(request.TLS.PeerCertificates[0]); ok {
+			return Principal{Name: name, Method: AuthMethodCertificate}, true
+		}
+	}
+
+	return Principal{}, true
+}
+
+// rule returns the rule that applies to a request.  A request with no
+// rule is allowed for any authenticated principal.
+func (sa *serverAuth) rule(request *http.Request) (found AuthRule) {
+	for _, ar := range sa.rules {
+		if len(ar.PathPrefix) > len(found.PathPrefix) && ar.match(request) {
+			found = ar
+		}
+	}
+
+	return
+}
+
+// unauthorized writes a 401 with challenges for the configured methods.
+func (sa *serverAuth) unauthorized(response http.ResponseWriter) {
+	for _, c := range sa.challenges {
+		response.Header().Add("WWW-Authenticate", c)
+	}
+
+	http.Error(response, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// Middleware returns a server middleware that authenticates each request and enforces
+// the rules.  The authenticated principal is available to handlers via GetPrincipal.
+//
+// If no authentication method is configured, this method returns a nil middleware.
+func (sac ServerAuthConfig) Middleware() (func(http.Handler) http.Handler, error) {
+	if !sac.enabled() {
+		if len(sac.Rules) > 0 {
+			return nil, ErrNoServerAuthMethods
+		}
+
+		return nil, nil
+	}
+
+	for _, ar := range sac.Rules {
+		if !strings.HasPrefix(ar.PathPrefix, "/") {
+			return nil, fmt.Errorf("Invalid auth rule path prefix: [%s]", ar.PathPrefix)
+		}
+	}
+
+	realm := sac.Realm
+	if len(realm) == 0 {
+		realm = DefaultAuthRealm
+	}
+
+	sa := &serverAuth{
+		certificate: sac.Certificate,
+		rules:       slices.Clone(sac.Rules),
+	}
+
+	if len(sac.BasicFile) > 0 {
+		var err error
+		if sa.credentials, err = readCredentials(sac.BasicFile); err != nil {
+			return nil, err
+		}
+
+		sa.challenges = append(sa.challenges, fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, realm))
+	}
+
+	if len(sac.BearerTokens) > 0 {
+		sa.tokens = make(map[[sha256.Size]byte]string, len(sac.BearerTokens))
+		for name, token := range sac.BearerTokens {
+			digest := sha256.Sum256([]byte(token))
+			switch {
+			case len(token) == 0:
+				return nil, fmt.Errorf("The bearer token for %s is empty", name)
+
+			case len(sa.tokens[digest]) > 0:
+				return nil, fmt.Errorf("The bearer token for %s is not unique", name)
+			}
+
+			sa.tokens[digest] = name
+		}
+
+		sa.challenges = append(sa.challenges, fmt.Sprintf(`Bearer realm="%s"`, realm))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			p, ok := sa.authenticate(request)
+			if !ok {
+				sa.unauthorized(response)
+				return
+			}
+
+			ar := sa.rule(request)
+			switch {
+			case len(p.Name) == 0 && ar.Anonymous:
+				next.ServeHTTP(response, request)
+				return
+
+			case len(p.Name) == 0:
+				sa.unauthorized(response)
+				return
+
+			case !ar.allows(p):
+				http.Error(response, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+
+			request = request.WithContext(WithPrincipal(request.Context(), p))
+			next.ServeHTTP(response, request)
+			recordRoute(request)
+		})
+	}, nil
+}
+
+// Apply allows a ServerAuthConfig to be used as an Option[http.Server].
+func (sac ServerAuthConfig) Apply(s *http.Server) error {
+	m, err := sac.Middleware()
+	if m != nil {
+		s.Handler = m(arrangereflect.Safe[http.Handler](s.Handler, http.DefaultServeMux))
+	}
+
+	return err
+}
@@ -0,0 +1,329 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange/arrangepprof"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type ServerAuthSuite struct {
+	suite.Suite
+}
+
+// writeFile writes a file in a temporary directory, returning its path.
+func (suite *ServerAuthSuite) writeFile(contents string) string {
+	path := filepath.Join(suite.T().TempDir(), "credentials")
+	suite.Require().NoError(os.WriteFile(path, []byte(contents), 0600))
+	return path
+}
+
+// credentials returns the contents of a credential file for the given username and password.
+func (suite *ServerAuthSuite) credentials(username, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	suite.Require().NoError(err)
+	return username + ":" + string(hash) + "\n"
+}
+
+// principal returns a handler that writes the request's principal.
+func (suite *ServerAuthSuite) principal() http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if p, ok := GetPrincipal(request.Context()); ok {
+			io.WriteString(response, p.Method+":"+p.Name)
+		} else {
+			io.WriteString(response, "anonymous")
+		}
+	})
+}
+
+func (suite *ServerAuthSuite) newHandler(sac ServerAuthConfig) http.Handler {
+	m, err := sac.Middleware()
+	suite.Require().NoError(err)
+	suite.Require().NotNil(m)
+	return m(suite.principal())
+}
+
+func (suite *ServerAuthSuite) serve(h http.Handler, request *http.Request) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, request)
+	return response
+}
+
+func (suite *ServerAuthSuite) TestPrincipalContext() {
+	p, ok := GetPrincipal(WithPrincipal(suite.T().Context(), Principal{Name: "test", Method: AuthMethodBasic}))
+	suite.True(ok)
+	suite.Equal(Principal{Name: "test", Method: AuthMethodBasic}, p)
+
+	_, ok = GetPrincipal(suite.T().Context())
+	suite.False(ok)
+}
+
+func (suite *ServerAuthSuite) TestDisabled() {
+	m, err := ServerAuthConfig{}.Middleware()
+	suite.NoError(err)
+	suite.Nil(m)
+
+	s := new(http.Server)
+	suite.NoError(ServerAuthConfig{}.Apply(s))
+	suite.Nil(s.Handler)
+}
+
+func (suite *ServerAuthSuite) TestInvalid() {
+	for _, invalid := range []ServerAuthConfig{
+		{Rules: []AuthRule{{PathPrefix: "/", Anonymous: true}}},
+		{BearerTokens: map[string]string{"test": "token"}, Rules: []AuthRule{{PathPrefix: "relative"}}},
+		{BearerTokens: map[string]string{"test": ""}},
+		{BearerTokens: map[string]string{"first": "token", "second": "token"}},
+		{BasicFile: "nosuch.file"},
+		{BasicFile: suite.writeFile("nohash\n")},
+		{BasicFile: suite.writeFile("user:notbcrypt\n")},
+		{BasicFile: suite.writeFile(suite.credentials("user", "a") + suite.credentials("user", "b"))},
+	} {
+		m, err := invalid.Middleware()
+		suite.Error(err)
+		suite.Nil(m)
+
+		suite.Error(ServerConfig{Auth: invalid}.Apply(new(http.Server)))
+	}
+
+	_, err := ServerAuthConfig{Rules: []AuthRule{{PathPrefix: "/"}}}.Middleware()
+	suite.ErrorIs(err, ErrNoServerAuthMethods)
+}
+
+func (suite *ServerAuthSuite) TestBasic() {
+	h := suite.newHandler(ServerAuthConfig{
+		Realm:     "test",
+		BasicFile: suite.writeFile("# comment\n\n" + suite.credentials("joe", "secret")),
+	})
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.SetBasicAuth("joe", "secret")
+	response := suite.serve(h, request)
+	suite.Equal(http.StatusOK, response.Code)
+	suite.Equal("basic:joe", response.Body.String())
+
+	for _, credentials := range [][2]string{{"joe", "wrong"}, {"nosuch", "secret"}} {
+		request = httptest.NewRequest("GET", "/", nil)
+		request.SetBasicAuth(credentials[0], credentials[1])
+		response = suite.serve(h, request)
+		suite.Equal(http.StatusUnauthorized, response.Code)
+		suite.Equal(`Basic realm="test", charset="UTF-8"`, response.Header().Get("WWW-Authenticate"))
+	}
+
+	// a bearer token isn't configured, so it's invalid
+	request = httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Authorization", "Bearer token")
+	suite.Equal(http.StatusUnauthorized, suite.serve(h, request).Code)
+
+	suite.Equal(http.StatusUnauthorized, suite.serve(h, httptest.NewRequest("GET", "/", nil)).Code)
+}
+
+func (suite *ServerAuthSuite) TestBearer() {
+	h := suite.newHandler(ServerAuthConfig{
+		BearerTokens: map[string]string{"deployer": "deploy-token"},
+	})
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Authorization", "Bearer deploy-token")
+	response := suite.serve(h, request)
+	suite.Equal(http.StatusOK, response.Code)
+	suite.Equal("bearer:deployer", response.Body.String())
+
+	request = httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Authorization", "bearer wrong")
+	response = suite.serve(h, request)
+	suite.Equal(http.StatusUnauthorized, response.Code)
+	suite.Equal(`Bearer realm="server"`, response.Header().Get("WWW-Authenticate"))
+}
+
+func (suite *ServerAuthSuite) TestCertificate() {
+	var (
+		spiffe, _ = url.Parse("spiffe://example.com/service")
+		cert      = &x509.Certificate{
+			Subject:  pkix.Name{CommonName: "client.example.com"},
+			DNSNames: []string{"alt.example.com"},
+			URIs:     []*url.URL{spiffe},
+		}
+
+		newRequest = func(verified bool) *http.Request {
+			request := httptest.NewRequest("GET", "/", nil)
+			request.TLS = &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{cert},
+			}
+
+			if verified {
+				request.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+			}
+
+			return request
+		}
+	)
+
+	h := suite.newHandler(ServerAuthConfig{
+		Certificate: CertificateAuthConfig{Enabled: true},
+	})
+
+	suite.Equal("certificate:client.example.com", suite.serve(h, newRequest(true)).Body.String())
+	suite.Equal(http.StatusUnauthorized, suite.serve(h, newRequest(false)).Code)
+
+	h = suite.newHandler(ServerAuthConfig{
+		Certificate: CertificateAuthConfig{
+			Enabled:    true,
+			Principals: map[string]string{"spiffe://example.com/service": "service"},
+		},
+	})
+
+	suite.Equal("certificate:service", suite.serve(h, newRequest(true)).Body.String())
+
+	h = suite.newHandler(ServerAuthConfig{
+		Certificate: CertificateAuthConfig{
+			Enabled:    true,
+			Principals: map[string]string{"other.example.com": "other"},
+		},
+	})
+
+	suite.Equal(http.StatusUnauthorized, suite.serve(h, newRequest(true)).Code)
+}
+
+func (suite *ServerAuthSuite) TestRules() {
+	h := suite.newHandler(ServerAuthConfig{
+		BearerTokens: map[string]string{
+			"admin":  "admin-token",
+			"reader": "reader-token",
+		},
+		Rules: []AuthRule{
+			{PathPrefix: "/public", Anonymous: true},
+			{PathPrefix: "/admin", Principals: []string{"admin"}},
+			{PathPrefix: "/items", Methods: []string{"PUT", "DELETE"}, Principals: []string{"admin"}},
+		},
+	})
+
+	newRequest := func(method, target, token string) *http.Request {
+		request := httptest.NewRequest(method, target, nil)
+		if len(token) > 0 {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		return request
+	}
+
+	testData := []struct {
+		method, target, token string
+		expectedCode          int
+		expectedBody          string
+	}{
+		{"GET", "/public/page", "", http.StatusOK, "anonymous"},
+		{"GET", "/public/page", "reader-token", http.StatusOK, "bearer:reader"},
+		{"GET", "/public/page", "invalid", http.StatusUnauthorized, ""},
+		{"GET", "/admin/debug", "admin-token", http.StatusOK, "bearer:admin"},
+		{"GET", "/admin/debug", "reader-token", http.StatusForbidden, ""},
+		{"GET", "/admin/debug", "", http.StatusUnauthorized, ""},
+		{"GET", "/items/1", "reader-token", http.StatusOK, "bearer:reader"},
+		{"PUT", "/items/1", "reader-token", http.StatusForbidden, ""},
+		{"PUT", "/items/1", "admin-token", http.StatusOK, "bearer:admin"},
+		{"GET", "/other", "", http.StatusUnauthorized, ""},
+	}
+
+	for _, record := range testData {
+		response := suite.serve(h, newRequest(record.method, record.target, record.token))
+		suite.Equal(record.expectedCode, response.Code, "%s %s", record.method, record.target)
+		if len(record.expectedBody) > 0 {
+			suite.Equal(record.expectedBody, response.Body.String())
+		}
+	}
+}
+
+func (suite *ServerAuthSuite) TestRuleAllows() {
+	testData := []struct {
+		principals []string
+		p          Principal
+		expected   bool
+	}{
+		{nil, Principal{Name: "anyone", Method: AuthMethodCertificate}, true},
+		{[]string{"admin"}, Principal{Name: "admin", Method: AuthMethodBasic}, true},
+		{[]string{"admin"}, Principal{Name: "admin", Method: AuthMethodBearer}, true},
+		{[]string{"admin"}, Principal{Name: "admin", Method: AuthMethodCertificate}, false},
+		{[]string{"certificate:admin"}, Principal{Name: "admin", Method: AuthMethodCertificate}, true},
+		{[]string{"certificate:admin"}, Principal{Name: "admin", Method: AuthMethodBearer}, false},
+		{[]string{"bearer:admin"}, Principal{Name: "admin", Method: AuthMethodBearer}, true},
+		{[]string{"bearer:admin"}, Principal{Name: "admin", Method: AuthMethodBasic}, false},
+	}
+
+	for _, record := range testData {
+		suite.Equal(record.expected, AuthRule{Principals: record.principals}.allows(record.p), "%v %v", record.principals, record.p)
+	}
+}
+
+func (suite *ServerAuthSuite) TestCertificateRules() {
+	var (
+		cert = &x509.Certificate{
+			Subject: pkix.Name{CommonName: "admin"},
+		}
+
+		newRequest = func(target string) *http.Request {
+			request := httptest.NewRequest("GET", target, nil)
+			request.TLS = &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{cert},
+				VerifiedChains:   [][]*x509.Certificate{{cert}},
+			}
+
+			return request
+		}
+	)
+
+	h := suite.newHandler(ServerAuthConfig{
+		BearerTokens: map[string]string{"admin": "admin-token"},
+		Certificate:  CertificateAuthConfig{Enabled: true},
+		Rules: []AuthRule{
+			{PathPrefix: "/admin", Principals: []string{"admin"}},
+			{PathPrefix: "/service", Principals: []string{"certificate:admin"}},
+		},
+	})
+
+	// a certificate named like a configured principal doesn't get that principal's access
+	suite.Equal(http.StatusForbidden, suite.serve(h, newRequest("/admin")).Code)
+
+	response := suite.serve(h, newRequest("/service"))
+	suite.Equal(http.StatusOK, response.Code)
+	suite.Equal("certificate:admin", response.Body.String())
+}
+
+func (suite *ServerAuthSuite) TestServerConfig() {
+	s := &http.Server{
+		Handler: arrangepprof.HTTP{}.New(),
+	}
+
+	sc := ServerConfig{
+		Header: http.Header{"X-Server": {"true"}},
+		Auth: ServerAuthConfig{
+			BearerTokens: map[string]string{"operator": "operator-token"},
+		},
+	}
+
+	suite.Require().NoError(sc.Apply(s))
+
+	response := suite.serve(s.Handler, httptest.NewRequest("GET", arrangepprof.DefaultPathPrefix, nil))
+	suite.Equal(http.StatusUnauthorized, response.Code)
+	suite.Equal("true", response.Header().Get("X-Server"))
+
+	request := httptest.NewRequest("GET", arrangepprof.DefaultPathPrefix, nil)
+	request.Header.Set("Authorization", "Bearer operator-token")
+	suite.Equal(http.StatusOK, suite.serve(s.Handler, request).Code)
+}
+
+func TestServerAuth(t *testing.T) {
+	suite.Run(t, new(ServerAuthSuite))
+}
@@ -62,9 +62,54 @@ type ServerConfig struct {
 	// Header supplies HTTP headers to emit on every response from this server
 	Header http.Header `json:"header" yaml:"header"`
 
+	// Compression configures response compression for this server.
+	Compression CompressionConfig `json:"compression" yaml:"compression"`
+
+	// CORS configures Cross-Origin Resource Sharing for this server.
+	CORS CORSConfig `json:"cors" yaml:"cors"`
+
+	// Limits configures per-route handler timeouts and request body sizes for this server.
+	Limits RouteLimits `json:"limits" yaml:"limits"`
+
+	// LoadShedding configures a concurrency limit for this server.  Requests over the limit
+	// are queued or rejected.
+	LoadShedding LoadSheddingConfig `json:"loadShedding" yaml:"loadShedding"`
+
+	// Auth configures authentication and authorization of requests to this server.
+	Auth ServerAuthConfig `json:"auth" yaml:"auth"`
+
 	// TLS is the optional unmarshaled TLS configuration.  If set, the resulting
 	// server will use HTTPS.
 	TLS *arrangetls.Config `json:"tls" yaml:"tls"`
+
+	// HTTPSRedirect configures a companion plain HTTP server that redirects to this server,
+	// which must use TLS.  The redirect server is only started when this server is created
+	// by ProvideServer.
+	HTTPSRedirect HTTPSRedirectConfig `json:"httpsRedirect" yaml:"httpsRedirect"`
+
+	// Maintenance configures the maintenance switch for this server.  The switch is only
+	// applied when the server is created by ProvideServer.  Admin servers have no switch.
+	Maintenance MaintenanceConfig `json:"maintenance" yaml:"maintenance"`
+
+	// AccessLog configures structured access logging for this server.  Access logs
+	// require a *zap.Logger, which ProvideServer obtains from the enclosing fx.App.
+	AccessLog AccessLogConfig `json:"accessLog" yaml:"accessLog"`
+
+	// Metrics configures request metrics for this server.  Metrics are only recorded
+	// when the server is created by ProvideServer.
+	Metrics MetricsConfig `json:"metrics" yaml:"metrics"`
+
+	// Recovery configures recovery of panics in handlers.  Recovery is only applied
+	// when the server is created by ProvideServer.
+	Recovery RecoveryConfig `json:"recovery" yaml:"recovery"`
+
+	// RequestID configures request identifiers for this server.  Request identifiers
+	// are only applied when the server is created by ProvideServer.
+	RequestID RequestIDConfig `json:"requestID" yaml:"requestID"`
+
+	// Trace configures W3C Trace Context propagation for this server.  Tracing is only
+	// applied when the server is created by ProvideServer.
+	Trace TraceConfig `json:"trace" yaml:"trace"`
 }
 
 // NewServer is the built-in implementation of ServerFactory in this package.
@@ -94,9 +139,60 @@ func (sc ServerConfig) Listen(ctx context.Context, s *http.Server) (net.Listener
 	}.Listen(ctx, s)
 }
 
+// accessLogConfig returns the access log configuration for ProvideServer.
+func (sc ServerConfig) accessLogConfig() AccessLogConfig {
+	return sc.AccessLog
+}
+
+// metricsConfig returns the metrics configuration for ProvideServer.
+func (sc ServerConfig) metricsConfig() MetricsConfig {
+	return sc.Metrics
+}
+
+// recoveryConfig returns the panic recovery configuration for ProvideServer.
+func (sc ServerConfig) recoveryConfig() RecoveryConfig {
+	return sc.Recovery
+}
+
+// requestIDConfig returns the request identifier configuration for ProvideServer.
+func (sc ServerConfig) requestIDConfig() RequestIDConfig {
+	return sc.RequestID
+}
+
+// httpsRedirectConfig returns the HTTPS redirect server configuration for ProvideServer.
+func (sc ServerConfig) httpsRedirectConfig() HTTPSRedirectConfig {
+	return sc.HTTPSRedirect
+}
+
+// maintenanceConfig returns the maintenance switch configuration for ProvideServer.
+func (sc ServerConfig) maintenanceConfig() (MaintenanceConfig, bool) {
+	return sc.Maintenance, true
+}
+
+// traceConfig returns the trace context configuration for ProvideServer.
+func (sc ServerConfig) traceConfig() TraceConfig {
+	return sc.Trace
+}
+
 // Apply allows this configuration object to be seen as an Option[http.Server].
-// This method adds the configured headers to every response.
+// This method applies any route limits, authentication, and load shedding, adds the configured
+// headers to every response, and applies any compression and CORS configuration.
+//
+// Load shedding is applied outside of authentication, so that rejected requests do not pay
+// for verifying credentials.  As a consequence, a RequestClassifier cannot see the Principal.
 func (sc ServerConfig) Apply(s *http.Server) error {
+	if err := sc.Limits.Apply(s); err != nil {
+		return err
+	}
+
+	if err := sc.Auth.Apply(s); err != nil {
+		return err
+	}
+
+	if err := sc.LoadShedding.Apply(s); err != nil {
+		return err
+	}
+
 	if len(sc.Header) > 0 {
 		header := httpaux.NewHeader(sc.Header)
 		s.Handler = server.Header(header.SetTo)(
@@ -104,5 +200,9 @@ func (sc ServerConfig) Apply(s *http.Server) error {
 		)
 	}
 
-	return nil
+	if err := sc.Compression.Apply(s); err != nil {
+		return err
+	}
+
+	return sc.CORS.Apply(s)
 }
@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	// ServerRequestsTotal counts server requests by method, status class, and route.
+	ServerRequestsTotal = "http_server_requests_total"
+
+	// ServerRequestDuration is the latency of server requests by method, status class, and route.
+	ServerRequestDuration = "http_server_request_duration_seconds"
+
+	// ServerResponseSize is the size of response bodies by method, status class, and route.
+	ServerResponseSize = "http_server_response_size_bytes"
+
+	// ServerInFlightRequests is the number of requests a server is currently handling.
+	ServerInFlightRequests = "http_server_in_flight_requests"
+
+	// UnknownRoute is the route label for requests that were not routed by an http.ServeMux.
+	UnknownRoute = "unknown"
+
+	// OtherMethod is the method label for requests with a nonstandard method.  This keeps
+	// clients from creating an unbounded number of metrics.
+	OtherMethod = "OTHER"
+)
+
+var (
+	// DefaultSizeBuckets are the response size histogram buckets, in bytes.
+	DefaultSizeBuckets = []float64{100, 1000, 10000, 100000, 1e6, 1e7}
+)
+
+// methodLabel returns the metric label for a request method.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+
+	default:
+		return OtherMethod
+	}
+}
+
+// routeLabel returns the metric label for the route with the given pattern, as
+// returned by routePattern.
+func routeLabel(pattern string) string {
+	if len(pattern) > 0 {
+		return pattern
+	}
+
+	return UnknownRoute
+}
+
+// ServerMiddleware returns a server middleware that records request metrics to the given
+// Registry.  The route label is the http.ServeMux pattern that matched the request.  If
+// middleware between this one and the ServeMux replaces the *http.Request, the pattern is
+// only known when the ServeMux was created with NewRouteMux.
+//
+// This method does not check the Enabled flag.  ProvideServer uses that flag to decide
+// whether to apply this middleware.
+func (mc MetricsConfig) ServerMiddleware(r Registry) func(http.Handler) http.Handler {
+	buckets := mc.buckets()
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			var (
+				start    = time.Now()
+				ow       = newObservedWriter(response)
+				inFlight = r.Gauge(ServerInFlightRequests, "Requests currently being handled", nil)
+			)
+
+			inFlight.Add(1)
+			defer inFlight.Add(-1)
+
+			request, mr := withMatchedRoute(request)
+			next.ServeHTTP(ow, request)
+			labels := Labels{
+				"method": methodLabel(request.Method),
+				"class":  statusClass(ow.StatusCode()),
+				"route":  routeLabel(routePattern(request, mr)),
+			}
+
+			r.Counter(ServerRequestsTotal, "Requests by method, status class, and route", labels).Add(1)
+			r.Histogram(ServerRequestDuration, "Request latency", buckets, labels).
+				Observe(time.Since(start).Seconds())
+			r.Histogram(ServerResponseSize, "Response body size", DefaultSizeBuckets, labels).
+				Observe(float64(ow.ContentLength()))
+		})
+	}
+}
+
+// MetricsHandler returns a server middleware that serves h at the given path, such as a
+// MetricsRegistry.  All other requests are passed to the decorated handler.  If path is
+// empty, the returned middleware does nothing.
+func MetricsHandler(path string, h http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(path) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			if request.URL.Path == path {
+				h.ServeHTTP(response, request)
+			} else {
+				next.ServeHTTP(response, request)
+			}
+		})
+	}
+}
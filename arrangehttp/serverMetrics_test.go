@@ -0,0 +1,256 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange"
+	"github.com/xmidt-org/arrange/arrangetest"
+	"go.uber.org/fx"
+)
+
+type ServerMetricsSuite struct {
+	suite.Suite
+}
+
+func (suite *ServerMetricsSuite) newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(response http.ResponseWriter, _ *http.Request) {
+		io.WriteString(response, "item")
+	})
+
+	return mux
+}
+
+func (suite *ServerMetricsSuite) serve(h http.Handler, method, target string) *httptest.ResponseRecorder {
+	response := httptest.NewRecorder()
+	h.ServeHTTP(response, httptest.NewRequest(method, target, nil))
+	return response
+}
+
+func (suite *ServerMetricsSuite) text(r *MetricsRegistry) string {
+	var b strings.Builder
+	suite.Require().NoError(r.WriteText(&b))
+	return b.String()
+}
+
+func (suite *ServerMetricsSuite) TestServerMiddleware() {
+	r := NewMetricsRegistry()
+	h := MetricsConfig{}.ServerMiddleware(r)(suite.newMux())
+
+	suite.Equal(http.StatusOK, suite.serve(h, "GET", "/items/1").Code)
+	suite.Equal(http.StatusOK, suite.serve(h, "GET", "/items/2").Code)
+	suite.Equal(http.StatusNotFound, suite.serve(h, "GET", "/nosuch").Code)
+	suite.serve(h, "BREW", "/items/1")
+
+	text := suite.text(r)
+	suite.Contains(text, `http_server_requests_total{class="2xx",method="GET",route="GET /items/{id}"} 2`)
+	suite.Contains(text, `http_server_requests_total{class="4xx",method="GET",route="unknown"} 1`)
+	suite.Contains(text, `http_server_requests_total{class="4xx",method="OTHER",route="unknown"} 1`)
+	suite.Contains(text, `http_server_request_duration_seconds_count{class="2xx",method="GET",route="GET /items/{id}"} 2`)
+	suite.Contains(text, `http_server_response_size_bytes_sum{class="2xx",method="GET",route="GET /items/{id}"} 8`)
+	suite.Contains(text, "http_server_in_flight_requests 0")
+}
+
+func (suite *ServerMetricsSuite) TestMetricsHandler() {
+	r := NewMetricsRegistry()
+	r.Counter("c", "", nil).Add(1)
+
+	h := MetricsHandler("/metrics", r)(suite.newMux())
+	response := suite.serve(h, "GET", "/metrics")
+	suite.Equal(PrometheusContentType, response.Header().Get("Content-Type"))
+	suite.Equal("# TYPE c counter\nc 1\n", response.Body.String())
+	suite.Equal("item", suite.serve(h, "GET", "/items/1").Body.String())
+
+	next := suite.newMux()
+	suite.Same(next, MetricsHandler("", r)(next))
+}
+
+func (suite *ServerMetricsSuite) TestProvideServer() {
+	var (
+		r      = NewMetricsRegistry()
+		server *http.Server
+	)
+
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			fx.Annotate(r, fx.As(new(Registry))),
+			fx.Annotated{
+				Name: "server.config",
+				Target: ServerConfig{
+					Address: ":0",
+					Metrics: MetricsConfig{
+						Enabled: true,
+						Path:    DefaultMetricsPath,
+					},
+				},
+			},
+		),
+		fx.Provide(
+			fx.Annotate(
+				func() http.Handler { return suite.newMux() },
+				arrange.Tags().Name("server.handler").ResultTags(),
+			),
+		),
+		ProvideServer("server"),
+		fx.Populate(
+			fx.Annotate(
+				&server,
+				arrange.Tags().Name("server").ParamTags(),
+			),
+		),
+	)
+
+	app.RequireStart()
+	defer app.RequireStop()
+
+	suite.Equal(http.StatusOK, suite.serve(server.Handler, "GET", "/items/1").Code)
+	body := suite.serve(server.Handler, "GET", DefaultMetricsPath).Body.String()
+	suite.Contains(body, `http_server_requests_total{class="2xx",method="GET",route="GET /items/{id}",server="server"} 1`)
+}
+
+func (suite *ServerMetricsSuite) TestProvideServerWithAuth() {
+	var (
+		r      = NewMetricsRegistry()
+		e      testSpanExporter
+		server *http.Server
+	)
+
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			fx.Annotate(r, fx.As(new(Registry))),
+			fx.Annotate(&e, fx.As(new(SpanExporter))),
+			fx.Annotated{
+				Name: "server.config",
+				Target: ServerConfig{
+					Address: ":0",
+					Metrics: MetricsConfig{Enabled: true},
+					Trace:   TraceConfig{Enabled: true, SampleRatio: 1.0},
+					Auth: ServerAuthConfig{
+						BearerTokens: map[string]string{"joe": "token"},
+					},
+				},
+			},
+		),
+		fx.Provide(
+			fx.Annotate(
+				func() http.Handler { return suite.newMux() },
+				arrange.Tags().Name("server.handler").ResultTags(),
+			),
+		),
+		ProvideServer("server"),
+		fx.Populate(
+			fx.Annotate(
+				&server,
+				arrange.Tags().Name("server").ParamTags(),
+			),
+		),
+	)
+
+	app.RequireStart()
+	defer app.RequireStop()
+
+	// the auth middleware replaces the request before it reaches the ServeMux
+	request := httptest.NewRequest("GET", "/items/1", nil)
+	request.Header.Set("Authorization", "Bearer token")
+	response := httptest.NewRecorder()
+	server.Handler.ServeHTTP(response, request)
+	suite.Equal(http.StatusOK, response.Code)
+
+	suite.Contains(
+		suite.text(r),
+		`http_server_requests_total{class="2xx",method="GET",route="GET /items/{id}",server="server"} 1`,
+	)
+
+	spans := e.Spans()
+	suite.Require().Len(spans, 1)
+	suite.Equal("GET /items/{id}", spans[0].Name)
+	suite.Equal("GET /items/{id}", spans[0].Attributes["http.route"])
+}
+
+func (suite *ServerMetricsSuite) TestMetricsPathWithAuth() {
+	var (
+		r      = NewMetricsRegistry()
+		server *http.Server
+	)
+
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			fx.Annotate(r, fx.As(new(Registry))),
+			fx.Annotated{
+				Name: "server.config",
+				Target: ServerConfig{
+					Address: ":0",
+					Metrics: MetricsConfig{Enabled: true, Path: DefaultMetricsPath},
+					Auth: ServerAuthConfig{
+						BearerTokens: map[string]string{"joe": "token"},
+					},
+				},
+			},
+		),
+		fx.Provide(
+			fx.Annotate(
+				func() http.Handler { return suite.newMux() },
+				arrange.Tags().Name("server.handler").ResultTags(),
+			),
+		),
+		ProvideServer("server"),
+		fx.Populate(
+			fx.Annotate(
+				&server,
+				arrange.Tags().Name("server").ParamTags(),
+			),
+		),
+	)
+
+	app.RequireStart()
+	defer app.RequireStop()
+
+	response := httptest.NewRecorder()
+	server.Handler.ServeHTTP(response, httptest.NewRequest("GET", DefaultMetricsPath, nil))
+	suite.Equal(http.StatusUnauthorized, response.Code)
+	suite.NotContains(response.Body.String(), "http_server_requests_total")
+
+	request := httptest.NewRequest("GET", DefaultMetricsPath, nil)
+	request.Header.Set("Authorization", "Bearer token")
+	response = httptest.NewRecorder()
+	server.Handler.ServeHTTP(response, request)
+	suite.Equal(http.StatusOK, response.Code)
+	suite.Contains(response.Body.String(), "http_server_requests_total")
+}
+
+func (suite *ServerMetricsSuite) TestRouteMuxWithLimits() {
+	mux, err := NewRouteMux(Route{
+		Pattern: "GET /items/{id}",
+		Handler: http.HandlerFunc(func(response http.ResponseWriter, _ *http.Request) {
+			io.WriteString(response, "item")
+		}),
+	})
+
+	suite.Require().NoError(err)
+
+	// a ResponseRecorder has no deadlines, so the limit is enforced with an
+	// http.TimeoutHandler, which replaces the request
+	limits, err := RouteLimits{{PathPrefix: "/items", Timeout: time.Minute}}.Middleware()
+	suite.Require().NoError(err)
+
+	r := NewMetricsRegistry()
+	h := MetricsConfig{}.ServerMiddleware(r)(limits(mux))
+	suite.Equal(http.StatusOK, suite.serve(h, "GET", "/items/1").Code)
+	suite.Contains(suite.text(r), `http_server_requests_total{class="2xx",method="GET",route="GET /items/{id}"} 1`)
+}
+
+func TestServerMetrics(t *testing.T) {
+	suite.Run(t, new(ServerMetricsSuite))
+}
@@ -10,6 +10,7 @@ import (
 	"net/http"
 
 	"github.com/xmidt-org/arrange/internal/arrangereflect"
+	"go.uber.org/zap"
 )
 
 // ConnState returns a server option that sets or replaces the http.Server.ConnState function.
@@ -91,6 +92,12 @@ func ErrorLog(l *log.Logger) Option[http.Server] {
 	})
 }
 
+// ZapErrorLog returns a server option that sets or replaces the http.Server.ErrorLog with
+// a logger that writes to the given zap logger.  See NewErrorLog.
+func ZapErrorLog(l *zap.Logger) Option[http.Server] {
+	return ErrorLog(NewErrorLog(l))
+}
+
 // ServerMiddleware returns an option that applies any number of middleware functions
 // to a server's handler.
 func ServerMiddleware[M Middleware[http.Handler]](fns ...M) Option[http.Server] {
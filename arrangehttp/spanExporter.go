@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// JSONLinesExporter is a SpanExporter that writes each span as a single line of JSON.
+type JSONLinesExporter struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+var _ SpanExporter = (*JSONLinesExporter)(nil)
+
+// NewJSONLinesExporter creates a JSONLinesExporter that writes to w.  If w is an io.Closer,
+// it is closed by Close.
+func NewJSONLinesExporter(w io.Writer) *JSONLinesExporter {
+	e := &JSONLinesExporter{
+		encoder: json.NewEncoder(w),
+	}
+
+	e.closer, _ = w.(io.Closer)
+	return e
+}
+
+// OpenJSONLinesFile creates a JSONLinesExporter that appends to the given file,
+// creating it if necessary.
+func OpenJSONLinesFile(path string) (*JSONLinesExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewJSONLinesExporter(f), nil
+}
+
+// ExportSpan writes a span as a line of JSON.
+func (e *JSONLinesExporter) ExportSpan(s Span) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.encoder.Encode(s)
+}
+
+// Close closes the underlying writer, if it is an io.Closer.
+func (e *JSONLinesExporter) Close() error {
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+
+	return nil
+}
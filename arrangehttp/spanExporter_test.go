@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SpanExporterSuite struct {
+	suite.Suite
+}
+
+func (suite *SpanExporterSuite) newSpan(name string) Span {
+	parent, err := ParseTraceParent(testTraceParent)
+	suite.Require().NoError(err)
+
+	return Span{
+		TraceID:      parent.TraceID,
+		SpanID:       newSpanID(),
+		ParentSpanID: parent.SpanID,
+		Name:         name,
+		Kind:         SpanKindServer,
+		Start:        time.Now(),
+		End:          time.Now(),
+		StatusCode:   200,
+	}
+}
+
+func (suite *SpanExporterSuite) TestWriter() {
+	var (
+		b bytes.Buffer
+		e = NewJSONLinesExporter(&b)
+	)
+
+	suite.NoError(e.ExportSpan(suite.newSpan("first")))
+	suite.NoError(e.ExportSpan(Span{Name: "root"}))
+	suite.NoError(e.Close())
+
+	scanner := bufio.NewScanner(&b)
+	suite.Require().True(scanner.Scan())
+
+	var line map[string]any
+	suite.Require().NoError(json.Unmarshal(scanner.Bytes(), &line))
+	suite.Equal("first", line["name"])
+	suite.Equal("4bf92f3577b34da6a3ce929d0e0e4736", line["traceID"])
+	suite.Equal("00f067aa0ba902b7", line["parentSpanID"])
+
+	suite.Require().True(scanner.Scan())
+	line = nil
+	suite.Require().NoError(json.Unmarshal(scanner.Bytes(), &line))
+	suite.Equal("root", line["name"])
+	suite.NotContains(line, "parentSpanID")
+	suite.False(scanner.Scan())
+}
+
+func (suite *SpanExporterSuite) TestFile() {
+	path := filepath.Join(suite.T().TempDir(), "spans.jsonl")
+	for _, name := range []string{"first", "second"} {
+		e, err := OpenJSONLinesFile(path)
+		suite.Require().NoError(err)
+		suite.NoError(e.ExportSpan(suite.newSpan(name)))
+		suite.NoError(e.Close())
+	}
+
+	contents, err := os.ReadFile(path)
+	suite.Require().NoError(err)
+	suite.Equal(2, bytes.Count(contents, []byte("\n")))
+
+	_, err = OpenJSONLinesFile(filepath.Join(suite.T().TempDir(), "missing", "spans.jsonl"))
+	suite.Error(err)
+}
+
+func TestSpanExporter(t *testing.T) {
+	suite.Run(t, new(SpanExporterSuite))
+}
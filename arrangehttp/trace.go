@@ -0,0 +1,392 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xmidt-org/arrange/internal/arrangereflect"
+	"github.com/xmidt-org/httpaux/roundtrip"
+)
+
+const (
+	// TraceParentHeader is the W3C Trace Context header that identifies the parent span.
+	TraceParentHeader = "Traceparent"
+
+	// TraceStateHeader is the W3C Trace Context header that carries vendor-specific state.
+	TraceStateHeader = "Tracestate"
+
+	// FlagSampled is the trace flag indicating that the caller may have recorded the trace.
+	FlagSampled byte = 0x01
+
+	// maxTraceStateMembers is the maximum number of list members in a tracestate.
+	maxTraceStateMembers = 32
+
+	// SpanKindServer is the kind of span recorded by a server.
+	SpanKindServer = "server"
+
+	// SpanKindClient is the kind of span recorded by a client.
+	SpanKindClient = "client"
+)
+
+var (
+	// ErrInvalidTraceParent indicates that a traceparent value could not be parsed.
+	ErrInvalidTraceParent = errors.New("Invalid traceparent")
+)
+
+// TraceID is a W3C trace identifier.
+type TraceID [16]byte
+
+// IsValid tests if this identifier is not all zeroes.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// String returns the lowercase hexadecimal form of this identifier.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// MarshalText encodes this identifier as lowercase hexadecimal.
+func (id TraceID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// SpanID is a W3C span (parent) identifier.
+type SpanID [8]byte
+
+// IsValid tests if this identifier is not all zeroes.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// String returns the lowercase hexadecimal form of this identifier.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// MarshalText encodes this identifier as lowercase hexadecimal.
+func (id SpanID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// SpanContext is the propagated state of a span.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+}
+
+// IsValid tests if both the trace and span identifiers are valid.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Sampled tests if the sampled flag is set.
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+// TraceParent returns the version 00 traceparent value for this span context.
+func (sc SpanContext) TraceParent() string {
+	var b strings.Builder
+	b.Grow(55)
+	b.WriteString("00-")
+	b.WriteString(sc.TraceID.String())
+	b.WriteByte('-')
+	b.WriteString(sc.SpanID.String())
+	b.WriteByte('-')
+	b.WriteString(hex.EncodeToString([]byte{sc.Flags}))
+	return b.String()
+}
+
+// isLowerHex tests if a string is entirely lowercase hexadecimal.
+func isLowerHex(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if (v[i] < '0' || v[i] > '9') && (v[i] < 'a' || v[i] > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseTraceParent parses a traceparent header value.  Versions other than 00 are parsed
+// as version 00, as the W3C specification requires, provided they are well formed.
+func ParseTraceParent(v string) (sc SpanContext, err error) {
+	v = strings.TrimSpace(v)
+	wellFormed := len(v) >= 55 &&
+		v[2] == '-' && v[35] == '-' && v[52] == '-' &&
+		isLowerHex(v[0:2]) && v[0:2] != "ff" &&
+		(len(v) == 55 || (v[0:2] != "00" && v[55] == '-')) &&
+		isLowerHex(v[3:35]) && isLowerHex(v[36:52]) && isLowerHex(v[53:55])
+
+	if !wellFormed {
+		return SpanContext{}, ErrInvalidTraceParent
+	}
+
+	hex.Decode(sc.TraceID[:], []byte(v[3:35]))
+	hex.Decode(sc.SpanID[:], []byte(v[36:52]))
+
+	var flags [1]byte
+	hex.Decode(flags[:], []byte(v[53:55]))
+	sc.Flags = flags[0]
+
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceParent
+	}
+
+	return
+}
+
+// normalizeTraceState cleans up a tracestate value, dropping empty or malformed list
+// members and any members beyond the maximum allowed.
+func normalizeTraceState(v string) string {
+	members := make([]string, 0, 4)
+	for _, member := range strings.Split(v, ",") {
+		member = strings.TrimSpace(member)
+		if key, value, ok := strings.Cut(member, "="); ok && len(key) > 0 && len(value) > 0 {
+			members = append(members, member)
+			if len(members) == maxTraceStateMembers {
+				break
+			}
+		}
+	}
+
+	return strings.Join(members, ",")
+}
+
+// ExtractSpanContext reads the span context from the W3C Trace Context headers.
+func ExtractSpanContext(h http.Header) (sc SpanContext, err error) {
+	if sc, err = ParseTraceParent(h.Get(TraceParentHeader)); err == nil {
+		sc.TraceState = normalizeTraceState(strings.Join(h.Values(TraceStateHeader), ","))
+	}
+
+	return
+}
+
+// InjectSpanContext writes the span context to the W3C Trace Context headers.
+func InjectSpanContext(sc SpanContext, h http.Header) {
+	h.Set(TraceParentHeader, sc.TraceParent())
+	if len(sc.TraceState) > 0 {
+		h.Set(TraceStateHeader, sc.TraceState)
+	} else {
+		h.Del(TraceStateHeader)
+	}
+}
+
+// spanContextKey is the context key for span contexts.
+type spanContextKey struct{}
+
+// WithSpanContext returns a context that holds the given span context.
+func WithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// GetSpanContext returns the span context held in a context, if any.
+func GetSpanContext(ctx context.Context) (sc SpanContext, ok bool) {
+	sc, ok = ctx.Value(spanContextKey{}).(SpanContext)
+	return
+}
+
+// newTraceID generates a random, valid trace identifier.
+func newTraceID() (id TraceID) {
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+
+	return
+}
+
+// newSpanID generates a random, valid span identifier.
+func newSpanID() (id SpanID) {
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+
+	return
+}
+
+// Span is a completed unit of work that can be exported.
+type Span struct {
+	TraceID      TraceID           `json:"traceID"`
+	SpanID       SpanID            `json:"spanID"`
+	ParentSpanID SpanID            `json:"parentSpanID,omitzero"`
+	Name         string            `json:"name"`
+	Kind         string            `json:"kind"`
+	Start        time.Time         `json:"start"`
+	End          time.Time         `json:"end"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	StatusCode   int               `json:"statusCode,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// SpanExporter records completed spans.  Only sampled spans are exported.
+// Implementations must be safe for concurrent use.
+type SpanExporter interface {
+	ExportSpan(Span) error
+}
+
+// TraceConfig is the unmarshaled configuration for W3C Trace Context propagation.
+type TraceConfig struct {
+	// Enabled turns on trace context propagation.
+	Enabled bool `json:"enabled" yaml:"enabled"`
+
+	// SampleRatio is the fraction of new traces that are sampled, e.g. 0.01.  A new trace is
+	// started for each request without a valid traceparent header, whereas a continued trace
+	// keeps its caller's sampling decision.  If unset, new traces are propagated but not sampled.
+	// A ratio of 1.0 or more samples every new trace.
+	SampleRatio float64 `json:"sampleRatio" yaml:"sampleRatio"`
+}
+
+// sampled decides whether a new trace is sampled.  The decision is derived from the random
+// trace identifier, so it is consistent for any given trace.
+func (tc TraceConfig) sampled(id TraceID) bool {
+	switch {
+	case tc.SampleRatio <= 0.0:
+		return false
+
+	case tc.SampleRatio >= 1.0:
+		return true
+
+	default:
+		return float64(binary.BigEndian.Uint64(id[8:])) < tc.SampleRatio*(1<<64)
+	}
+}
+
+// traceFactory is implemented by client and server factories that configure
+// trace context propagation, such as ServerConfig.
+type traceFactory interface {
+	traceConfig() TraceConfig
+}
+
+// exportSpan sends a span to an exporter if the span is sampled.
+func exportSpan(e SpanExporter, sc SpanContext, s Span) {
+	if e != nil && sc.Sampled() {
+		// exporters are best effort, and must not affect requests
+		e.ExportSpan(s)
+	}
+}
+
+// ServerMiddleware returns a server middleware that starts a span for each request.  The
+// trace is continued from a valid traceparent header; otherwise, a new trace is started and
+// sampled according to SampleRatio.  The span context is available to handlers via
+// GetSpanContext, and clients created by ProvideClient with tracing enabled propagate it to
+// downstream calls.
+//
+// If e is not nil, a server span is exported for each sampled request.
+//
+// This method does not check the Enabled flag.  ProvideServer uses that flag to decide
+// whether to apply this middleware.
+func (tc TraceConfig) ServerMiddleware(e SpanExporter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			parent, err := ExtractSpanContext(request.Header)
+			sc := SpanContext{
+				TraceID:    parent.TraceID,
+				SpanID:     newSpanID(),
+				Flags:      parent.Flags,
+				TraceState: parent.TraceState,
+			}
+
+			if err != nil {
+				sc.TraceID = newTraceID()
+				sc.Flags = 0
+				if tc.sampled(sc.TraceID) {
+					sc.Flags = FlagSampled
+				}
+			}
+
+			var (
+				start = time.Now()
+				ow    = newObservedWriter(response)
+			)
+
+			request, mr := withMatchedRoute(request.WithContext(WithSpanContext(request.Context(), sc)))
+			next.ServeHTTP(ow, request)
+
+			pattern := routePattern(request, mr)
+			name := request.Method
+			if len(pattern) > 0 {
+				name = pattern
+			}
+
+			exportSpan(e, sc, Span{
+				TraceID:      sc.TraceID,
+				SpanID:       sc.SpanID,
+				ParentSpanID: parent.SpanID,
+				Name:         name,
+				Kind:         SpanKindServer,
+				Start:        start,
+				End:          time.Now(),
+				Attributes: map[string]string{
+					"http.method": request.Method,
+					"http.target": request.URL.RequestURI(),
+					"http.route":  routeLabel(pattern),
+				},
+				StatusCode: ow.StatusCode(),
+			})
+		})
+	}
+}
+
+// ClientMiddleware returns a client middleware that propagates the span context held in
+// each outgoing request's context.  Each request is a child span of that span context.
+// Requests without a span context are sent unchanged.
+//
+// If e is not nil, a client span is exported for each sampled request.
+func (tc TraceConfig) ClientMiddleware(e SpanExporter) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		next = arrangereflect.Safe(next, http.DefaultTransport)
+		return roundtrip.PreserveCloseIdler(
+			next,
+			roundtrip.Func(func(request *http.Request) (*http.Response, error) {
+				parent, ok := GetSpanContext(request.Context())
+				if !ok {
+					return next.RoundTrip(request)
+				}
+
+				sc := parent
+				sc.SpanID = newSpanID()
+
+				// RoundTrippers must not modify the original request
+				request = request.Clone(request.Context())
+				InjectSpanContext(sc, request.Header)
+
+				start := time.Now()
+				response, err := next.RoundTrip(request)
+				span := Span{
+					TraceID:      sc.TraceID,
+					SpanID:       sc.SpanID,
+					ParentSpanID: parent.SpanID,
+					Name:         request.Method,
+					Kind:         SpanKindClient,
+					Start:        start,
+					End:          time.Now(),
+					Attributes: map[string]string{
+						"http.method": request.Method,
+						"http.url":    request.URL.Redacted(),
+					},
+				}
+
+				if err != nil {
+					span.Error = err.Error()
+				} else {
+					span.StatusCode = response.StatusCode
+				}
+
+				exportSpan(e, sc, span)
+				return response, err
+			}),
+		)
+	}
+}
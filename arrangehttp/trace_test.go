@@ -0,0 +1,366 @@
+// SPDX-FileCopyrightText: 2023 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package arrangehttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/xmidt-org/arrange"
+	"github.com/xmidt-org/arrange/arrangetest"
+	"github.com/xmidt-org/httpaux/roundtrip"
+	"go.uber.org/fx"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// testSpanExporter collects exported spans.
+type testSpanExporter struct {
+	lock  sync.Mutex
+	spans []Span
+}
+
+func (tse *testSpanExporter) ExportSpan(s Span) error {
+	tse.lock.Lock()
+	defer tse.lock.Unlock()
+	tse.spans = append(tse.spans, s)
+	return nil
+}
+
+func (tse *testSpanExporter) Spans() []Span {
+	tse.lock.Lock()
+	defer tse.lock.Unlock()
+	return append([]Span{}, tse.spans...)
+}
+
+type TraceSuite struct {
+	suite.Suite
+}
+
+func (suite *TraceSuite) TestParseTraceParent() {
+	sc, err := ParseTraceParent(testTraceParent)
+	suite.Require().NoError(err)
+	suite.Equal("4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	suite.Equal("00f067aa0ba902b7", sc.SpanID.String())
+	suite.True(sc.Sampled())
+	suite.True(sc.IsValid())
+	suite.Equal(testTraceParent, sc.TraceParent())
+
+	// future versions may append fields
+	sc, err = ParseTraceParent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	suite.Require().NoError(err)
+	suite.False(sc.Sampled())
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01extra",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		_, err = ParseTraceParent(invalid)
+		suite.ErrorIs(err, ErrInvalidTraceParent, invalid)
+	}
+}
+
+func (suite *TraceSuite) TestExtractSpanContext() {
+	h := http.Header{}
+	h.Set(TraceParentHeader, testTraceParent)
+	h.Add(TraceStateHeader, "congo=t61rcWkgMzE, ,bad")
+	h.Add(TraceStateHeader, "rojo=00f067aa0ba902b7")
+
+	sc, err := ExtractSpanContext(h)
+	suite.Require().NoError(err)
+	suite.Equal("congo=t61rcWkgMzE,rojo=00f067aa0ba902b7", sc.TraceState)
+
+	h = http.Header{}
+	InjectSpanContext(sc, h)
+	suite.Equal(testTraceParent, h.Get(TraceParentHeader))
+	suite.Equal(sc.TraceState, h.Get(TraceStateHeader))
+
+	sc.TraceState = ""
+	InjectSpanContext(sc, h)
+	suite.Empty(h.Values(TraceStateHeader))
+
+	_, err = ExtractSpanContext(http.Header{})
+	suite.ErrorIs(err, ErrInvalidTraceParent)
+}
+
+func (suite *TraceSuite) TestNormalizeTraceState() {
+	var members []string
+	for i := 0; i < maxTraceStateMembers+5; i++ {
+		members = append(members, "k=v")
+	}
+
+	suite.Len(strings.Split(normalizeTraceState(strings.Join(members, ",")), ","), maxTraceStateMembers)
+	suite.Empty(normalizeTraceState(""))
+}
+
+func (suite *TraceSuite) TestGetSpanContext() {
+	_, ok := GetSpanContext(context.Background())
+	suite.False(ok)
+
+	expected := SpanContext{TraceID: newTraceID(), SpanID: newSpanID()}
+	actual, ok := GetSpanContext(WithSpanContext(context.Background(), expected))
+	suite.True(ok)
+	suite.Equal(expected, actual)
+}
+
+// serve runs a request through the trace server middleware, returning the span context
+// seen by the handler.
+func (suite *TraceSuite) serve(tc TraceConfig, e SpanExporter, header http.Header) (seen SpanContext) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(response http.ResponseWriter, request *http.Request) {
+		var ok bool
+		seen, ok = GetSpanContext(request.Context())
+		suite.True(ok)
+		response.WriteHeader(http.StatusOK)
+	})
+
+	request := httptest.NewRequest("GET", "/items/1", nil)
+	for name, values := range header {
+		request.Header[name] = values
+	}
+
+	tc.ServerMiddleware(e)(mux).ServeHTTP(httptest.NewRecorder(), request)
+	return
+}
+
+func (suite *TraceSuite) TestServerMiddlewareContinue() {
+	var (
+		e      testSpanExporter
+		parent SpanContext
+		header = http.Header{}
+	)
+
+	header.Set(TraceParentHeader, testTraceParent)
+	header.Set(TraceStateHeader, "congo=t61rcWkgMzE")
+	parent, _ = ParseTraceParent(testTraceParent)
+
+	seen := suite.serve(TraceConfig{}, &e, header)
+	suite.Equal(parent.TraceID, seen.TraceID)
+	suite.NotEqual(parent.SpanID, seen.SpanID)
+	suite.True(seen.Sampled())
+	suite.Equal("congo=t61rcWkgMzE", seen.TraceState)
+
+	spans := e.Spans()
+	suite.Require().Len(spans, 1)
+	suite.Equal(seen.TraceID, spans[0].TraceID)
+	suite.Equal(seen.SpanID, spans[0].SpanID)
+	suite.Equal(parent.SpanID, spans[0].ParentSpanID)
+	suite.Equal("GET /items/{id}", spans[0].Name)
+	suite.Equal(SpanKindServer, spans[0].Kind)
+	suite.Equal(http.StatusOK, spans[0].StatusCode)
+	suite.Equal("/items/1", spans[0].Attributes["http.target"])
+}
+
+func (suite *TraceSuite) TestServerMiddlewareNewTrace() {
+	var e testSpanExporter
+	seen := suite.serve(TraceConfig{SampleRatio: 1.0}, &e, http.Header{TraceParentHeader: {"garbage"}})
+	suite.True(seen.IsValid())
+	suite.True(seen.Sampled())
+
+	spans := e.Spans()
+	suite.Require().Len(spans, 1)
+	suite.False(spans[0].ParentSpanID.IsValid())
+}
+
+func (suite *TraceSuite) TestServerMiddlewareNewTraceNotSampled() {
+	var e testSpanExporter
+	seen := suite.serve(TraceConfig{}, &e, nil)
+	suite.True(seen.IsValid())
+	suite.False(seen.Sampled())
+	suite.Empty(e.Spans())
+}
+
+func (suite *TraceSuite) TestSampleRatio() {
+	const traces = 10000
+	sampled := func(tc TraceConfig) (count int) {
+		for range traces {
+			if tc.sampled(newTraceID()) {
+				count++
+			}
+		}
+
+		return
+	}
+
+	suite.Zero(sampled(TraceConfig{}))
+	suite.Equal(traces, sampled(TraceConfig{SampleRatio: 1.0}))
+	suite.Equal(traces, sampled(TraceConfig{SampleRatio: 2.0}))
+	suite.InDelta(traces/4, sampled(TraceConfig{SampleRatio: 0.25}), traces/20)
+
+	// the decision is consistent for a trace
+	id := newTraceID()
+	tc := TraceConfig{SampleRatio: 0.5}
+	suite.Equal(tc.sampled(id), tc.sampled(id))
+}
+
+func (suite *TraceSuite) TestServerMiddlewareNotSampled() {
+	var e testSpanExporter
+	seen := suite.serve(TraceConfig{SampleRatio: 1.0}, &e, http.Header{
+		TraceParentHeader: {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+	})
+
+	suite.False(seen.Sampled())
+	suite.Empty(e.Spans())
+
+	// no exporter at all
+	suite.True(suite.serve(TraceConfig{}, nil, nil).IsValid())
+}
+
+func (suite *TraceSuite) TestClientMiddleware() {
+	var (
+		e    testSpanExporter
+		seen []string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, request *http.Request) {
+		seen = append(seen, request.Header.Get(TraceParentHeader))
+	}))
+
+	defer server.Close()
+	c := &http.Client{
+		Transport: TraceConfig{}.ClientMiddleware(&e)(nil),
+	}
+
+	response, err := c.Get(server.URL)
+	suite.Require().NoError(err)
+	response.Body.Close()
+
+	parent, _ := ParseTraceParent(testTraceParent)
+	request, err := http.NewRequestWithContext(WithSpanContext(context.Background(), parent), "GET", server.URL, nil)
+	suite.Require().NoError(err)
+	response, err = c.Do(request)
+	suite.Require().NoError(err)
+	response.Body.Close()
+	suite.Empty(request.Header.Get(TraceParentHeader))
+
+	suite.Require().Len(seen, 2)
+	suite.Empty(seen[0])
+
+	child, err := ParseTraceParent(seen[1])
+	suite.Require().NoError(err)
+	suite.Equal(parent.TraceID, child.TraceID)
+	suite.NotEqual(parent.SpanID, child.SpanID)
+
+	spans := e.Spans()
+	suite.Require().Len(spans, 1)
+	suite.Equal(child.SpanID, spans[0].SpanID)
+	suite.Equal(parent.SpanID, spans[0].ParentSpanID)
+	suite.Equal(SpanKindClient, spans[0].Kind)
+	suite.Equal(http.StatusOK, spans[0].StatusCode)
+}
+
+func (suite *TraceSuite) TestClientMiddlewareError() {
+	var (
+		e           testSpanExporter
+		expectedErr = errors.New("expected")
+		c           = &http.Client{
+			Transport: TraceConfig{}.ClientMiddleware(&e)(
+				roundtrip.Func(func(*http.Request) (*http.Response, error) {
+					return nil, expectedErr
+				}),
+			),
+		}
+	)
+
+	parent, _ := ParseTraceParent(testTraceParent)
+	request, err := http.NewRequestWithContext(WithSpanContext(context.Background(), parent), "GET", "http://localhost", nil)
+	suite.Require().NoError(err)
+
+	_, err = c.Do(request)
+	suite.ErrorIs(err, expectedErr)
+
+	spans := e.Spans()
+	suite.Require().Len(spans, 1)
+	suite.Equal("expected", spans[0].Error)
+}
+
+func (suite *TraceSuite) TestProvide() {
+	var e testSpanExporter
+	upstream := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		io.WriteString(response, request.Header.Get(TraceParentHeader))
+	}))
+
+	defer upstream.Close()
+
+	var server *http.Server
+	app := arrangetest.NewApp(
+		suite,
+		fx.Supply(
+			fx.Annotate(&e, fx.As(new(SpanExporter))),
+			fx.Annotated{
+				Name: "server.config",
+				Target: ServerConfig{
+					Address: ":0",
+					Trace:   TraceConfig{Enabled: true},
+				},
+			},
+			fx.Annotated{
+				Name: "client.config",
+				Target: ClientConfig{
+					Trace: TraceConfig{Enabled: true},
+				},
+			},
+		),
+		fx.Provide(
+			fx.Annotate(
+				func(c *http.Client) http.Handler {
+					return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+						upstreamRequest, _ := http.NewRequestWithContext(request.Context(), "GET", upstream.URL, nil)
+						upstreamResponse, err := c.Do(upstreamRequest)
+						suite.Require().NoError(err)
+						defer upstreamResponse.Body.Close()
+						io.Copy(response, upstreamResponse.Body)
+					})
+				},
+				arrange.Tags().Name("client").ParamTags(),
+				arrange.Tags().Name("server.handler").ResultTags(),
+			),
+		),
+		ProvideServer("server"),
+		ProvideClient("client"),
+		fx.Populate(
+			fx.Annotate(
+				&server,
+				arrange.Tags().Name("server").ParamTags(),
+			),
+		),
+	)
+
+	app.RequireStart()
+	defer app.RequireStop()
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set(TraceParentHeader, testTraceParent)
+	response := httptest.NewRecorder()
+	server.Handler.ServeHTTP(response, request)
+
+	downstream, err := ParseTraceParent(response.Body.String())
+	suite.Require().NoError(err)
+
+	spans := e.Spans()
+	suite.Require().Len(spans, 2)
+	suite.Equal(SpanKindClient, spans[0].Kind)
+	suite.Equal(SpanKindServer, spans[1].Kind)
+	suite.Equal(downstream.SpanID, spans[0].SpanID)
+	suite.Equal(spans[1].SpanID, spans[0].ParentSpanID)
+	suite.Equal(downstream.TraceID, spans[1].TraceID)
+}
+
+func TestTrace(t *testing.T) {
+	suite.Run(t, new(TraceSuite))
+}